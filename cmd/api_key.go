@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang-example/database"
+	"golang-example/model"
+	"golang-example/utils"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	apiKeyName    string
+	apiKeyOwnerID uint
+	apiKeyScope   string
+)
+
+var apiKeyCMD = &cobra.Command{
+	Use:   "api-key",
+	Short: "API key related commands",
+}
+
+var createAPIKeyCMD = &cobra.Command{
+	Use:   "create",
+	Short: "create an API key and print it",
+	Run: func(cmd *cobra.Command, args []string) {
+		createAPIKey()
+	},
+}
+
+var listAPIKeysCMD = &cobra.Command{
+	Use:   "list",
+	Short: "list API keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		listAPIKeys()
+	},
+}
+
+var revokeAPIKeyCMD = &cobra.Command{
+	Use:   "revoke [id]",
+	Short: "revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revokeAPIKey(args[0])
+	},
+}
+
+func init() {
+	createAPIKeyCMD.Flags().StringVarP(&apiKeyName, "name", "n", "", "name of the API key")
+	createAPIKeyCMD.Flags().UintVarP(&apiKeyOwnerID, "owner", "o", 0, "id of the user the key acts as")
+	createAPIKeyCMD.Flags().StringVarP(&apiKeyScope, "scope", "s", "", "space separated list of scopes")
+	listAPIKeysCMD.Flags().UintVarP(&apiKeyOwnerID, "owner", "o", 0, "only list keys of this user")
+
+	apiKeyCMD.AddCommand(createAPIKeyCMD)
+	apiKeyCMD.AddCommand(listAPIKeysCMD)
+	apiKeyCMD.AddCommand(revokeAPIKeyCMD)
+}
+
+func createAPIKey() {
+	if apiKeyName == "" || apiKeyOwnerID == 0 {
+		log.Fatal("name and owner are required")
+	}
+
+	scopes := model.ParseScopes(apiKeyScope)
+	if len(scopes) == 0 {
+		log.Fatal("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if _, ok := model.ScopesMap[scope]; !ok {
+			log.Fatalf("invalid scope: %s", scope)
+		}
+	}
+
+	db := database.InitDatabase()
+	if err := db.Where(model.User{ID: apiKeyOwnerID}).First(&model.User{}).Error; err != nil {
+		log.Fatalf("cannot find owner: %s", err)
+	}
+
+	key, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiKey := model.APIKey{
+		Name:    apiKeyName,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  scopes,
+		OwnerID: apiKeyOwnerID,
+	}
+	if err = db.Create(&apiKey).Error; err != nil {
+		log.Fatalf("error in creating API key: %s", err)
+	}
+
+	log.Infof("API key [%d] created, it is shown only once", apiKey.ID)
+	fmt.Println(key)
+}
+
+func listAPIKeys() {
+	db := database.InitDatabase()
+
+	query := db.Order("id")
+	if apiKeyOwnerID != 0 {
+		query = query.Where("owner_id = ?", apiKeyOwnerID)
+	}
+
+	var apiKeys []model.APIKey
+	if err := query.Find(&apiKeys).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tOWNER\tSCOPE\tREVOKED AT")
+	for _, apiKey := range apiKeys {
+		revokedAt := "-"
+		if apiKey.RevokedAt != nil {
+			revokedAt = apiKey.RevokedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", apiKey.ID, apiKey.Name, apiKey.Prefix, apiKey.OwnerID,
+			strings.Join(model.ScopeStrings(apiKey.Scopes), " "), revokedAt)
+	}
+	_ = w.Flush()
+}
+
+func revokeAPIKey(arg string) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid API key id: %s", arg)
+	}
+
+	db := database.InitDatabase()
+	result := db.Model(&model.APIKey{}).
+		Where("id = ?", id).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		log.Fatal(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		log.Fatalf("API key [%d] not found", id)
+	}
+
+	log.Infof("API key [%d] revoked", id)
+}
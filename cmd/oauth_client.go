@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang-example/database"
+	"golang-example/model"
+	"golang-example/utils"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	oauthClientName    string
+	oauthClientOwnerID uint
+	oauthClientScope   string
+)
+
+var oauthClientCMD = &cobra.Command{
+	Use:   "oauth-client",
+	Short: "OAuth client related commands",
+}
+
+var createOAuthClientCMD = &cobra.Command{
+	Use:   "create",
+	Short: "register an OAuth client and print its credentials",
+	Run: func(cmd *cobra.Command, args []string) {
+		createOAuthClient()
+	},
+}
+
+var listOAuthClientsCMD = &cobra.Command{
+	Use:   "list",
+	Short: "list OAuth clients",
+	Run: func(cmd *cobra.Command, args []string) {
+		listOAuthClients()
+	},
+}
+
+var revokeOAuthClientCMD = &cobra.Command{
+	Use:   "revoke [id]",
+	Short: "revoke an OAuth client",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revokeOAuthClient(args[0])
+	},
+}
+
+func init() {
+	createOAuthClientCMD.Flags().StringVarP(&oauthClientName, "name", "n", "", "name of the client")
+	createOAuthClientCMD.Flags().UintVarP(&oauthClientOwnerID, "owner", "o", 0, "id of the user the client acts as")
+	createOAuthClientCMD.Flags().StringVarP(&oauthClientScope, "scope", "s", "", "space separated list of scopes the client may request")
+	listOAuthClientsCMD.Flags().UintVarP(&oauthClientOwnerID, "owner", "o", 0, "only list clients of this user")
+
+	oauthClientCMD.AddCommand(createOAuthClientCMD)
+	oauthClientCMD.AddCommand(listOAuthClientsCMD)
+	oauthClientCMD.AddCommand(revokeOAuthClientCMD)
+}
+
+func createOAuthClient() {
+	if oauthClientName == "" || oauthClientOwnerID == 0 {
+		log.Fatal("name and owner are required")
+	}
+
+	scopes := model.ParseScopes(oauthClientScope)
+	if len(scopes) == 0 {
+		log.Fatal("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if _, ok := model.ScopesMap[scope]; !ok {
+			log.Fatalf("invalid scope: %s", scope)
+		}
+	}
+
+	db := database.InitDatabase()
+	if err := db.Where(model.User{ID: oauthClientOwnerID}).First(&model.User{}).Error; err != nil {
+		log.Fatalf("cannot find owner: %s", err)
+	}
+
+	clientID, secret, hash, err := utils.GenerateClientCredentials()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := model.OAuthClient{
+		ClientID:   clientID,
+		SecretHash: hash,
+		Name:       oauthClientName,
+		Scopes:     scopes,
+		OwnerID:    oauthClientOwnerID,
+	}
+	if err = db.Create(&client).Error; err != nil {
+		log.Fatalf("error in creating OAuth client: %s", err)
+	}
+
+	log.Infof("OAuth client [%d] created, the secret is shown only once", client.ID)
+	fmt.Printf("client_id: %s\nclient_secret: %s\n", clientID, secret)
+}
+
+func listOAuthClients() {
+	db := database.InitDatabase()
+
+	query := db.Order("id")
+	if oauthClientOwnerID != 0 {
+		query = query.Where("owner_id = ?", oauthClientOwnerID)
+	}
+
+	var clients []model.OAuthClient
+	if err := query.Find(&clients).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCLIENT ID\tOWNER\tSCOPE\tREVOKED AT")
+	for _, client := range clients {
+		revokedAt := "-"
+		if client.RevokedAt != nil {
+			revokedAt = client.RevokedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", client.ID, client.Name, client.ClientID, client.OwnerID,
+			strings.Join(model.ScopeStrings(client.Scopes), " "), revokedAt)
+	}
+	_ = w.Flush()
+}
+
+func revokeOAuthClient(arg string) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid OAuth client id: %s", arg)
+	}
+
+	db := database.InitDatabase()
+	result := db.Model(&model.OAuthClient{}).
+		Where("id = ?", id).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		log.Fatal(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		log.Fatalf("OAuth client [%d] not found", id)
+	}
+
+	log.Infof("OAuth client [%d] revoked", id)
+}
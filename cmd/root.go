@@ -25,6 +25,9 @@ func init() {
 
 	rootCMD.AddCommand(serveCMD)
 	rootCMD.AddCommand(databaseCMD)
+	rootCMD.AddCommand(apiKeyCMD)
+	rootCMD.AddCommand(oauthClientCMD)
+	rootCMD.AddCommand(userCMD)
 }
 
 func Execute() {
@@ -6,21 +6,32 @@ import (
 	"golang-example/config"
 	"golang-example/database"
 	"golang-example/model"
-	"golang.org/x/crypto/bcrypt"
+	"golang-example/utils"
 	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
 
+var seedAdmin bool
+
 var seedDatabaseCMD = &cobra.Command{
 	Use:   "seed",
 	Short: "seed database with data",
+	Long: `Seed database with data.
+
+Creates the users user001, user002, ... with the passwords password001,
+password002, ... With --admin user001 is made an admin, only use it where
+nobody else can log in.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		seedDB()
 	},
 }
 
+func init() {
+	seedDatabaseCMD.Flags().BoolVar(&seedAdmin, "admin", false, "make user001 an admin")
+}
+
 func seedDB() {
 	if !(host == "" && port == "" && db == "" && user == "") {
 		fmt.Print("Password: ")
@@ -45,7 +56,7 @@ func seedDB() {
 	}
 
 	log.Info("Creating users")
-	users := createMockUsers(3)
+	users := createMockUsers(3, seedAdmin)
 	err := db.CreateInBatches(users, 6).Error
 	if err != nil {
 		log.Fatal(err)
@@ -59,17 +70,22 @@ func seedDB() {
 	}
 }
 
-func createMockUsers(n int) []*model.User {
+func createMockUsers(n int, admin bool) []*model.User {
 	users := make([]*model.User, 0, n)
 
 	for i := 1; i < n+1; i++ {
-		hashedPass, _ := bcrypt.GenerateFromPassword([]byte(fmt.Sprintf("password%03d", i)), bcrypt.DefaultCost)
+		hashedPass, _ := utils.HashPassword(fmt.Sprintf("password%03d", i))
 		u := &model.User{
 			UserName:  fmt.Sprintf("user%03d", i),
-			Password:  string(hashedPass),
+			Password:  hashedPass,
+			Roles:     model.Roles{model.RoleUser},
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
+		if i == 1 && admin {
+			log.Warn("Making user001 an admin")
+			u.Roles = append(u.Roles, model.RoleAdmin)
+		}
 		users = append(users, u)
 	}
 
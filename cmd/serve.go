@@ -1,14 +1,26 @@
 package cmd
 
 import (
+	"context"
 	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang-example/config"
 	"golang-example/controller"
 	"golang-example/database"
 	"golang-example/middleware"
+	"golang-example/model"
+	"golang-example/utils"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout is how long requests in flight get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 var serveCMD = &cobra.Command{
 	Use:   "serve",
 	Short: "serve API",
@@ -18,22 +30,107 @@ var serveCMD = &cobra.Command{
 }
 
 func serve() {
+	if err := utils.InitKeys(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := utils.InitSecretKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := utils.InitOIDCProviders(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := utils.InitBreachedPasswords(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := utils.InitMetaKeys(); err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := utils.NewMailer(config.C.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db := database.InitDatabase()
 
 	redis := database.InitRedis()
 	defer database.CloseRedis(redis)
 
 	e := echo.New()
+	e.IPExtractor, err = middleware.IPExtractor()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	userController := controller.User{DB: db}
+	userController := controller.User{DB: db, Redis: redis, Mailer: mailer}
 	userMetaController := controller.UserMeta{DB: db}
+	tokenController := controller.Token{DB: db, Redis: redis}
+	adminController := controller.Admin{DB: db, Redis: redis}
+	apiKeyController := controller.APIKey{DB: db}
+	oauthController := controller.OAuth{DB: db, Redis: redis}
+	oidcController := controller.OIDC{DB: db, Redis: redis}
+	mfaController := controller.MFA{DB: db, Redis: redis}
+	passwordController := controller.Password{DB: db, Redis: redis, Mailer: mailer}
+	emailVerificationController := controller.EmailVerification{DB: db, Redis: redis, Mailer: mailer}
+	sessionController := controller.Session{Redis: redis}
 
-	e.POST("/signup", userController.Signup)
-	e.POST("/login", userController.Login)
+	e.POST("/signup", userController.Signup, middleware.RateLimit(redis, "signup"))
+	e.POST("/login", userController.Login, middleware.RateLimit(redis, "login"))
+	e.POST("/login/mfa", mfaController.Login, middleware.RateLimit(redis, "login_mfa"))
+	e.POST("/password/forgot", passwordController.Forgot, middleware.RateLimit(redis, "password_forgot"))
+	e.POST("/password/reset", passwordController.Reset, middleware.RateLimit(redis, "password_reset"))
+	e.GET("/email/verify", emailVerificationController.VerifyLink)
+	e.POST("/email/verify", emailVerificationController.VerifyCode, middleware.RateLimit(redis, "email_verify"))
+	e.POST("/email/verify/resend", emailVerificationController.Resend, middleware.RateLimit(redis, "email_verify"))
+	e.POST("/token/refresh", tokenController.Refresh, middleware.CSRFProtected())
+	e.POST("/logout", tokenController.Logout, middleware.UserAuthorized(redis), middleware.CSRFProtected())
+	e.GET("/.well-known/jwks.json", tokenController.JWKS)
+	e.POST("/oauth/token", oauthController.Token, middleware.RateLimit(redis, "oauth_token"))
+	e.POST("/oauth/introspect", oauthController.Introspect)
+	e.GET("/auth/:provider/login", oidcController.Login)
+	e.GET("/auth/:provider/callback", oidcController.Callback)
 
-	e.PUT("/metas", userMetaController.Update, middleware.UserAuthorized(), middleware.Lock(redis))
-	e.GET("/metas", userMetaController.Get, middleware.UserAuthorized())
+	e.PUT("/me/password", passwordController.Change, middleware.UserAuthorized(redis), middleware.CSRFProtected())
+	e.POST("/me/mfa/enroll", mfaController.Enroll, middleware.UserAuthorized(redis), middleware.CSRFProtected())
+	e.POST("/me/mfa/confirm", mfaController.Confirm, middleware.UserAuthorized(redis), middleware.CSRFProtected())
+	e.POST("/me/mfa/disable", mfaController.Disable, middleware.UserAuthorized(redis), middleware.CSRFProtected())
+	e.GET("/me/sessions", sessionController.List, middleware.UserAuthorized(redis))
+	e.DELETE("/me/sessions/:id", sessionController.Revoke, middleware.UserAuthorized(redis), middleware.CSRFProtected())
+
+	e.PUT("/metas", userMetaController.Update, middleware.UserOrAPIKeyAuthorized(redis, db), middleware.CSRFProtected(), middleware.RateLimit(redis, "metas"), middleware.RequireScope(model.ScopeMetasWrite), middleware.RequireVerifiedEmail(db, config.EmailVerificationPolicyMetaUpdates), middleware.Lock(redis))
+	e.DELETE("/metas", userMetaController.Delete, middleware.UserOrAPIKeyAuthorized(redis, db), middleware.CSRFProtected(), middleware.RateLimit(redis, "metas"), middleware.RequireScope(model.ScopeMetasWrite), middleware.RequireVerifiedEmail(db, config.EmailVerificationPolicyMetaUpdates), middleware.Lock(redis))
+	e.GET("/metas", userMetaController.Get, middleware.UserOrAPIKeyAuthorized(redis, db), middleware.RateLimit(redis, "metas"), middleware.RequireScope(model.ScopeMetasRead))
+	e.GET("/metas/history", userMetaController.History, middleware.UserOrAPIKeyAuthorized(redis, db), middleware.RateLimit(redis, "metas"), middleware.RequireScope(model.ScopeMetasRead))
+
+	admin := e.Group("/admin", middleware.UserAuthorized(redis), middleware.CSRFProtected(), middleware.RequireRole(model.RoleAdmin))
+	admin.PUT("/users/:id/roles", adminController.UpdateUserRoles)
+	admin.POST("/users/:id/unlock", adminController.UnlockUser)
+	admin.POST("/api-keys", apiKeyController.Create)
+	admin.GET("/api-keys", apiKeyController.List)
+	admin.DELETE("/api-keys/:id", apiKeyController.Revoke)
 
 	// Start server
-	e.Logger.Fatal(e.Start(config.C.Address))
+	go func() {
+		if err := e.Start(config.C.Address); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Error(err)
+	}
+
+	// Reset mails are sent after responding, don't drop the ones in flight.
+	passwordController.Wait()
 }
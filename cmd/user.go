@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"golang-example/database"
+	"golang-example/model"
+	"golang-example/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var userCMD = &cobra.Command{
+	Use:   "user",
+	Short: "User related commands",
+}
+
+var unlockUserCMD = &cobra.Command{
+	Use:   "unlock [username]",
+	Short: "lift the login lockout of a user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		unlockUser(args[0])
+	},
+}
+
+func init() {
+	userCMD.AddCommand(unlockUserCMD)
+}
+
+func unlockUser(userName string) {
+	db := database.InitDatabase()
+	if err := db.Where(model.User{UserName: userName}).First(&model.User{}).Error; err != nil {
+		log.Fatalf("cannot find user: %s", err)
+	}
+
+	redis := database.InitRedis()
+	defer database.CloseRedis(redis)
+
+	if err := utils.UnlockLogin(context.Background(), redis, userName); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Infof("user [%s] unlocked", userName)
+}
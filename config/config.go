@@ -21,6 +21,7 @@ var (
 )
 
 var builtinConfig = []byte(`address: 0.0.0.0:8080
+trusted_proxies: []
 database:
   driver: mysql
   host: localhost
@@ -45,21 +46,371 @@ redis:
   write_timeout: 3s
 token:
   expires_in: 5m
+  refresh_expires_in: 720h
   secret: secret
+  signing_key_id: ''
+  keys: []
+oidc:
+  state_ttl: 10m
+  providers: []
+session:
+  cookie_mode: false
+  cookie_name: access_token
+  refresh_cookie_name: refresh_token
+  csrf_cookie_name: csrf_token
+  csrf_header: X-CSRF-Token
+  domain: ''
+  secure: true
+  same_site: lax
+mfa:
+  issuer: golang-example
+  encryption_key: ''
+  pending_token_ttl: 5m
+  max_attempts: 5
+  user_max_attempts: 10
+  lock_duration: 15m
+mail:
+  driver: file
+  from: no-reply@localhost
+  file_path: ''
+  smtp:
+    host: localhost
+    port: 587
+    username: ''
+    password: ''
+password_reset:
+  token_ttl: 30m
+  url: http://localhost:3000/reset-password
+email_verification:
+  policy: none
+  url: http://localhost:3000/verify-email
+  link_ttl: 24h
+  code_ttl: 15m
+  max_code_attempts: 5
+  resend_interval: 1m
+  max_resends: 5
+  resend_window: 1h
+  claim_ttl: 72h
+login_lockout:
+  max_attempts: 5
+  ip_max_attempts: 20
+  window: 15m
+  base_duration: 1m
+  max_duration: 1h
+password_policy:
+  min_length: 8
+  max_length: 128
+  require_lower: true
+  require_upper: true
+  require_digit: true
+  require_special: true
+  special_characters: ''
+  min_score: 2
+  breached_list: ''
+password_history:
+  size: 5
+password_hash:
+  algorithm: argon2id
+  bcrypt_cost: 10
+  argon2id:
+    memory: 65536
+    iterations: 3
+    parallelism: 2
+    salt_length: 16
+    key_length: 32
+rate_limits:
+  signup:
+    key: ip
+    limit: 5
+    period: 1h
+  login:
+    key: ip
+    limit: 20
+    period: 1m
+  password_forgot:
+    key: ip
+    limit: 5
+    period: 1h
+  oauth_token:
+    key: ip
+    limit: 60
+    period: 1m
+  login_mfa:
+    key: ip
+    limit: 20
+    period: 1m
+  password_reset:
+    key: ip
+    limit: 10
+    period: 1h
+  email_verify:
+    key: ip
+    limit: 20
+    period: 1h
+  metas:
+    key: user_id
+    limit: 60
+    period: 1m
 loc_ttl: 30s
+hardened_mode: false
+meta_keys:
+  age:
+    type: int
+    min: '1'
+  gender:
+    type: enum
+    values: [male, female, none]
 `)
 
 type Config struct {
-	Address  string        `yaml:"address"`
-	Database SQLDatabase   `yaml:"database"`
-	Redis    Redis         `yaml:"redis"`
-	Token    Token         `yaml:"token"`
-	LockTTL  time.Duration `yaml:"loc_ttl"`
+	Address           string            `yaml:"address"`
+	Database          SQLDatabase       `yaml:"database"`
+	Redis             Redis             `yaml:"redis"`
+	Token             Token             `yaml:"token"`
+	OIDC              OIDC              `yaml:"oidc"`
+	Session           Session           `yaml:"session"`
+	MFA               MFA               `yaml:"mfa"`
+	Mail              Mail              `yaml:"mail"`
+	PasswordReset     PasswordReset     `yaml:"password_reset"`
+	EmailVerification EmailVerification `yaml:"email_verification"`
+	LoginLockout      LoginLockout      `yaml:"login_lockout"`
+	RateLimits        RateLimits        `yaml:"rate_limits"`
+	PasswordHash      PasswordHash      `yaml:"password_hash"`
+	PasswordPolicy    PasswordPolicy    `yaml:"password_policy"`
+	PasswordHistory   PasswordHistory   `yaml:"password_history"`
+	LockTTL           time.Duration     `yaml:"loc_ttl"`
+	// HardenedMode keeps signup and login from telling which usernames
+	// exist, by their responses or their timing. Signup needs an email then,
+	// as that is where the outcome is sent.
+	HardenedMode bool     `yaml:"hardened_mode"`
+	MetaKeys     MetaKeys `yaml:"meta_keys"`
+	// TrustedProxies are the CIDRs of the proxies in front of the service.
+	// The client IP is only read from X-Forwarded-For behind one of them.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 type Token struct {
-	ExpiresIn time.Duration `yaml:"expires_in"`
-	Secret    string        `yaml:"secret"`
+	ExpiresIn        time.Duration `yaml:"expires_in"`
+	RefreshExpiresIn time.Duration `yaml:"refresh_expires_in"`
+	Secret           string        `yaml:"secret"`
+	SigningKeyID     string        `yaml:"signing_key_id"`
+	Keys             []TokenKey    `yaml:"keys"`
+}
+
+// TokenKey is a PEM encoded asymmetric key used to sign or verify tokens.
+// Keys without a private key are only used for verification, which allows
+// rotated out keys to stay valid until their tokens expire.
+type TokenKey struct {
+	ID             string `yaml:"id"`
+	Algorithm      string `yaml:"algorithm"`
+	PrivateKeyPath string `yaml:"private_key_path"`
+	PublicKeyPath  string `yaml:"public_key_path"`
+}
+
+type OIDC struct {
+	StateTTL  time.Duration  `yaml:"state_ttl"`
+	Providers []OIDCProvider `yaml:"providers"`
+}
+
+// OIDCProvider is an OpenID Connect provider users can sign in with. Its
+// endpoints and keys are discovered from the issuer.
+type OIDCProvider struct {
+	Name         string   `yaml:"name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Session configures the cookie mode for browser clients. When enabled the
+// tokens are handed out as HttpOnly cookies instead of in the response body,
+// and requests authenticated by cookie need a double-submit CSRF token.
+type Session struct {
+	CookieMode        bool   `yaml:"cookie_mode"`
+	CookieName        string `yaml:"cookie_name"`
+	RefreshCookieName string `yaml:"refresh_cookie_name"`
+	CSRFCookieName    string `yaml:"csrf_cookie_name"`
+	CSRFHeader        string `yaml:"csrf_header"`
+	Domain            string `yaml:"domain"`
+	Secure            bool   `yaml:"secure"`
+	SameSite          string `yaml:"same_site"`
+}
+
+// MFA configures TOTP two-factor authentication. EncryptionKey is a base64
+// encoded 32 byte key the TOTP secrets are encrypted with at rest, it has no
+// default and serve doesn't start without one. After
+// UserMaxAttempts failed second factors of a user within LockDuration, no
+// more are checked for LockDuration. A zero UserMaxAttempts turns it off.
+type MFA struct {
+	Issuer          string        `yaml:"issuer"`
+	EncryptionKey   string        `yaml:"encryption_key"`
+	PendingTokenTTL time.Duration `yaml:"pending_token_ttl"`
+	MaxAttempts     int64         `yaml:"max_attempts"`
+	UserMaxAttempts int64         `yaml:"user_max_attempts"`
+	LockDuration    time.Duration `yaml:"lock_duration"`
+}
+
+// Mail configures how mail is sent. The smtp driver sends it through the
+// SMTP server, the file driver appends it to FilePath, or writes it to the
+// log when no path is set, which is handy in development and tests.
+type Mail struct {
+	Driver   string `yaml:"driver"`
+	From     string `yaml:"from"`
+	FilePath string `yaml:"file_path"`
+	SMTP     SMTP   `yaml:"smtp"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// PasswordReset configures the reset mails. URL is the page of the client
+// the reset token is appended to.
+type PasswordReset struct {
+	TokenTTL time.Duration `yaml:"token_ttl"`
+	URL      string        `yaml:"url"`
+}
+
+const (
+	EmailVerificationPolicyNone        = "none"
+	EmailVerificationPolicyLogin       = "login"
+	EmailVerificationPolicyMetaUpdates = "meta_updates"
+)
+
+// EmailVerification configures how emails given at signup are verified.
+// Policy decides what users with an unverified email can't do: nothing is
+// blocked with "none", "login" blocks password logins and "meta_updates"
+// blocks changing metas. With any policy other than "none" signup requires
+// an email. Resends are limited to one per ResendInterval and MaxResends per
+// ResendWindow for each email. An unverified email only keeps others from
+// signing up with it for ClaimTTL after the signup of its account, then the
+// next signup with it takes it over. Zero keeps it for good.
+type EmailVerification struct {
+	Policy          string        `yaml:"policy"`
+	URL             string        `yaml:"url"`
+	LinkTTL         time.Duration `yaml:"link_ttl"`
+	CodeTTL         time.Duration `yaml:"code_ttl"`
+	MaxCodeAttempts int64         `yaml:"max_code_attempts"`
+	ResendInterval  time.Duration `yaml:"resend_interval"`
+	MaxResends      int64         `yaml:"max_resends"`
+	ResendWindow    time.Duration `yaml:"resend_window"`
+	ClaimTTL        time.Duration `yaml:"claim_ttl"`
+}
+
+// EmailRequired reports whether signup needs an email.
+func (e EmailVerification) EmailRequired() bool {
+	return e.Policy != "" && e.Policy != EmailVerificationPolicyNone
+}
+
+// LoginLockout configures the brute-force protection of password logins.
+// After MaxAttempts failures for a username, or IPMaxAttempts failures from
+// a client IP, within Window the username or IP is locked. The first lock
+// lasts BaseDuration and every following one twice the previous, up to
+// MaxDuration. A zero number of attempts turns the check off.
+type LoginLockout struct {
+	MaxAttempts   int64         `yaml:"max_attempts"`
+	IPMaxAttempts int64         `yaml:"ip_max_attempts"`
+	Window        time.Duration `yaml:"window"`
+	BaseDuration  time.Duration `yaml:"base_duration"`
+	MaxDuration   time.Duration `yaml:"max_duration"`
+}
+
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyUserID = "user_id"
+	RateLimitKeyAPIKey = "api_key"
+)
+
+// RateLimits holds the rate limit of each limited route by its name.
+type RateLimits map[string]RateLimit
+
+// RateLimit allows Limit requests per Period for every value of Key, which
+// is the client IP, the user id or the API key of the request. Requests can
+// come in a burst of up to Limit, after that they are spread evenly over
+// the period. A zero Limit turns the check off.
+type RateLimit struct {
+	Key    string        `yaml:"key"`
+	Limit  int64         `yaml:"limit"`
+	Period time.Duration `yaml:"period"`
+}
+
+const (
+	MetaTypeInt    = "int"
+	MetaTypeString = "string"
+	MetaTypeEnum   = "enum"
+	MetaTypeBool   = "bool"
+	MetaTypeDate   = "date"
+)
+
+// MetaKeys defines the user meta keys by their name. Names are lower case.
+// Without any the original age and gender keys are used.
+type MetaKeys map[string]MetaKey
+
+// MetaKey is what the values of a user meta key may be. Min and Max bound
+// int and date values and are written like a value, e.g. '1' or
+// '1900-01-01'. Pattern is a regular expression string values have to
+// match and Values are the choices of an enum. MaxLength limits the length
+// of any value. Default is returned for users who never set the key.
+type MetaKey struct {
+	Type      string   `yaml:"type"`
+	Min       string   `yaml:"min"`
+	Max       string   `yaml:"max"`
+	MaxLength int      `yaml:"max_length"`
+	Pattern   string   `yaml:"pattern"`
+	Values    []string `yaml:"values"`
+	Default   string   `yaml:"default"`
+}
+
+// PasswordPolicy is what new passwords have to satisfy. With an empty
+// SpecialCharacters any punctuation or symbol counts as special. MinScore is
+// the least strength score from 0 to 4, zero skips the check. BreachedList
+// is a file of SHA-1 hashes of breached passwords, one per line with an
+// optional ":count" as in the Have I Been Pwned downloads. Every rule left
+// out keeps its original value: 8 characters with a lower and upper case
+// letter, a digit and one of !@#$%^&*.?-.
+type PasswordPolicy struct {
+	MinLength         int     `yaml:"min_length"`
+	MaxLength         int     `yaml:"max_length"`
+	RequireLower      *bool   `yaml:"require_lower"`
+	RequireUpper      *bool   `yaml:"require_upper"`
+	RequireDigit      *bool   `yaml:"require_digit"`
+	RequireSpecial    *bool   `yaml:"require_special"`
+	SpecialCharacters *string `yaml:"special_characters"`
+	MinScore          int     `yaml:"min_score"`
+	BreachedList      string  `yaml:"breached_list"`
+}
+
+// PasswordHistory is how many of the latest passwords of a user, the
+// current one included, can't be chosen again. Zero allows any.
+type PasswordHistory struct {
+	Size int `yaml:"size"`
+}
+
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// PasswordHash configures how new passwords are hashed. Stored hashes made
+// with other settings keep working and are rehashed on the next login.
+// Without an algorithm bcrypt is used.
+type PasswordHash struct {
+	Algorithm  string   `yaml:"algorithm"`
+	BcryptCost int      `yaml:"bcrypt_cost"`
+	Argon2id   Argon2id `yaml:"argon2id"`
+}
+
+// Argon2id holds the argon2id parameters, Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32 `yaml:"memory"`
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+	SaltLength  uint32 `yaml:"salt_length"`
+	KeyLength   uint32 `yaml:"key_length"`
 }
 
 func initViper(path string, c *Config) (*viper.Viper, error) {
@@ -118,7 +469,7 @@ func Init(path string) *Config {
 	c := Config{}
 	V, err = initViper(path, &c)
 	if err != nil {
-		log.Fatal("Failed on config initialization: %v", err)
+		log.Fatalf("Failed on config initialization: %v", err)
 	}
 
 	C = c
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"errors"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+type Admin struct {
+	DB    *gorm.DB
+	Redis *goredis.Client
+}
+
+type updateRolesReq struct {
+	UserID uint     `param:"id"`
+	Roles  []string `json:"roles"`
+}
+
+func (req *updateRolesReq) validate() error {
+	if len(req.Roles) == 0 {
+		return errors.New("at least one role is required")
+	}
+
+	for _, role := range req.Roles {
+		if _, ok := model.RolesMap[model.Role(role)]; !ok {
+			return errors.New("invalid role")
+		}
+	}
+
+	return nil
+}
+
+func (a *Admin) UpdateUserRoles(ctx echo.Context) error {
+	var req updateRolesReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var roles model.Roles
+	for _, role := range req.Roles {
+		roles = append(roles, model.Role(role))
+	}
+
+	result := a.DB.Model(&model.User{}).Where("id = ?", req.UserID).Update("roles", roles)
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	// tokens carry the roles, so the old ones must not outlive the change
+	err = utils.RevokeUserTokens(ctx.Request().Context(), a.Redis, req.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type unlockUserReq struct {
+	UserID uint `param:"id"`
+}
+
+// UnlockUser lifts the login lockout of the user before it runs out.
+func (a *Admin) UnlockUser(ctx echo.Context) error {
+	var req unlockUserReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	var user model.User
+	err = a.DB.Select("id", "user_name").Where(model.User{ID: req.UserID}).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	err = utils.UnlockLogin(ctx.Request().Context(), a.Redis, user.UserName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
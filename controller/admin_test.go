@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type UpdateUserRolesTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	endpoint    string
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	admin       Admin
+}
+
+func (suite *UpdateUserRolesTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.endpoint = "/admin/users/2/roles"
+	suite.ctx = context.Background()
+	suite.admin = Admin{DB: db, Redis: redisClient}
+}
+
+func (suite *UpdateUserRolesTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.admin.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *UpdateUserRolesTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *UpdateUserRolesTestSuite) CallHandler(requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPut, suite.endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	err := suite.admin.UpdateUserRoles(c)
+
+	return rec, err
+}
+
+func (suite *UpdateUserRolesTestSuite) TestUpdateUserRoles_Binding_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=error in parse request data"
+
+	requestBody := `{"roles:["admin"]}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UpdateUserRolesTestSuite) TestUpdateUserRoles_NoRoles_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=at least one role is required"
+
+	requestBody := `{"roles":[]}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UpdateUserRolesTestSuite) TestUpdateUserRoles_InvalidRole_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid role"
+
+	requestBody := `{"roles":["root"]}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UpdateUserRolesTestSuite) TestUpdateUserRoles_DBErr_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	syntax := "^UPDATE `users` SET `roles`=.+,`updated_at`=.+ WHERE id = .+"
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnError(errors.New("database err"))
+	suite.sqlMock.ExpectRollback()
+
+	requestBody := `{"roles":["admin"]}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UpdateUserRolesTestSuite) TestUpdateUserRoles_UserNotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=user not found"
+
+	syntax := "^UPDATE `users` SET `roles`=.+,`updated_at`=.+ WHERE id = .+"
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectCommit()
+
+	requestBody := `{"roles":["admin"]}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UpdateUserRolesTestSuite) TestUpdateUserRoles_Success() {
+	require := suite.Require()
+
+	syntax := "^UPDATE `users` SET `roles`=.+,`updated_at`=.+ WHERE id = .+"
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs("user,admin", sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	requestBody := `{"roles":["user","admin"]}`
+	response, err := suite.CallHandler(requestBody)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+
+	generation, err := suite.redisServer.Get("token_generation:2")
+	require.NoError(err)
+	require.Equal("1", generation)
+}
+
+func TestUpdateUserRoles(t *testing.T) {
+	suite.Run(t, new(UpdateUserRolesTestSuite))
+}
+
+type UnlockUserTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	admin       Admin
+}
+
+func (suite *UnlockUserTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.ctx = context.Background()
+	suite.admin = Admin{DB: db, Redis: redisClient}
+	config.C.LoginLockout = config.LoginLockout{MaxAttempts: 1, BaseDuration: time.Minute, MaxDuration: time.Hour, Window: time.Hour}
+}
+
+func (suite *UnlockUserTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+	config.C.LoginLockout = config.LoginLockout{}
+
+	sqlDB, _ := suite.admin.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *UnlockUserTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *UnlockUserTestSuite) CallHandler() (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/2/unlock", nil)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	err := suite.admin.UnlockUser(c)
+
+	return rec, err
+}
+
+func (suite *UnlockUserTestSuite) TestUnlockUser_NotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=user not found"
+
+	syntax := "^SELECT `id`,`user_name` FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(2).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := suite.CallHandler()
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UnlockUserTestSuite) TestUnlockUser_Success() {
+	require := suite.Require()
+
+	lockedFor, err := utils.RecordLoginFailure(suite.ctx, suite.admin.Redis, "username", "")
+	require.NoError(err)
+	require.Equal(time.Minute, lockedFor)
+
+	syntax := "^SELECT `id`,`user_name` FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_name"}).AddRow(2, "username"))
+
+	response, err := suite.CallHandler()
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+
+	lockedFor, err = utils.LoginLockedFor(suite.ctx, suite.admin.Redis, "username", "")
+	require.NoError(err)
+	require.Zero(lockedFor)
+}
+
+func TestUnlockUser(t *testing.T) {
+	suite.Run(t, new(UnlockUserTestSuite))
+}
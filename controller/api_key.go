@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type APIKey struct {
+	DB *gorm.DB
+}
+
+type createAPIKeyReq struct {
+	Name    string `json:"name"`
+	OwnerID uint   `json:"owner_id"`
+	Scope   string `json:"scope"`
+}
+
+func (req *createAPIKeyReq) validate() error {
+	if req.Name == "" || len(req.Name) > 255 {
+		return errors.New("name is invalid")
+	}
+
+	if req.OwnerID == 0 {
+		return errors.New("owner is required")
+	}
+
+	scopes := model.ParseScopes(req.Scope)
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if _, ok := model.ScopesMap[scope]; !ok {
+			return errors.New("invalid scope")
+		}
+	}
+
+	return nil
+}
+
+type apiKeyRes struct {
+	ID        uint       `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key,omitempty"`
+	OwnerID   uint       `json:"owner_id"`
+	Scope     string     `json:"scope"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func newAPIKeyRes(apiKey model.APIKey) apiKeyRes {
+	return apiKeyRes{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		OwnerID:   apiKey.OwnerID,
+		Scope:     strings.Join(model.ScopeStrings(apiKey.Scopes), " "),
+		CreatedAt: apiKey.CreatedAt,
+		RevokedAt: apiKey.RevokedAt,
+	}
+}
+
+func (a *APIKey) Create(ctx echo.Context) error {
+	var req createAPIKeyReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = a.DB.Where(model.User{ID: req.OwnerID}).First(&model.User{}).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	key, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	apiKey := model.APIKey{
+		Name:    req.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  model.ParseScopes(req.Scope),
+		OwnerID: req.OwnerID,
+	}
+	if err = a.DB.Create(&apiKey).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	res := newAPIKeyRes(apiKey)
+	res.Key = key
+
+	return ctx.JSON(http.StatusCreated, res)
+}
+
+type listAPIKeysReq struct {
+	OwnerID uint `query:"owner_id"`
+}
+
+func (a *APIKey) List(ctx echo.Context) error {
+	var req listAPIKeysReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	query := a.DB.Order("id")
+	if req.OwnerID != 0 {
+		query = query.Where("owner_id = ?", req.OwnerID)
+	}
+
+	var apiKeys []model.APIKey
+	if err = query.Find(&apiKeys).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	response := make([]apiKeyRes, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		response = append(response, newAPIKeyRes(apiKey))
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+type revokeAPIKeyReq struct {
+	ID uint `param:"id"`
+}
+
+func (a *APIKey) Revoke(ctx echo.Context) error {
+	var req revokeAPIKeyReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	result := a.DB.Model(&model.APIKey{}).
+		Where("id = ?", req.ID).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "api key not found")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/database"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type APIKeyTestSuite struct {
+	suite.Suite
+	e       *echo.Echo
+	sqlMock sqlmock.Sqlmock
+	apiKey  APIKey
+}
+
+func (suite *APIKeyTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	suite.e = echo.New()
+	suite.apiKey = APIKey{DB: db}
+}
+
+func (suite *APIKeyTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.apiKey.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *APIKeyTestSuite) newContext(method, target, requestBody string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	return suite.e.NewContext(req, rec), rec
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_Create_Validation_Failure() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		requestBody   string
+		expectedError string
+	}{
+		"Binding": {
+			requestBody:   `{"name:"job"}`,
+			expectedError: "code=400, message=error in parse request data",
+		},
+		"Missing name": {
+			requestBody:   `{"owner_id":1,"scope":"metas:read"}`,
+			expectedError: "code=400, message=name is invalid",
+		},
+		"Missing owner": {
+			requestBody:   `{"name":"job","scope":"metas:read"}`,
+			expectedError: "code=400, message=owner is required",
+		},
+		"Missing scope": {
+			requestBody:   `{"name":"job","owner_id":1}`,
+			expectedError: "code=400, message=at least one scope is required",
+		},
+		"Invalid scope": {
+			requestBody:   `{"name":"job","owner_id":1,"scope":"metas:read admin"}`,
+			expectedError: "code=400, message=invalid scope",
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			c, _ := suite.newContext(http.MethodPost, "/admin/api-keys", v.requestBody)
+			err := suite.apiKey.Create(c)
+			require.EqualError(err, v.expectedError)
+		})
+	}
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_Create_OwnerNotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=user not found"
+
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	c, _ := suite.newContext(http.MethodPost, "/admin/api-keys", `{"name":"job","owner_id":1,"scope":"metas:read"}`)
+	err := suite.apiKey.Create(c)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_Create_Success() {
+	require := suite.Require()
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	suite.sqlMock.ExpectBegin()
+	syntax = "^INSERT INTO `api_keys`"
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	suite.sqlMock.ExpectCommit()
+
+	c, response := suite.newContext(http.MethodPost, "/admin/api-keys", `{"name":"job","owner_id":1,"scope":"metas:read"}`)
+	err := suite.apiKey.Create(c)
+
+	require.NoError(err)
+	require.Equal(http.StatusCreated, response.Code)
+
+	var res apiKeyRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Equal(uint(3), res.ID)
+	require.Equal("metas:read", res.Scope)
+	require.True(strings.HasPrefix(res.Key, res.Prefix))
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_List_Success() {
+	require := suite.Require()
+	expectedMsg := `[{"id":1,"name":"job","prefix":"gek_0a1b2c3d","owner_id":1,"scope":"metas:read","created_at":"0001-01-01T00:00:00Z","revoked_at":null}]`
+
+	rows := sqlmock.NewRows([]string{"id", "name", "prefix", "key_hash", "owner_id", "scopes"}).
+		AddRow(1, "job", "gek_0a1b2c3d", utils.HashAPIKey("gek_0a1b2c3d_secret"), 1, "metas:read")
+	syntax := "^SELECT (.+) FROM `api_keys` WHERE owner_id = (.+) ORDER BY id"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	c, response := suite.newContext(http.MethodGet, "/admin/api-keys?owner_id=1", "")
+	err := suite.apiKey.List(c)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.JSONEq(expectedMsg, response.Body.String())
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_Revoke_DBErr_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	syntax := "^UPDATE `api_keys` SET `revoked_at`=.+,`updated_at`=.+ WHERE id = .+ AND revoked_at IS NULL"
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnError(errors.New("database err"))
+	suite.sqlMock.ExpectRollback()
+
+	c, _ := suite.newContext(http.MethodDelete, "/admin/api-keys/1", "")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	err := suite.apiKey.Revoke(c)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_Revoke_NotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=api key not found"
+
+	syntax := "^UPDATE `api_keys` SET `revoked_at`=.+,`updated_at`=.+ WHERE id = .+ AND revoked_at IS NULL"
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectCommit()
+
+	c, _ := suite.newContext(http.MethodDelete, "/admin/api-keys/1", "")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	err := suite.apiKey.Revoke(c)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_Revoke_Success() {
+	require := suite.Require()
+
+	syntax := "^UPDATE `api_keys` SET `revoked_at`=.+,`updated_at`=.+ WHERE id = .+ AND revoked_at IS NULL"
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	c, response := suite.newContext(http.MethodDelete, "/admin/api-keys/1", "")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	err := suite.apiKey.Revoke(c)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+}
+
+func TestAPIKey(t *testing.T) {
+	suite.Run(t, new(APIKeyTestSuite))
+}
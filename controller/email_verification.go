@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"golang-example/config"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type EmailVerification struct {
+	DB     *gorm.DB
+	Redis  *goredis.Client
+	Mailer utils.Mailer
+}
+
+type emailVerifiedRes struct {
+	Status string `json:"status"`
+}
+
+// sendEmailVerification mails a verification link and code to the user. A
+// failed delivery is only logged, the user can ask for another mail.
+func sendEmailVerification(ctx context.Context, redis *goredis.Client, mailer utils.Mailer, user model.User) error {
+	token, err := utils.GenerateEmailVerificationToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	code, err := utils.GenerateEmailVerificationCode(ctx, redis, user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(config.C.EmailVerification.URL)
+	if err != nil {
+		return err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	msg := utils.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease verify your email by opening the link below:\n\n%s\n\n"+
+			"Or enter this code: %s\n\nThe code expires in %s. If you didn't sign up, you can ignore this mail.\n",
+			user.UserName, link.String(), code, config.C.EmailVerification.CodeTTL),
+	}
+
+	if err = mailer.Send(ctx, msg); err != nil {
+		log.Errorf("sending verification mail to user [%d] failed: %s", user.ID, err)
+	}
+
+	return nil
+}
+
+type verifyEmailLinkReq struct {
+	Token string `query:"token"`
+}
+
+// VerifyLink verifies the email with the token of the link in the mail. The
+// link only works as long as the user still has the email it was sent to.
+func (e *EmailVerification) VerifyLink(ctx echo.Context) error {
+	var req verifyEmailLinkReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	userID, email, err := utils.ValidateEmailVerificationToken(req.Token)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	}
+
+	var user model.User
+	err = e.DB.Where(model.User{ID: userID}).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if user.Email != email {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	}
+
+	if err = e.markVerified(user); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, emailVerifiedRes{Status: "verified"})
+}
+
+type verifyEmailCodeReq struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+func (req *verifyEmailCodeReq) validate() error {
+	req.Email = normalizeEmail(req.Email)
+	if !validEmail(req.Email) {
+		return errors.New("email is invalid")
+	}
+
+	if req.Code == "" {
+		return errors.New("code is required")
+	}
+
+	return nil
+}
+
+// VerifyCode verifies the email with the 6-digit code of the mail. It
+// doesn't need a login, as the policy may not allow one before.
+func (e *EmailVerification) VerifyCode(ctx echo.Context) error {
+	var req verifyEmailCodeReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var user model.User
+	err = e.DB.Where("email = ? AND email_verified_at IS NULL", req.Email).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	err = utils.VerifyEmailVerificationCode(ctx.Request().Context(), e.Redis, user.ID, user.Email, req.Code)
+	if err == utils.ErrEmailVerificationInvalid {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = e.markVerified(user); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, emailVerifiedRes{Status: "verified"})
+}
+
+type resendEmailVerificationReq struct {
+	Email string `json:"email"`
+}
+
+func (req *resendEmailVerificationReq) validate() error {
+	req.Email = normalizeEmail(req.Email)
+	if !validEmail(req.Email) {
+		return errors.New("email is invalid")
+	}
+
+	return nil
+}
+
+// Resend mails a new link and code. Resends are throttled per email whether
+// or not a user has it, and the response doesn't tell either, so the
+// endpoint can't be used to find registered emails.
+func (e *EmailVerification) Resend(ctx echo.Context) error {
+	var req resendEmailVerificationReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	retryAfter, err := utils.AllowEmailVerificationResend(ctx.Request().Context(), e.Redis, req.Email)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if retryAfter > 0 {
+		return tooManyRequests(ctx, retryAfter)
+	}
+
+	var user model.User
+	err = e.DB.Where("email = ? AND email_verified_at IS NULL", req.Email).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return ctx.NoContent(http.StatusAccepted)
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = sendEmailVerification(ctx.Request().Context(), e.Redis, e.Mailer, user); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// markVerified sets the email of the user verified, keeping the time of the
+// first verification.
+func (e *EmailVerification) markVerified(user model.User) error {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	return e.DB.Model(&user).Update("email_verified_at", time.Now()).Error
+}
@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type EmailVerificationTestSuite struct {
+	suite.Suite
+	e                 *echo.Echo
+	ctx               context.Context
+	sqlMock           sqlmock.Sqlmock
+	redisServer       *miniredis.Miniredis
+	mailPath          string
+	emailVerification EmailVerification
+}
+
+func (suite *EmailVerificationTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.ctx = context.Background()
+	suite.mailPath = filepath.Join(suite.T().TempDir(), "mail.log")
+	suite.emailVerification = EmailVerification{DB: db, Redis: redisClient, Mailer: &utils.FileMailer{Path: suite.mailPath}}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn: time.Minute,
+			Secret:    "secret",
+		},
+		EmailVerification: config.EmailVerification{
+			Policy:          config.EmailVerificationPolicyLogin,
+			URL:             "https://example.com/verify-email",
+			LinkTTL:         time.Hour,
+			CodeTTL:         15 * time.Minute,
+			MaxCodeAttempts: 5,
+			ResendInterval:  time.Minute,
+			MaxResends:      5,
+			ResendWindow:    time.Hour,
+		},
+	}
+}
+
+func (suite *EmailVerificationTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.emailVerification.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *EmailVerificationTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+	_ = os.Remove(suite.mailPath)
+}
+
+func (suite *EmailVerificationTestSuite) CallHandler(handler echo.HandlerFunc, method string, target string, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(method, target, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := handler(c)
+
+	return rec, err
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_VerifyLink_InvalidToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid or expired token"
+
+	_, err := suite.CallHandler(suite.emailVerification.VerifyLink, http.MethodGet, "/email/verify?token=invalid", "")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_VerifyLink_EmailChanged_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid or expired token"
+
+	token, err := utils.GenerateEmailVerificationToken(1, "old@example.com")
+	require.NoError(err)
+
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "new@example.com"))
+
+	_, err = suite.CallHandler(suite.emailVerification.VerifyLink, http.MethodGet, "/email/verify?token="+token, "")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_VerifyLink_Success() {
+	require := suite.Require()
+
+	token, err := utils.GenerateEmailVerificationToken(1, "user@example.com")
+	require.NoError(err)
+
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "user@example.com"))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `email_verified_at`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler(suite.emailVerification.VerifyLink, http.MethodGet, "/email/verify?token="+token, "")
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.JSONEq(`{"status":"verified"}`, response.Body.String())
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_VerifyCode_UnknownEmail_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid code"
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) AND email_verified_at IS NULL ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := suite.CallHandler(suite.emailVerification.VerifyCode, http.MethodPost, "/email/verify", `{"email":"user@example.com","code":"123456"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_VerifyCode_WrongCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid code"
+
+	code, err := utils.GenerateEmailVerificationCode(suite.ctx, suite.emailVerification.Redis, 1, "user@example.com")
+	require.NoError(err)
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) AND email_verified_at IS NULL ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "user@example.com"))
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	_, err = suite.CallHandler(suite.emailVerification.VerifyCode, http.MethodPost, "/email/verify", `{"email":"user@example.com","code":"`+wrong+`"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_VerifyCode_Success() {
+	require := suite.Require()
+
+	code, err := utils.GenerateEmailVerificationCode(suite.ctx, suite.emailVerification.Redis, 1, "user@example.com")
+	require.NoError(err)
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) AND email_verified_at IS NULL ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "user@example.com"))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `email_verified_at`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler(suite.emailVerification.VerifyCode, http.MethodPost, "/email/verify", `{"email":"User@example.com","code":"`+code+`"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Resend_UnknownEmail_Success() {
+	require := suite.Require()
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) AND email_verified_at IS NULL ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	response, err := suite.CallHandler(suite.emailVerification.Resend, http.MethodPost, "/email/verify/resend", `{"email":"user@example.com"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, response.Code)
+	require.NoFileExists(suite.mailPath)
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Resend_Success() {
+	require := suite.Require()
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) AND email_verified_at IS NULL ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_name", "email"}).AddRow(1, "username", "user@example.com"))
+
+	response, err := suite.CallHandler(suite.emailVerification.Resend, http.MethodPost, "/email/verify/resend", `{"email":"user@example.com"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, response.Code)
+
+	b, err := os.ReadFile(suite.mailPath)
+	require.NoError(err)
+
+	token := regexp.MustCompile(`https://example\.com/verify-email\?token=([A-Za-z0-9_.-]+)`).FindStringSubmatch(string(b))
+	require.Len(token, 2)
+	userID, email, err := utils.ValidateEmailVerificationToken(token[1])
+	require.NoError(err)
+	require.Equal(uint(1), userID)
+	require.Equal("user@example.com", email)
+
+	code := regexp.MustCompile(`code: ([0-9]{6})`).FindStringSubmatch(string(b))
+	require.Len(code, 2)
+	require.NoError(utils.VerifyEmailVerificationCode(suite.ctx, suite.emailVerification.Redis, 1, "user@example.com", code[1]))
+
+	// A second resend right away is throttled.
+	_, err = suite.CallHandler(suite.emailVerification.Resend, http.MethodPost, "/email/verify/resend", `{"email":"user@example.com"}`)
+	require.EqualError(err, "code=429, message=too many requests")
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Resend_Throttled_Failure() {
+	require := suite.Require()
+
+	_, err := utils.AllowEmailVerificationResend(suite.ctx, suite.emailVerification.Redis, "user@example.com")
+	require.NoError(err)
+
+	response, err := suite.CallHandler(suite.emailVerification.Resend, http.MethodPost, "/email/verify/resend", `{"email":"user@example.com"}`)
+
+	require.EqualError(err, "code=429, message=too many requests")
+	require.Equal("60", response.Header().Get("Retry-After"))
+}
+
+func TestEmailVerification(t *testing.T) {
+	suite.Run(t, new(EmailVerificationTestSuite))
+}
@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var totpCodePattern = regexp.MustCompile("^[0-9]{6}$")
+
+type MFA struct {
+	DB    *gorm.DB
+	Redis *goredis.Client
+}
+
+type mfaPendingRes struct {
+	Status    string `json:"status"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// issueMFAPendingToken returns the short-lived token a user with two-factor
+// authentication gets for the password. It carries the requested scopes
+// until it is exchanged at /login/mfa.
+func issueMFAPendingToken(ctx context.Context, redis *goredis.Client, user model.User, scopes []string) (*mfaPendingRes, error) {
+	generation, err := utils.TokenGeneration(ctx, redis, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateToken(utils.TokenParams{
+		UserID:     user.ID,
+		Generation: generation,
+		Scopes:     scopes,
+		Purpose:    utils.TokenPurposeMFAPending,
+		ExpiresIn:  config.C.MFA.PendingTokenTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &mfaPendingRes{Status: "mfa_required", MFAToken: token, ExpiresIn: int64(config.C.MFA.PendingTokenTTL.Seconds())}, nil
+}
+
+type mfaEnrollRes struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCode     string `json:"qr_code"`
+}
+
+// Enroll starts the enrollment with a new TOTP secret. Two-factor
+// authentication is only turned on once a code is confirmed, enrolling again
+// before that replaces the secret.
+func (m *MFA) Enroll(ctx echo.Context) error {
+	id := ctx.Get(userIDContextField).(uint)
+
+	var user model.User
+	err := m.DB.Where(model.User{ID: id}).First(&user).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if user.MFAEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "mfa is already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	uri := utils.TOTPURI(config.C.MFA.Issuer, user.UserName, secret)
+	qrCode, err := utils.QRCodePNG(uri, 4)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	err = m.DB.Model(&user).Update("mfa_secret", encrypted).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, mfaEnrollRes{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode),
+	})
+}
+
+type mfaCodeReq struct {
+	Code string `json:"code"`
+}
+
+func (req *mfaCodeReq) validate() error {
+	req.Code = strings.TrimSpace(req.Code)
+	if req.Code == "" {
+		return errors.New("code is required")
+	}
+
+	return nil
+}
+
+type mfaConfirmRes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Confirm turns two-factor authentication on with the first code of the
+// authenticator app and hands out the recovery codes. They are only shown
+// this once.
+func (m *MFA) Confirm(ctx echo.Context) error {
+	var req mfaCodeReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+
+	var user model.User
+	err = m.DB.Where(model.User{ID: id}).First(&user).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if user.MFAEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "mfa is already enabled")
+	}
+
+	if user.MFASecret == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "mfa enrollment is not started")
+	}
+
+	ok, err := m.checkSecondFactor(ctx, user, req.Code, m.verifyTOTP)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	codes, hashes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("mfa_enabled", true).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		recoveryCodes := make([]model.MFARecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			recoveryCodes[i] = model.MFARecoveryCode{UserID: user.ID, CodeHash: hash}
+		}
+
+		return tx.Create(&recoveryCodes).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, mfaConfirmRes{RecoveryCodes: codes})
+}
+
+// Disable turns two-factor authentication off. It needs a current code or a
+// recovery code, so a stolen access token alone can't do it.
+func (m *MFA) Disable(ctx echo.Context) error {
+	var req mfaCodeReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+
+	var user model.User
+	err = m.DB.Where(model.User{ID: id}).First(&user).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if !user.MFAEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "mfa is not enabled")
+	}
+
+	ok, err := m.checkSecondFactor(ctx, user, req.Code, m.verifySecondFactor)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{"mfa_secret": "", "mfa_enabled": false}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&model.MFARecoveryCode{}).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type mfaLoginReq struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+func (req *mfaLoginReq) validate() error {
+	req.Code = strings.TrimSpace(req.Code)
+	if req.MFAToken == "" || req.Code == "" {
+		return errors.New("mfa_token and code are required")
+	}
+
+	return nil
+}
+
+// Login exchanges the pending token of a password login and a code, or a
+// recovery code, for the access and refresh tokens. The pending token is
+// single use and only allows a few attempts.
+func (m *MFA) Login(ctx echo.Context) error {
+	var req mfaLoginReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	claims, err := utils.ValidatePurposeToken(req.MFAToken, utils.TokenPurposeMFAPending)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid mfa token")
+	}
+
+	err = utils.CheckTokenRevoked(ctx.Request().Context(), m.Redis, claims)
+	if err == utils.ErrTokenRevoked {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid mfa token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	attempts, err := utils.CountMFAAttempt(ctx.Request().Context(), m.Redis, claims.Id, claims.ExpiresAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if attempts > config.C.MFA.MaxAttempts {
+		if err = utils.RevokeToken(ctx.Request().Context(), m.Redis, claims.Id, claims.ExpiresAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		return echo.NewHTTPError(http.StatusUnauthorized, "too many attempts")
+	}
+
+	var user model.User
+	err = m.DB.Where(model.User{ID: claims.ID}).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid mfa token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if !user.MFAEnabled {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid mfa token")
+	}
+
+	ok, err := m.checkSecondFactor(ctx, user, req.Code, m.verifySecondFactor)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid code")
+	}
+
+	if err = utils.RevokeToken(ctx.Request().Context(), m.Redis, claims.Id, claims.ExpiresAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	res, err := issueTokens(ctx, m.Redis, user, claims.Scopes())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = writeSession(ctx, res); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// checkSecondFactor verifies the code of the user with verify and counts
+// failures towards a lockout of the user. It is shared by everything asking
+// for a second factor, so the attempts of one pending token or session don't
+// start over with the next. The returned error is the response to send.
+func (m *MFA) checkSecondFactor(ctx echo.Context, user model.User, code string, verify func(context.Context, model.User, string) (bool, error)) (bool, error) {
+	reqCtx := ctx.Request().Context()
+
+	lockedFor, err := utils.MFALockedFor(reqCtx, m.Redis, user.ID)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if lockedFor > 0 {
+		return false, tooManyRequests(ctx, lockedFor)
+	}
+
+	ok, err := verify(reqCtx, user, code)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if !ok {
+		lockedFor, err = utils.RecordMFAFailure(reqCtx, m.Redis, user.ID)
+		if err != nil {
+			return false, echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		if lockedFor > 0 {
+			return false, tooManyRequests(ctx, lockedFor)
+		}
+
+		return false, nil
+	}
+
+	if err = utils.ResetMFAFailures(reqCtx, m.Redis, user.ID); err != nil {
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return true, nil
+}
+
+// verifySecondFactor accepts a TOTP code or one of the unused recovery codes
+// of the user, which is used up by the call.
+func (m *MFA) verifySecondFactor(ctx context.Context, user model.User, code string) (bool, error) {
+	if totpCodePattern.MatchString(code) {
+		return m.verifyTOTP(ctx, user, code)
+	}
+
+	result := m.DB.Model(&model.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// verifyTOTP checks the code against the secret of the user. Every code is
+// only accepted once.
+func (m *MFA) verifyTOTP(ctx context.Context, user model.User, code string) (bool, error) {
+	secret, err := utils.DecryptSecret(user.MFASecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := utils.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return utils.MarkTOTPUsed(ctx, m.Redis, user.ID, step)
+}
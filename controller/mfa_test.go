@@ -0,0 +1,494 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/model"
+	"golang-example/utils"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	mfaTestSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	mfaUserQuery  = "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+)
+
+func mfaTestConfig() config.Config {
+	return config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+		MFA: config.MFA{
+			Issuer:          "golang-example",
+			EncryptionKey:   "c2VjcmV0LWtleS1mb3ItZGV2ZWxvcG1lbnQtb25seSE=",
+			PendingTokenTTL: time.Minute,
+			MaxAttempts:     3,
+			UserMaxAttempts: 4,
+			LockDuration:    time.Minute,
+		},
+	}
+}
+
+// mfaUserRows returns the user row, with the test secret when enrolled.
+func mfaUserRows(enrolled bool, enabled bool) *sqlmock.Rows {
+	secret := ""
+	if enrolled {
+		var err error
+		secret, err = utils.EncryptSecret(mfaTestSecret)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return sqlmock.NewRows([]string{"id", "user_name", "password", "roles", "mfa_secret", "mfa_enabled"}).
+		AddRow(1, "username", "hash", "user", secret, enabled)
+}
+
+func mfaTestCode(offset time.Duration) string {
+	code, err := utils.TOTPCode(mfaTestSecret, time.Now().Add(offset))
+	if err != nil {
+		panic(err)
+	}
+
+	return code
+}
+
+type MFATestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	mfa         MFA
+}
+
+func (suite *MFATestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.ctx = context.Background()
+	suite.mfa = MFA{DB: db, Redis: redisClient}
+	config.C = mfaTestConfig()
+}
+
+func (suite *MFATestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.mfa.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *MFATestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *MFATestSuite) CallHandler(handler echo.HandlerFunc, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/me/mfa", strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set(userIDContextField, uint(1))
+	err := handler(c)
+
+	return rec, err
+}
+
+func (suite *MFATestSuite) TestMFA_Enroll_AlreadyEnabled_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=mfa is already enabled"
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+
+	_, err := suite.CallHandler(suite.mfa.Enroll, "")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFATestSuite) TestMFA_Enroll_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(false, false))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `mfa_secret`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler(suite.mfa.Enroll, "")
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	var res mfaEnrollRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Len(res.Secret, 32)
+	require.Equal(utils.TOTPURI("golang-example", "username", res.Secret), res.OTPAuthURI)
+	require.True(strings.HasPrefix(res.QRCode, "data:image/png;base64,"))
+
+	qrCode, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(res.QRCode, "data:image/png;base64,"))
+	require.NoError(err)
+	_, err = png.Decode(bytes.NewReader(qrCode))
+	require.NoError(err)
+}
+
+func (suite *MFATestSuite) TestMFA_Confirm_NotStarted_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=mfa enrollment is not started"
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(false, false))
+
+	_, err := suite.CallHandler(suite.mfa.Confirm, `{"code":"123456"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFATestSuite) TestMFA_Confirm_InvalidCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid code"
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, false))
+
+	_, err := suite.CallHandler(suite.mfa.Confirm, `{"code":"`+mfaTestCode(-5*time.Minute)+`"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFATestSuite) TestMFA_Confirm_ReplayedCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid code"
+
+	code := mfaTestCode(0)
+	step, ok := utils.VerifyTOTP(mfaTestSecret, code, time.Now())
+	require.True(ok)
+	_, err := utils.MarkTOTPUsed(suite.ctx, suite.mfa.Redis, 1, step)
+	require.NoError(err)
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, false))
+
+	_, err = suite.CallHandler(suite.mfa.Confirm, `{"code":"`+code+`"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFATestSuite) TestMFA_Confirm_TooManyAttempts_Failure() {
+	require := suite.Require()
+
+	for i := 0; i < 3; i++ {
+		suite.sqlMock.ExpectQuery(mfaUserQuery).
+			WithArgs(1).
+			WillReturnRows(mfaUserRows(true, false))
+
+		_, err := suite.CallHandler(suite.mfa.Confirm, `{"code":"`+mfaTestCode(-5*time.Minute)+`"}`)
+		require.EqualError(err, "code=400, message=invalid code")
+	}
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, false))
+
+	response, err := suite.CallHandler(suite.mfa.Confirm, `{"code":"`+mfaTestCode(-5*time.Minute)+`"}`)
+	require.EqualError(err, "code=429, message=too many requests")
+	require.Equal("60", response.Header().Get("Retry-After"))
+
+	// The right code doesn't help while locked.
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, false))
+
+	_, err = suite.CallHandler(suite.mfa.Confirm, `{"code":"`+mfaTestCode(0)+`"}`)
+	require.EqualError(err, "code=429, message=too many requests")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *MFATestSuite) TestMFA_Confirm_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, false))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `mfa_enabled`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WithArgs(true, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec("^DELETE FROM `mfa_recovery_codes` WHERE user_id = .+").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectExec("^INSERT INTO `mfa_recovery_codes`").
+		WillReturnResult(sqlmock.NewResult(1, 10))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler(suite.mfa.Confirm, `{"code":"`+mfaTestCode(0)+`"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	var res mfaConfirmRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Len(res.RecoveryCodes, 10)
+}
+
+func (suite *MFATestSuite) TestMFA_Disable_NotEnabled_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=mfa is not enabled"
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, false))
+
+	_, err := suite.CallHandler(suite.mfa.Disable, `{"code":"123456"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFATestSuite) TestMFA_Disable_UsedRecoveryCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid code"
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `mfa_recovery_codes` SET `used_at`=.+ WHERE user_id = .+ AND code_hash = .+ AND used_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), 1, utils.HashRecoveryCode("abcde-12345")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.sqlMock.ExpectCommit()
+
+	_, err := suite.CallHandler(suite.mfa.Disable, `{"code":"abcde-12345"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFATestSuite) TestMFA_Disable_RecoveryCode_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `mfa_recovery_codes` SET `used_at`=.+ WHERE user_id = .+ AND code_hash = .+ AND used_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), 1, utils.HashRecoveryCode("abcde-12345")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `mfa_enabled`=.+,`mfa_secret`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WithArgs(false, "", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec("^DELETE FROM `mfa_recovery_codes` WHERE user_id = .+").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 9))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler(suite.mfa.Disable, `{"code":"ABCDE-12345"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestMFA(t *testing.T) {
+	suite.Run(t, new(MFATestSuite))
+}
+
+type MFALoginTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	endpoint    string
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	mfa         MFA
+}
+
+func (suite *MFALoginTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.endpoint = "/login/mfa"
+	suite.ctx = context.Background()
+	suite.mfa = MFA{DB: db, Redis: redisClient}
+	config.C = mfaTestConfig()
+}
+
+func (suite *MFALoginTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.mfa.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *MFALoginTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *MFALoginTestSuite) CallHandler(requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := suite.mfa.Login(c)
+
+	return rec, err
+}
+
+func (suite *MFALoginTestSuite) pendingToken() string {
+	res, err := issueMFAPendingToken(suite.ctx, suite.mfa.Redis, model.User{ID: 1, UserName: "username", Roles: model.Roles{model.RoleUser}}, []string{"metas:read"})
+	suite.Require().NoError(err)
+	suite.Require().Equal("mfa_required", res.Status)
+
+	return res.MFAToken
+}
+
+func (suite *MFALoginTestSuite) TestMFALogin_Login_EmptyCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=mfa_token and code are required"
+
+	_, err := suite.CallHandler(`{"mfa_token":"token"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFALoginTestSuite) TestMFALogin_Login_AccessToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=invalid mfa token"
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+
+	_, err = suite.CallHandler(`{"mfa_token":"` + token + `","code":"123456"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFALoginTestSuite) TestMFALogin_Login_InvalidCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=invalid code"
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+
+	_, err := suite.CallHandler(`{"mfa_token":"` + suite.pendingToken() + `","code":"` + mfaTestCode(-5*time.Minute) + `"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MFALoginTestSuite) TestMFALogin_Login_TooManyAttempts_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=too many attempts"
+
+	token := suite.pendingToken()
+	claims, err := utils.ValidatePurposeToken(token, utils.TokenPurposeMFAPending)
+	require.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err = utils.CountMFAAttempt(suite.ctx, suite.mfa.Redis, claims.Id, claims.ExpiresAt)
+		require.NoError(err)
+	}
+
+	_, err = suite.CallHandler(`{"mfa_token":"` + token + `","code":"` + mfaTestCode(0) + `"}`)
+	require.EqualError(err, expectedError)
+
+	require.EqualError(utils.CheckTokenRevoked(suite.ctx, suite.mfa.Redis, claims), utils.ErrTokenRevoked.Error())
+}
+
+func (suite *MFALoginTestSuite) TestMFALogin_Login_Success() {
+	require := suite.Require()
+
+	token := suite.pendingToken()
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+
+	response, err := suite.CallHandler(`{"mfa_token":"` + token + `","code":"` + mfaTestCode(0) + `"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+
+	var res signupRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Equal("success", res.Status)
+	require.Equal("metas:read", res.Scope)
+	require.NotEmpty(res.RefreshToken)
+
+	claims, err := utils.ValidateToken(res.Token)
+	require.NoError(err)
+	require.Equal(uint(1), claims.ID)
+	require.Equal([]string{"user"}, claims.Roles)
+
+	// The pending token can't be used a second time.
+	_, err = suite.CallHandler(`{"mfa_token":"` + token + `","code":"` + mfaTestCode(30*time.Second) + `"}`)
+	require.EqualError(err, "code=401, message=invalid mfa token")
+}
+
+func (suite *MFALoginTestSuite) TestMFALogin_Login_UserLocked_Failure() {
+	require := suite.Require()
+
+	// Wrong codes sent to disable count towards the same lock as the ones
+	// sent with pending tokens.
+	for i := 0; i < 3; i++ {
+		suite.sqlMock.ExpectQuery(mfaUserQuery).
+			WithArgs(1).
+			WillReturnRows(mfaUserRows(true, true))
+
+		req := httptest.NewRequest(http.MethodPost, "/me/mfa/disable", strings.NewReader(`{"code":"`+mfaTestCode(-5*time.Minute)+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := suite.e.NewContext(req, httptest.NewRecorder())
+		c.Set(userIDContextField, uint(1))
+		require.EqualError(suite.mfa.Disable(c), "code=400, message=invalid code")
+	}
+
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+
+	response, err := suite.CallHandler(`{"mfa_token":"` + suite.pendingToken() + `","code":"` + mfaTestCode(-5*time.Minute) + `"}`)
+	require.EqualError(err, "code=429, message=too many requests")
+	require.Equal("60", response.Header().Get("Retry-After"))
+
+	// A new pending token and the right code don't help while locked.
+	suite.sqlMock.ExpectQuery(mfaUserQuery).
+		WithArgs(1).
+		WillReturnRows(mfaUserRows(true, true))
+
+	_, err = suite.CallHandler(`{"mfa_token":"` + suite.pendingToken() + `","code":"` + mfaTestCode(0) + `"}`)
+	require.EqualError(err, "code=429, message=too many requests")
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestMFALogin(t *testing.T) {
+	suite.Run(t, new(MFALoginTestSuite))
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeRefreshToken      = "refresh_token"
+	tokenTypeBearer            = "Bearer"
+	tokenTypeHintRefreshToken  = "refresh_token"
+)
+
+var errInvalidClient = errors.New("client authentication failed")
+
+type OAuth struct {
+	DB    *gorm.DB
+	Redis *goredis.Client
+}
+
+type oauthErrorRes struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// oauthError writes an error response in the format of RFC 6749 section 5.2.
+func oauthError(ctx echo.Context, status int, code string, description string) error {
+	if status == http.StatusUnauthorized {
+		ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+	}
+
+	return ctx.JSON(status, oauthErrorRes{Error: code, ErrorDescription: description})
+}
+
+type oauthTokenReq struct {
+	GrantType    string `form:"grant_type"`
+	Scope        string `form:"scope"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type oauthTokenRes struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// Token is the token endpoint of RFC 6749. Clients authenticate with HTTP
+// basic authentication or by sending their credentials in the body.
+func (o *OAuth) Token(ctx echo.Context) error {
+	var req oauthTokenReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return oauthError(ctx, http.StatusBadRequest, "invalid_request", "error in parse request data")
+	}
+
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	ctx.Response().Header().Set("Pragma", "no-cache")
+
+	switch req.GrantType {
+	case grantTypeClientCredentials:
+		return o.clientCredentialsGrant(ctx, req)
+	case grantTypeRefreshToken:
+		return o.refreshTokenGrant(ctx, req)
+	case "":
+		return oauthError(ctx, http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		return oauthError(ctx, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// clientCredentialsGrant issues an access token on behalf of the owner of the
+// client, limited to the scopes the client is registered with. No refresh
+// token is issued as the client can always ask for a new access token.
+func (o *OAuth) clientCredentialsGrant(ctx echo.Context, req oauthTokenReq) error {
+	client, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err == errInvalidClient {
+		return oauthError(ctx, http.StatusUnauthorized, "invalid_client", "")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = model.ParseScopes(req.Scope)
+		for _, scope := range scopes {
+			if !hasScope(client.Scopes, scope) {
+				return oauthError(ctx, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope [%s] is not allowed for the client", scope))
+			}
+		}
+	}
+
+	generation, err := utils.TokenGeneration(ctx.Request().Context(), o.Redis, client.OwnerID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	scopeStrings := model.ScopeStrings(scopes)
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: client.OwnerID, Generation: generation, Scopes: scopeStrings, ClientID: client.ClientID})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, oauthTokenRes{
+		AccessToken: token,
+		TokenType:   tokenTypeBearer,
+		ExpiresIn:   int64(config.C.Token.ExpiresIn.Seconds()),
+		Scope:       strings.Join(scopeStrings, " "),
+	})
+}
+
+// refreshTokenGrant rotates a refresh token like /token/refresh does. Client
+// authentication is optional since refresh tokens of first party logins
+// aren't issued to a client, but credentials which are sent have to be
+// valid. A refresh token can only be redeemed by the client it was issued
+// to.
+func (o *OAuth) refreshTokenGrant(ctx echo.Context, req oauthTokenReq) error {
+	var clientID string
+	if _, _, basic := ctx.Request().BasicAuth(); basic || req.ClientID != "" {
+		client, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+		if err == errInvalidClient {
+			return oauthError(ctx, http.StatusUnauthorized, "invalid_client", "")
+		}
+
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		clientID = client.ClientID
+	}
+
+	if req.RefreshToken == "" {
+		return oauthError(ctx, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+	}
+
+	token, refreshToken, scopes, err := refreshAccessToken(ctx.Request().Context(), o.DB, o.Redis, req.RefreshToken, clientID)
+	if err == utils.ErrRefreshTokenInvalid {
+		return oauthError(ctx, http.StatusBadRequest, "invalid_grant", "")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, oauthTokenRes{
+		AccessToken:  token,
+		TokenType:    tokenTypeBearer,
+		ExpiresIn:    int64(config.C.Token.ExpiresIn.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+type introspectReq struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+type introspectRes struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+// Introspect is the token introspection endpoint of RFC 7662. Only
+// registered clients may call it. Both access and refresh tokens are
+// understood, token_type_hint only decides which one is tried first.
+func (o *OAuth) Introspect(ctx echo.Context) error {
+	var req introspectReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return oauthError(ctx, http.StatusBadRequest, "invalid_request", "error in parse request data")
+	}
+
+	_, err = o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err == errInvalidClient {
+		return oauthError(ctx, http.StatusUnauthorized, "invalid_client", "")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if req.Token == "" {
+		return oauthError(ctx, http.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	lookups := []func(echo.Context, string) (*introspectRes, error){o.introspectAccessToken, o.introspectRefreshToken}
+	if req.TokenTypeHint == tokenTypeHintRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		res, err := lookup(ctx, req.Token)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		if res != nil {
+			return ctx.JSON(http.StatusOK, res)
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, introspectRes{Active: false})
+}
+
+func (o *OAuth) introspectAccessToken(ctx echo.Context, token string) (*introspectRes, error) {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	err = utils.CheckTokenRevoked(ctx.Request().Context(), o.Redis, claims)
+	if err == utils.ErrTokenRevoked {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &introspectRes{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		TokenType: tokenTypeBearer,
+		Exp:       claims.ExpiresAt,
+		Sub:       fmt.Sprint(claims.ID),
+		Jti:       claims.Id,
+	}, nil
+}
+
+func (o *OAuth) introspectRefreshToken(ctx echo.Context, token string) (*introspectRes, error) {
+	grant, expiresAt, err := utils.LookupRefreshToken(ctx.Request().Context(), o.Redis, token)
+	if err == utils.ErrRefreshTokenInvalid {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &introspectRes{
+		Active:   true,
+		Scope:    strings.Join(grant.Scopes, " "),
+		ClientID: grant.ClientID,
+		Exp:      expiresAt,
+		Sub:      fmt.Sprint(grant.UserID),
+	}, nil
+}
+
+// authenticateClient looks up the active client matching the credentials of
+// the request.
+func (o *OAuth) authenticateClient(ctx echo.Context, bodyClientID string, bodyClientSecret string) (*model.OAuthClient, error) {
+	clientID, clientSecret, ok := clientCredentials(ctx, bodyClientID, bodyClientSecret)
+	if !ok {
+		return nil, errInvalidClient
+	}
+
+	var client model.OAuthClient
+	err := o.DB.Where("client_id = ?", clientID).
+		Where("revoked_at IS NULL").
+		First(&client).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !utils.VerifyClientSecret(client.SecretHash, clientSecret) {
+		return nil, errInvalidClient
+	}
+
+	return &client, nil
+}
+
+// clientCredentials returns the client credentials sent with HTTP basic
+// authentication, falling back to the ones in the body. Basic credentials
+// are form encoded as required by RFC 6749 section 2.3.1.
+func clientCredentials(ctx echo.Context, bodyClientID string, bodyClientSecret string) (string, string, bool) {
+	clientID, clientSecret, ok := ctx.Request().BasicAuth()
+	if ok {
+		id, err := url.QueryUnescape(clientID)
+		if err != nil {
+			return "", "", false
+		}
+
+		secret, err := url.QueryUnescape(clientSecret)
+		if err != nil {
+			return "", "", false
+		}
+
+		return id, secret, id != "" && secret != ""
+	}
+
+	return bodyClientID, bodyClientSecret, bodyClientID != "" && bodyClientSecret != ""
+}
+
+func hasScope(scopes []model.Scope, scope model.Scope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
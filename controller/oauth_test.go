@@ -0,0 +1,403 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const oauthClientQuery = "^SELECT (.+) FROM `oauth_clients` WHERE client_id = (.+) AND revoked_at IS NULL ORDER BY `oauth_clients`.`id` LIMIT 1"
+
+func oauthClientRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "client_id", "secret_hash", "name", "scopes", "owner_id"}).
+		AddRow(1, "client", utils.HashClientSecret("client-secret"), "billing", "metas:read profile:read", 7)
+}
+
+type OAuthTokenTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	endpoint    string
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	oauth       OAuth
+}
+
+func (suite *OAuthTokenTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.endpoint = "/oauth/token"
+	suite.ctx = context.Background()
+	suite.oauth = OAuth{DB: db, Redis: redisClient}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+}
+
+func (suite *OAuthTokenTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.oauth.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *OAuthTokenTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *OAuthTokenTestSuite) CallHandler(form url.Values, basicAuth bool) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if basicAuth {
+		req.SetBasicAuth("client", "client-secret")
+	}
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := suite.oauth.Token(c)
+
+	return rec, err
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_Token_GrantType_Failure() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		grantType   string
+		expectedMsg string
+	}{
+		"Missing grant type": {
+			grantType:   "",
+			expectedMsg: `{"error":"invalid_request","error_description":"grant_type is required"}`,
+		},
+		"Unsupported grant type": {
+			grantType:   "password",
+			expectedMsg: `{"error":"unsupported_grant_type"}`,
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			response, err := suite.CallHandler(url.Values{"grant_type": {v.grantType}}, true)
+			require.NoError(err)
+			require.Equal(http.StatusBadRequest, response.Code)
+			require.JSONEq(v.expectedMsg, response.Body.String())
+		})
+	}
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_ClientCredentials_InvalidClient_Failure() {
+	require := suite.Require()
+	expectedMsg := `{"error":"invalid_client"}`
+
+	suite.sqlMock.ExpectQuery(oauthClientQuery).
+		WithArgs("client").
+		WillReturnRows(oauthClientRows())
+
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"client"}, "client_secret": {"wrong"}}
+	response, err := suite.CallHandler(form, false)
+
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, response.Code)
+	require.Equal(`Basic realm="oauth"`, response.Header().Get(echo.HeaderWWWAuthenticate))
+	require.JSONEq(expectedMsg, response.Body.String())
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_ClientCredentials_UnknownClient_Failure() {
+	require := suite.Require()
+	expectedMsg := `{"error":"invalid_client"}`
+
+	suite.sqlMock.ExpectQuery(oauthClientQuery).
+		WithArgs("client").
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	response, err := suite.CallHandler(url.Values{"grant_type": {"client_credentials"}}, true)
+
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, response.Code)
+	require.JSONEq(expectedMsg, response.Body.String())
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_ClientCredentials_InvalidScope_Failure() {
+	require := suite.Require()
+	expectedMsg := `{"error":"invalid_scope","error_description":"scope [metas:write] is not allowed for the client"}`
+
+	suite.sqlMock.ExpectQuery(oauthClientQuery).
+		WithArgs("client").
+		WillReturnRows(oauthClientRows())
+
+	response, err := suite.CallHandler(url.Values{"grant_type": {"client_credentials"}, "scope": {"metas:write"}}, true)
+
+	require.NoError(err)
+	require.Equal(http.StatusBadRequest, response.Code)
+	require.JSONEq(expectedMsg, response.Body.String())
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_ClientCredentials_Success() {
+	require := suite.Require()
+
+	suite.sqlMock.ExpectQuery(oauthClientQuery).
+		WithArgs("client").
+		WillReturnRows(oauthClientRows())
+
+	response, err := suite.CallHandler(url.Values{"grant_type": {"client_credentials"}, "scope": {"metas:read"}}, true)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.Equal("no-store", response.Header().Get("Cache-Control"))
+
+	var res oauthTokenRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Equal("Bearer", res.TokenType)
+	require.Equal(int64(60), res.ExpiresIn)
+	require.Equal("metas:read", res.Scope)
+	require.Empty(res.RefreshToken)
+
+	claims, err := utils.ValidateToken(res.AccessToken)
+	require.NoError(err)
+	require.Equal(uint(7), claims.ID)
+	require.Equal("client", claims.ClientID)
+	require.Empty(claims.Roles)
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_RefreshToken_InvalidGrant_Failure() {
+	require := suite.Require()
+	expectedMsg := `{"error":"invalid_grant"}`
+
+	response, err := suite.CallHandler(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"unknown"}}, false)
+
+	require.NoError(err)
+	require.Equal(http.StatusBadRequest, response.Code)
+	require.JSONEq(expectedMsg, response.Body.String())
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_RefreshToken_Success() {
+	require := suite.Require()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.oauth.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	rows := sqlmock.NewRows([]string{"id", "roles"}).
+		AddRow(1, "user")
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	response, err := suite.CallHandler(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}, false)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+
+	var res oauthTokenRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Equal("Bearer", res.TokenType)
+	require.Equal("metas:read", res.Scope)
+	require.NotEmpty(res.RefreshToken)
+	require.NotEqual(refreshToken, res.RefreshToken)
+}
+
+func (suite *OAuthTokenTestSuite) TestOAuthToken_RefreshToken_OtherClient_Failure() {
+	require := suite.Require()
+	expectedMsg := `{"error":"invalid_grant"}`
+
+	firstParty, err := utils.GenerateRefreshToken(suite.ctx, suite.oauth.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	otherClient, err := utils.GenerateRefreshToken(suite.ctx, suite.oauth.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}, ClientID: "other"})
+	require.NoError(err)
+
+	testCases := map[string]struct {
+		refreshToken string
+		basicAuth    bool
+	}{
+		"first party token with client":  {refreshToken: firstParty, basicAuth: true},
+		"client token with other client": {refreshToken: otherClient, basicAuth: true},
+		"client token without client":    {refreshToken: otherClient, basicAuth: false},
+	}
+
+	for name, tc := range testCases {
+		if tc.basicAuth {
+			suite.sqlMock.ExpectQuery(oauthClientQuery).
+				WithArgs("client").
+				WillReturnRows(oauthClientRows())
+		}
+
+		response, err := suite.CallHandler(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tc.refreshToken}}, tc.basicAuth)
+
+		require.NoError(err, name)
+		require.Equal(http.StatusBadRequest, response.Code, name)
+		require.JSONEq(expectedMsg, response.Body.String(), name)
+	}
+
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestOAuthToken(t *testing.T) {
+	suite.Run(t, new(OAuthTokenTestSuite))
+}
+
+type OAuthIntrospectTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	endpoint    string
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	oauth       OAuth
+}
+
+func (suite *OAuthIntrospectTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.endpoint = "/oauth/introspect"
+	suite.ctx = context.Background()
+	suite.oauth = OAuth{DB: db, Redis: redisClient}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+}
+
+func (suite *OAuthIntrospectTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.oauth.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *OAuthIntrospectTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *OAuthIntrospectTestSuite) CallHandler(form url.Values) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.SetBasicAuth("client", "client-secret")
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := suite.oauth.Introspect(c)
+
+	return rec, err
+}
+
+func (suite *OAuthIntrospectTestSuite) TestOAuthIntrospect_Introspect_InvalidClient_Failure() {
+	require := suite.Require()
+	expectedMsg := `{"error":"invalid_client"}`
+
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader("token=abc"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	err := suite.oauth.Introspect(suite.e.NewContext(req, rec))
+
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, rec.Code)
+	require.JSONEq(expectedMsg, rec.Body.String())
+}
+
+func (suite *OAuthIntrospectTestSuite) TestOAuthIntrospect_Introspect_AccessToken_Success() {
+	require := suite.Require()
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1, Scopes: []string{"metas:read"}, ClientID: "client"})
+	require.NoError(err)
+
+	suite.sqlMock.ExpectQuery(oauthClientQuery).
+		WithArgs("client").
+		WillReturnRows(oauthClientRows())
+
+	response, err := suite.CallHandler(url.Values{"token": {token}})
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+
+	var res introspectRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.True(res.Active)
+	require.Equal("metas:read", res.Scope)
+	require.Equal("client", res.ClientID)
+	require.Equal("Bearer", res.TokenType)
+	require.Equal("1", res.Sub)
+	require.NotEmpty(res.Jti)
+}
+
+func (suite *OAuthIntrospectTestSuite) TestOAuthIntrospect_Introspect_RefreshToken_Success() {
+	require := suite.Require()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.oauth.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	suite.sqlMock.ExpectQuery(oauthClientQuery).
+		WithArgs("client").
+		WillReturnRows(oauthClientRows())
+
+	response, err := suite.CallHandler(url.Values{"token": {refreshToken}, "token_type_hint": {"refresh_token"}})
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+
+	var res introspectRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.True(res.Active)
+	require.Equal("metas:read", res.Scope)
+	require.Equal("1", res.Sub)
+}
+
+func (suite *OAuthIntrospectTestSuite) TestOAuthIntrospect_Introspect_Inactive_Success() {
+	require := suite.Require()
+	expectedMsg := `{"active":false}`
+
+	revoked, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+	require.NoError(utils.RevokeUserTokens(suite.ctx, suite.oauth.Redis, 1))
+
+	testCases := map[string]string{
+		"Unknown token": "unknown",
+		"Revoked token": revoked,
+	}
+
+	for desc, token := range testCases {
+		suite.Run(desc, func() {
+			suite.sqlMock.ExpectQuery(oauthClientQuery).
+				WithArgs("client").
+				WillReturnRows(oauthClientRows())
+
+			response, err := suite.CallHandler(url.Values{"token": {token}})
+
+			require.NoError(err)
+			require.Equal(http.StatusOK, response.Code)
+			require.JSONEq(expectedMsg, response.Body.String())
+		})
+	}
+}
+
+func TestOAuthIntrospect(t *testing.T) {
+	suite.Run(t, new(OAuthIntrospectTestSuite))
+}
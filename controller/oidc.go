@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+const oidcStateCookie = "oidc_state"
+
+type OIDC struct {
+	DB    *gorm.DB
+	Redis *goredis.Client
+}
+
+type oidcLoginReq struct {
+	Provider string `param:"provider"`
+}
+
+// Login sends the user to the identity provider. The state is also put in a
+// cookie so the callback only completes in the browser that started it.
+func (o *OIDC) Login(ctx echo.Context) error {
+	var req oidcLoginReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	provider, err := utils.GetOIDCProvider(req.Provider)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "provider not found")
+	}
+
+	state, data, err := utils.NewOIDCState(ctx.Request().Context(), o.Redis, provider.Name())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx.Request().Context(), state, data.Nonce, data.CodeVerifier)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "identity provider is unavailable")
+	}
+
+	ctx.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/",
+		MaxAge:   int(config.C.OIDC.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   ctx.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return ctx.Redirect(http.StatusFound, authURL)
+}
+
+type oidcCallbackReq struct {
+	Provider string `param:"provider"`
+	Code     string `query:"code"`
+	State    string `query:"state"`
+	Error    string `query:"error"`
+}
+
+func (req *oidcCallbackReq) validate() error {
+	if req.Error != "" {
+		return errors.New("login was rejected by the identity provider")
+	}
+
+	if req.Code == "" || req.State == "" {
+		return errors.New("code and state are required")
+	}
+
+	return nil
+}
+
+// Callback completes the login. The ID token is verified, the user linked to
+// the external account is looked up or created, and our own tokens are
+// issued just like for a password login.
+func (o *OIDC) Callback(ctx echo.Context) error {
+	var req oidcCallbackReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	provider, err := utils.GetOIDCProvider(req.Provider)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "provider not found")
+	}
+
+	cookie, err := ctx.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value != req.State {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid state")
+	}
+
+	ctx.SetCookie(&http.Cookie{Name: oidcStateCookie, Path: "/auth/", MaxAge: -1, HttpOnly: true})
+
+	state, err := utils.ConsumeOIDCState(ctx.Request().Context(), o.Redis, req.State)
+	if err == utils.ErrOIDCStateInvalid || (err == nil && state.Provider != provider.Name()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid state")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	rawIDToken, err := provider.Exchange(ctx.Request().Context(), req.Code, state.CodeVerifier)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "login with identity provider failed")
+	}
+
+	claims, err := provider.VerifyIDToken(ctx.Request().Context(), rawIDToken, state.Nonce)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "login with identity provider failed")
+	}
+
+	user, err := o.linkUser(provider.Name(), claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if user.MFAEnabled {
+		res, err := issueMFAPendingToken(ctx.Request().Context(), o.Redis, *user, model.ScopeStrings(model.DefaultScopes))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		return ctx.JSON(http.StatusOK, res)
+	}
+
+	res, err := issueTokens(ctx, o.Redis, *user, model.ScopeStrings(model.DefaultScopes))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = writeSession(ctx, res); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// linkUser returns the user linked to the external account, creating both
+// on the first login. Such users have no password and can only sign in
+// through the provider.
+func (o *OIDC) linkUser(provider string, claims *utils.IDTokenClaims) (*model.User, error) {
+	var user model.User
+	err := o.DB.Transaction(func(tx *gorm.DB) error {
+		var identity model.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+		if err == nil {
+			if err = tx.Where(model.User{ID: identity.UserID}).First(&user).Error; err != nil {
+				return err
+			}
+
+			if claims.Email != identity.Email {
+				return tx.Model(&identity).Update("email", claims.Email).Error
+			}
+
+			return nil
+		}
+
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		userName, err := externalUserName()
+		if err != nil {
+			return err
+		}
+
+		user = model.User{UserName: userName, Roles: model.Roles{model.RoleUser}}
+		if err = tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		identity = model.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}
+
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// externalUserName returns a random username that matches the username
+// rules of signup.
+func externalUserName() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "user-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/testutil"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type OIDCTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	idp         *testutil.OIDCProviderMock
+	oidc        OIDC
+}
+
+func (suite *OIDCTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.idp = testutil.NewOIDCProviderMock()
+	suite.e = echo.New()
+	suite.oidc = OIDC{DB: db, Redis: redisClient}
+}
+
+func (suite *OIDCTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+		OIDC: config.OIDC{
+			StateTTL:  time.Minute,
+			Providers: []config.OIDCProvider{suite.idp.Config("fake")},
+		},
+	}
+	suite.Require().NoError(utils.InitOIDCProviders())
+}
+
+func (suite *OIDCTestSuite) TearDownSuite() {
+	suite.idp.Close()
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.oidc.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *OIDCTestSuite) CallLogin(provider string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/"+provider+"/login", nil)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.SetParamNames("provider")
+	c.SetParamValues(provider)
+	err := suite.oidc.Login(c)
+
+	return rec, err
+}
+
+func (suite *OIDCTestSuite) CallCallback(provider string, query url.Values, state string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/"+provider+"/callback?"+query.Encode(), nil)
+	if state != "" {
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: state})
+	}
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.SetParamNames("provider")
+	c.SetParamValues(provider)
+	err := suite.oidc.Callback(c)
+
+	return rec, err
+}
+
+// authorize starts a login and signs in at the fake provider, returning the
+// callback query and the state cookie.
+func (suite *OIDCTestSuite) authorize(subject string) (url.Values, string) {
+	require := suite.Require()
+
+	response, err := suite.CallLogin("fake")
+	require.NoError(err)
+	require.Equal(http.StatusFound, response.Code)
+
+	location := response.Header().Get(echo.HeaderLocation)
+	code, err := suite.idp.Authorize(location, subject, "user@example.com")
+	require.NoError(err)
+
+	u, err := url.Parse(location)
+	require.NoError(err)
+	state := u.Query().Get("state")
+
+	return url.Values{"code": {code}, "state": {state}}, state
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Login_UnknownProvider_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=provider not found"
+
+	_, err := suite.CallLogin("unknown")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Login_Success() {
+	require := suite.Require()
+
+	response, err := suite.CallLogin("fake")
+
+	require.NoError(err)
+	require.Equal(http.StatusFound, response.Code)
+
+	u, err := url.Parse(response.Header().Get(echo.HeaderLocation))
+	require.NoError(err)
+	require.Equal(suite.idp.Server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal("S256", u.Query().Get("code_challenge_method"))
+
+	cookies := response.Result().Cookies()
+	require.Len(cookies, 1)
+	require.Equal(oidcStateCookie, cookies[0].Name)
+	require.Equal(u.Query().Get("state"), cookies[0].Value)
+	require.True(cookies[0].HttpOnly)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Callback_Validation_Failure() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		query         url.Values
+		expectedError string
+	}{
+		"Provider error": {
+			query:         url.Values{"error": {"access_denied"}},
+			expectedError: "code=400, message=login was rejected by the identity provider",
+		},
+		"Missing code": {
+			query:         url.Values{"state": {"state"}},
+			expectedError: "code=400, message=code and state are required",
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			_, err := suite.CallCallback("fake", v.query, "state")
+			require.EqualError(err, v.expectedError)
+		})
+	}
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Callback_StateMismatch_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid state"
+
+	query, _ := suite.authorize("subject")
+	_, err := suite.CallCallback("fake", query, "other")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Callback_UnknownState_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid state"
+
+	query := url.Values{"code": {"code"}, "state": {"unknown"}}
+	_, err := suite.CallCallback("fake", query, "unknown")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Callback_InvalidCode_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=login with identity provider failed"
+
+	query, state := suite.authorize("subject")
+	query.Set("code", "invalid")
+	_, err := suite.CallCallback("fake", query, state)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Callback_NewUser_Success() {
+	require := suite.Require()
+
+	query, state := suite.authorize("subject")
+
+	suite.sqlMock.ExpectBegin()
+	syntax := "^SELECT (.+) FROM `user_identities` WHERE provider = (.+) AND subject = (.+) ORDER BY `user_identities`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("fake", "subject").
+		WillReturnError(gorm.ErrRecordNotFound)
+	suite.sqlMock.ExpectExec("^INSERT INTO `users`").
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	suite.sqlMock.ExpectExec("^INSERT INTO `user_identities`").
+		WithArgs(5, "fake", "subject", "user@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallCallback("fake", query, state)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	var res signupRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Equal("success", res.Status)
+	require.NotEmpty(res.RefreshToken)
+
+	claims, err := utils.ValidateToken(res.Token)
+	require.NoError(err)
+	require.Equal(uint(5), claims.ID)
+	require.Equal([]string{"user"}, claims.Roles)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Callback_LinkedUser_Success() {
+	require := suite.Require()
+
+	query, state := suite.authorize("subject")
+
+	suite.sqlMock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"id", "user_id", "provider", "subject", "email"}).
+		AddRow(1, 3, "fake", "subject", "user@example.com")
+	syntax := "^SELECT (.+) FROM `user_identities` WHERE provider = (.+) AND subject = (.+) ORDER BY `user_identities`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("fake", "subject").
+		WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"id", "user_name", "roles"}).
+		AddRow(3, "user-0a1b2c3d4e5f", "user,admin")
+	syntax = "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(3).
+		WillReturnRows(rows)
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallCallback("fake", query, state)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	var res signupRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+
+	claims, err := utils.ValidateToken(res.Token)
+	require.NoError(err)
+	require.Equal(uint(3), claims.ID)
+	require.Equal([]string{"user", "admin"}, claims.Roles)
+}
+
+func TestOIDC(t *testing.T) {
+	suite.Run(t, new(OIDCTestSuite))
+}
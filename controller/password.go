@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"golang-example/config"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// passwordResetMailTimeout bounds making and sending a reset mail after the
+// request is answered.
+var passwordResetMailTimeout = 30 * time.Second
+
+type Password struct {
+	DB     *gorm.DB
+	Redis  *goredis.Client
+	Mailer utils.Mailer
+
+	// resets tracks the reset mails still being sent.
+	resets sync.WaitGroup
+}
+
+type forgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+func (req *forgotPasswordReq) validate() error {
+	req.Email = normalizeEmail(req.Email)
+	if !validEmail(req.Email) {
+		return errors.New("email is invalid")
+	}
+
+	return nil
+}
+
+// Forgot mails a password reset link to the user with the email. The
+// response is the same whether or not such a user exists, so it can't be
+// used to find out which emails are registered. The token and the mail are
+// made after responding, so the response time doesn't tell either.
+func (p *Password) Forgot(ctx echo.Context) error {
+	var req forgotPasswordReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var user model.User
+	err = p.DB.Where("email = ?", req.Email).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return ctx.NoContent(http.StatusAccepted)
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	p.resets.Add(1)
+	go func() {
+		defer p.resets.Done()
+		p.sendPasswordReset(user)
+	}()
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// Wait blocks until the reset mails still being sent are done, so they
+// aren't lost on shutdown.
+func (p *Password) Wait() {
+	p.resets.Wait()
+}
+
+// sendPasswordReset mails a new reset token to the user. It runs after the
+// request is answered, so failures are only logged.
+func (p *Password) sendPasswordReset(user model.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordResetMailTimeout)
+	defer cancel()
+
+	token, err := utils.GeneratePasswordResetToken(ctx, p.Redis, user.ID)
+	if err != nil {
+		log.Errorf("generating password reset token for user [%d] failed: %s", user.ID, err)
+		return
+	}
+
+	msg, err := passwordResetMessage(user, token)
+	if err != nil {
+		log.Errorf("making password reset mail for user [%d] failed: %s", user.ID, err)
+		return
+	}
+
+	if err = p.Mailer.Send(ctx, msg); err != nil {
+		log.Errorf("sending password reset mail to user [%d] failed: %s", user.ID, err)
+	}
+}
+
+func passwordResetMessage(user model.User, token string) (utils.Message, error) {
+	link, err := url.Parse(config.C.PasswordReset.URL)
+	if err != nil {
+		return utils.Message{}, err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return utils.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomebody asked to reset the password of your account. "+
+			"If it was you, open the link below to choose a new password:\n\n%s\n\n"+
+			"The link expires in %s and can only be used once. If you didn't ask for it, you can ignore this mail.\n",
+			user.UserName, link.String(), config.C.PasswordReset.TokenTTL),
+	}, nil
+}
+
+type resetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (req *resetPasswordReq) validate() error {
+	if req.Token == "" {
+		return errors.New("token is required")
+	}
+
+	if err := utils.ValidatePassword(req.Password); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reset sets a new password with a token from Forgot. Every session of the
+// user is ended, as whoever knew the old password may have one.
+func (p *Password) Reset(ctx echo.Context) error {
+	var req resetPasswordReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return validationError(err)
+	}
+
+	// The token is only looked up for now, so it stays good for another try
+	// when the password was used before.
+	userID, err := utils.LookupPasswordResetToken(ctx.Request().Context(), p.Redis, req.Token)
+	if err == utils.ErrPasswordResetTokenInvalid {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	var user model.User
+	err = p.DB.Where(model.User{ID: userID}).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	reused, err := passwordReused(p.DB, user, req.Password)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if reused {
+		return validationError(utils.PasswordReusedError())
+	}
+
+	hashedPass, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	_, err = utils.ConsumePasswordResetToken(ctx.Request().Context(), p.Redis, req.Token)
+	if err == utils.ErrPasswordResetTokenInvalid {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = savePassword(p.DB, user, hashedPass); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = utils.RevokeUserTokens(ctx.Request().Context(), p.Redis, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+type changePasswordReq struct {
+	CurrentPassword     string `json:"current_password"`
+	NewPassword         string `json:"new_password"`
+	RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+}
+
+func (req *changePasswordReq) validate() error {
+	if req.CurrentPassword == "" {
+		return errors.New("current password is required")
+	}
+
+	if err := utils.ValidatePassword(req.NewPassword); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Change sets a new password for the logged-in user, who has to know the
+// current one. Other sessions are ended on request. As that also rejects
+// the token of the caller, a new token pair is returned in that case.
+func (p *Password) Change(ctx echo.Context) error {
+	var req changePasswordReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return validationError(err)
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+
+	var user model.User
+	err = p.DB.Where(model.User{ID: id}).First(&user).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = utils.VerifyPassword(user.Password, req.CurrentPassword); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "current password is incorrect")
+	}
+
+	reused, err := passwordReused(p.DB, user, req.NewPassword)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if reused {
+		return validationError(utils.PasswordReusedError())
+	}
+
+	hashedPass, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = savePassword(p.DB, user, hashedPass); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if !req.RevokeOtherSessions {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	if err = utils.RevokeUserTokens(ctx.Request().Context(), p.Redis, user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	scopes, _ := ctx.Get(scopesContextField).([]string)
+	res, err := issueTokens(ctx, p.Redis, user, scopes)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = writeSession(ctx, res); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// passwordReused reports whether the password is the current one of the user
+// or one of the previous ones the history size covers.
+func passwordReused(db *gorm.DB, user model.User, password string) (bool, error) {
+	size := config.C.PasswordHistory.Size
+	if size <= 0 {
+		return false, nil
+	}
+
+	if utils.VerifyPassword(user.Password, password) == nil {
+		return true, nil
+	}
+
+	if size == 1 {
+		return false, nil
+	}
+
+	var hashes []string
+	err := db.Model(&model.PasswordHistory{}).
+		Where("user_id = ?", user.ID).
+		Order("id DESC").
+		Limit(size-1).
+		Pluck("password_hash", &hashes).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, hash := range hashes {
+		if utils.VerifyPassword(hash, password) == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// savePassword sets the new password hash of the user. The old hash goes to
+// the password history, which is pruned to what the history size covers.
+func savePassword(db *gorm.DB, user model.User, hashedPass string) error {
+	previous := user.Password
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hashedPass).Error; err != nil {
+			return err
+		}
+
+		size := config.C.PasswordHistory.Size
+		if size <= 0 {
+			return nil
+		}
+
+		// The current password counts towards the size, the history keeps
+		// the rest.
+		keep := size - 1
+		if keep > 0 {
+			err := tx.Create(&model.PasswordHistory{UserID: user.ID, PasswordHash: previous}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		var ids []uint
+		err := tx.Model(&model.PasswordHistory{}).
+			Where("user_id = ?", user.ID).
+			Order("id DESC").
+			Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+
+		if len(ids) <= keep {
+			return nil
+		}
+
+		return tx.Where("id IN ?", ids[keep:]).Delete(&model.PasswordHistory{}).Error
+	})
+}
@@ -0,0 +1,500 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type PasswordTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	mailPath    string
+	password    Password
+}
+
+func (suite *PasswordTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.ctx = context.Background()
+	suite.mailPath = filepath.Join(suite.T().TempDir(), "mail.log")
+	suite.password = Password{DB: db, Redis: redisClient, Mailer: &utils.FileMailer{Path: suite.mailPath, From: "no-reply@example.com"}}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+		PasswordReset: config.PasswordReset{
+			TokenTTL: 30 * time.Minute,
+			URL:      "https://example.com/reset-password",
+		},
+	}
+}
+
+func (suite *PasswordTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.password.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *PasswordTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+	config.C.PasswordHistory = config.PasswordHistory{}
+	_ = os.Remove(suite.mailPath)
+}
+
+func (suite *PasswordTestSuite) CallHandler(handler echo.HandlerFunc, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, "/password", strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := handler(c)
+
+	return rec, err
+}
+
+func (suite *PasswordTestSuite) CallAuthenticatedHandler(handler echo.HandlerFunc, requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPut, "/me/password", strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set(userIDContextField, uint(1))
+	c.Set(scopesContextField, []string{"metas:read"})
+	err := handler(c)
+
+	return rec, err
+}
+
+// sentToken returns the reset token of the last mail written by the mailer.
+func (suite *PasswordTestSuite) sentToken() string {
+	b, err := os.ReadFile(suite.mailPath)
+	suite.Require().NoError(err)
+
+	matches := regexp.MustCompile(`https://example\.com/reset-password\?token=([A-Za-z0-9_-]+)`).FindAllStringSubmatch(string(b), -1)
+	suite.Require().NotEmpty(matches)
+
+	return matches[len(matches)-1][1]
+}
+
+func (suite *PasswordTestSuite) TestPassword_Forgot_InvalidEmail_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=email is invalid"
+
+	_, err := suite.CallHandler(suite.password.Forgot, `{"email":"not an email"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Forgot_DBErr_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnError(errors.New("database err"))
+
+	_, err := suite.CallHandler(suite.password.Forgot, `{"email":"user@example.com"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Forgot_UnknownEmail_Success() {
+	require := suite.Require()
+
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("nobody@example.com").
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	response, err := suite.CallHandler(suite.password.Forgot, `{"email":"nobody@example.com"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, response.Code)
+	require.NoFileExists(suite.mailPath)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Forgot_Success() {
+	require := suite.Require()
+
+	rows := sqlmock.NewRows([]string{"id", "user_name", "email"}).
+		AddRow(1, "username", "user@example.com")
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnRows(rows)
+
+	response, err := suite.CallHandler(suite.password.Forgot, `{"email":" User@Example.com "}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, response.Code)
+	suite.password.Wait()
+
+	b, err := os.ReadFile(suite.mailPath)
+	require.NoError(err)
+	require.Contains(string(b), "To: user@example.com\r\n")
+
+	userID, err := utils.ConsumePasswordResetToken(suite.ctx, suite.password.Redis, suite.sentToken())
+	require.NoError(err)
+	require.Equal(uint(1), userID)
+}
+
+// blockingMailer holds every mail until it is released.
+type blockingMailer struct {
+	release chan struct{}
+	sent    []utils.Message
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg utils.Message) error {
+	<-m.release
+	m.sent = append(m.sent, msg)
+
+	return nil
+}
+
+func (suite *PasswordTestSuite) TestPassword_Forgot_SlowMailer_Success() {
+	require := suite.Require()
+
+	mailer := &blockingMailer{release: make(chan struct{})}
+	password := &Password{DB: suite.password.DB, Redis: suite.password.Redis, Mailer: mailer}
+
+	rows := sqlmock.NewRows([]string{"id", "user_name", "email"}).
+		AddRow(1, "username", "user@example.com")
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnRows(rows)
+
+	// The response doesn't wait for the mail, as waiting would tell that
+	// the email is registered.
+	response, err := suite.CallHandler(password.Forgot, `{"email":"user@example.com"}`)
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, response.Code)
+	require.Empty(mailer.sent)
+
+	close(mailer.release)
+	password.Wait()
+	require.Len(mailer.sent, 1)
+	require.Equal("user@example.com", mailer.sent[0].To)
+}
+
+// hungMailer never gets the mail out, like an SMTP server that stopped
+// answering.
+type hungMailer struct{}
+
+func (hungMailer) Send(ctx context.Context, msg utils.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (suite *PasswordTestSuite) TestPassword_Forgot_HungMailer_Success() {
+	require := suite.Require()
+
+	timeout := passwordResetMailTimeout
+	passwordResetMailTimeout = 50 * time.Millisecond
+	defer func() { passwordResetMailTimeout = timeout }()
+
+	password := &Password{DB: suite.password.DB, Redis: suite.password.Redis, Mailer: hungMailer{}}
+
+	rows := sqlmock.NewRows([]string{"id", "user_name", "email"}).
+		AddRow(1, "username", "user@example.com")
+	syntax := "^SELECT (.+) FROM `users` WHERE email = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs("user@example.com").
+		WillReturnRows(rows)
+
+	response, err := suite.CallHandler(password.Forgot, `{"email":"user@example.com"}`)
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, response.Code)
+
+	// The send gives up, so waiting for it on shutdown ends.
+	done := make(chan struct{})
+	go func() {
+		password.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail("sending the reset mail didn't time out")
+	}
+}
+
+func (suite *PasswordTestSuite) TestPassword_Reset_WeakPassword_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=password isn't strong enough"
+
+	token, err := utils.GeneratePasswordResetToken(suite.ctx, suite.password.Redis, 1)
+	require.NoError(err)
+
+	_, err = suite.CallHandler(suite.password.Reset, `{"token":"`+token+`","password":"password"}`)
+	require.EqualError(err, expectedError)
+
+	// The token is still good for another try.
+	_, err = utils.ConsumePasswordResetToken(suite.ctx, suite.password.Redis, token)
+	require.NoError(err)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Reset_InvalidToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid or expired token"
+
+	_, err := suite.CallHandler(suite.password.Reset, `{"token":"unknown","password":"Aaaaaaaa768!"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Reset_Success() {
+	require := suite.Require()
+
+	token, err := utils.GeneratePasswordResetToken(suite.ctx, suite.password.Redis, 1)
+	require.NoError(err)
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.password.Redis, utils.RefreshTokenGrant{UserID: 1})
+	require.NoError(err)
+
+	suite.expectChangePasswordUser("Bbbbbbbb768!")
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `password`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler(suite.password.Reset, `{"token":"`+token+`","password":"Aaaaaaaa768!"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	// Existing sessions are gone.
+	_, _, err = utils.LookupRefreshToken(suite.ctx, suite.password.Redis, refreshToken)
+	require.Equal(utils.ErrRefreshTokenInvalid, err)
+
+	// The token can't be used twice.
+	_, err = suite.CallHandler(suite.password.Reset, `{"token":"`+token+`","password":"Aaaaaaaa768!"}`)
+	require.EqualError(err, "code=400, message=invalid or expired token")
+}
+
+func (suite *PasswordTestSuite) TestPassword_Reset_ReusedPassword_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=password isn't strong enough"
+	config.C.PasswordHistory.Size = 3
+
+	token, err := utils.GeneratePasswordResetToken(suite.ctx, suite.password.Redis, 1)
+	require.NoError(err)
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+
+	_, err = suite.CallHandler(suite.password.Reset, `{"token":"`+token+`","password":"Aaaaaaaa768!"}`)
+
+	require.EqualError(err, expectedError)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	policyErr := err.(*echo.HTTPError).Message.(*utils.PasswordPolicyError)
+	require.Equal(utils.PasswordRuleHistory, policyErr.Rules[0].Rule)
+	require.Equal("password should not be one of the last 3 passwords", policyErr.Rules[0].Message)
+
+	// The token is still good for another try.
+	_, err = utils.LookupPasswordResetToken(suite.ctx, suite.password.Redis, token)
+	require.NoError(err)
+}
+
+// expectPasswordHistory expects the lookup of the previous passwords of the
+// user.
+func (suite *PasswordTestSuite) expectPasswordHistory(limit int, passwords ...string) {
+	rows := sqlmock.NewRows([]string{"password_hash"})
+	for _, password := range passwords {
+		hashedPass, err := utils.HashPassword(password)
+		suite.Require().NoError(err)
+		rows.AddRow(hashedPass)
+	}
+
+	syntax := "^SELECT `password_hash` FROM `password_histories` WHERE user_id = .+ ORDER BY id DESC LIMIT " + strconv.Itoa(limit)
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+}
+
+func (suite *PasswordTestSuite) expectChangePasswordUser(password string) {
+	hashedPass, err := utils.HashPassword(password)
+	suite.Require().NoError(err)
+
+	rows := sqlmock.NewRows([]string{"id", "user_name", "password"}).
+		AddRow(1, "username", hashedPass)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_WeakPassword_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=password isn't strong enough"
+
+	_, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Aaaaaaaa768!","new_password":"password"}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_WrongPassword_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=current password is incorrect"
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+
+	_, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Bbbbbbbb768!","new_password":"Cccccccc768!"}`)
+
+	require.EqualError(err, expectedError)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_Success() {
+	require := suite.Require()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.password.Redis, utils.RefreshTokenGrant{UserID: 1})
+	require.NoError(err)
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `password`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Aaaaaaaa768!","new_password":"Cccccccc768!"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	// Other sessions are kept.
+	_, _, err = utils.LookupRefreshToken(suite.ctx, suite.password.Redis, refreshToken)
+	require.NoError(err)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_RevokeOtherSessions_Success() {
+	require := suite.Require()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.password.Redis, utils.RefreshTokenGrant{UserID: 1})
+	require.NoError(err)
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `password`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Aaaaaaaa768!","new_password":"Cccccccc768!","revoke_other_sessions":true}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	// Other sessions are gone.
+	_, _, err = utils.LookupRefreshToken(suite.ctx, suite.password.Redis, refreshToken)
+	require.Equal(utils.ErrRefreshTokenInvalid, err)
+
+	// The caller gets a new session with the same scopes.
+	var res signupRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Equal("metas:read", res.Scope)
+
+	claims, err := utils.ValidateToken(res.Token)
+	require.NoError(err)
+	require.NoError(utils.CheckTokenRevoked(suite.ctx, suite.password.Redis, claims))
+
+	grant, _, err := utils.LookupRefreshToken(suite.ctx, suite.password.Redis, res.RefreshToken)
+	require.NoError(err)
+	require.Equal([]string{"metas:read"}, grant.Scopes)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_ReusedPassword_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=password isn't strong enough"
+	config.C.PasswordHistory.Size = 3
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+	suite.expectPasswordHistory(2, "Bbbbbbbb768!", "Cccccccc768!")
+
+	_, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Aaaaaaaa768!","new_password":"Cccccccc768!"}`)
+
+	require.EqualError(err, expectedError)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+
+	policyErr := err.(*echo.HTTPError).Message.(*utils.PasswordPolicyError)
+	require.Equal(utils.PasswordRuleHistory, policyErr.Rules[0].Rule)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_CurrentPassword_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=password isn't strong enough"
+	config.C.PasswordHistory.Size = 1
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+
+	_, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Aaaaaaaa768!","new_password":"Aaaaaaaa768!"}`)
+
+	require.EqualError(err, expectedError)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *PasswordTestSuite) TestPassword_Change_PrunesHistory_Success() {
+	require := suite.Require()
+	config.C.PasswordHistory.Size = 3
+
+	suite.expectChangePasswordUser("Aaaaaaaa768!")
+	suite.expectPasswordHistory(2, "Bbbbbbbb768!")
+	suite.sqlMock.ExpectBegin()
+	suite.sqlMock.ExpectExec("^UPDATE `users` SET `password`=.+,`updated_at`=.+ WHERE `id` = .+").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.sqlMock.ExpectExec("^INSERT INTO `password_histories` \\(`user_id`,`password_hash`,`created_at`\\)").
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	suite.sqlMock.ExpectQuery("^SELECT `id` FROM `password_histories` WHERE user_id = .+ ORDER BY id DESC").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7).AddRow(6).AddRow(5).AddRow(4))
+	suite.sqlMock.ExpectExec("^DELETE FROM `password_histories` WHERE id IN \\(.+,.+\\)").
+		WithArgs(5, 4).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallAuthenticatedHandler(suite.password.Change, `{"current_password":"Aaaaaaaa768!","new_password":"Cccccccc768!"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func TestPassword(t *testing.T) {
+	suite.Run(t, new(PasswordTestSuite))
+}
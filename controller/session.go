@@ -0,0 +1,168 @@
+package controller
+
+import (
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Session struct {
+	Redis *goredis.Client
+}
+
+type sessionRes struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"`
+}
+
+// List returns where the logged-in user is logged in, the most recently
+// seen session first.
+func (s *Session) List(ctx echo.Context) error {
+	id := ctx.Get(userIDContextField).(uint)
+	currentID, _ := ctx.Get(sessionIDContextField).(string)
+
+	sessions, err := utils.UserSessions(ctx.Request().Context(), s.Redis, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	response := make([]sessionRes, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionRes{
+			ID:        session.ID,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			CreatedAt: session.CreatedAt,
+			LastSeen:  session.LastSeen,
+			Current:   session.ID == currentID,
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+type revokeSessionReq struct {
+	ID string `param:"id"`
+}
+
+// Revoke ends one session of the logged-in user. Its refresh tokens stop
+// working and so do its access tokens, the last one it used is put on the
+// denylist as well. The other sessions are kept.
+func (s *Session) Revoke(ctx echo.Context) error {
+	var req revokeSessionReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+
+	session, err := utils.DeleteSession(ctx.Request().Context(), s.Redis, id, req.ID)
+	if err == utils.ErrSessionNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if session.TokenID != "" {
+		expiresAt := time.Now().Add(config.C.Token.ExpiresIn).Unix()
+		if err = utils.RevokeToken(ctx.Request().Context(), s.Redis, session.TokenID, expiresAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	if currentID, _ := ctx.Get(sessionIDContextField).(string); currentID == session.ID {
+		clearSession(ctx)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// writeSession hands the tokens of res out as cookies when cookie mode is
+// enabled and removes them from the body, so scripts never see them. A
+// fresh CSRF token is set in a cookie readable by the frontend, which has
+// to send it back in the CSRF header.
+func writeSession(ctx echo.Context, res *signupRes) error {
+	if !config.C.Session.CookieMode {
+		return nil
+	}
+
+	csrfToken, err := utils.NewCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	ctx.SetCookie(sessionCookie(config.C.Session.CookieName, res.Token, "/", config.C.Token.ExpiresIn, true))
+	ctx.SetCookie(sessionCookie(config.C.Session.RefreshCookieName, res.RefreshToken, "/", config.C.Token.RefreshExpiresIn, true))
+	ctx.SetCookie(sessionCookie(config.C.Session.CSRFCookieName, csrfToken, "/", config.C.Token.RefreshExpiresIn, false))
+
+	res.Token = ""
+	res.RefreshToken = ""
+
+	return nil
+}
+
+// clearSession removes the session cookies.
+func clearSession(ctx echo.Context) {
+	if !config.C.Session.CookieMode {
+		return
+	}
+
+	ctx.SetCookie(sessionCookie(config.C.Session.CookieName, "", "/", -1, true))
+	ctx.SetCookie(sessionCookie(config.C.Session.RefreshCookieName, "", "/", -1, true))
+	ctx.SetCookie(sessionCookie(config.C.Session.CSRFCookieName, "", "/", -1, false))
+}
+
+// sessionRefreshToken returns the refresh token of the session cookie.
+func sessionRefreshToken(ctx echo.Context) string {
+	if !config.C.Session.CookieMode {
+		return ""
+	}
+
+	cookie, err := ctx.Cookie(config.C.Session.RefreshCookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func sessionCookie(name string, value string, path string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   config.C.Session.Domain,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   config.C.Session.Secure,
+		SameSite: sameSite(config.C.Session.SameSite),
+	}
+
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+
+	return cookie
+}
+
+func sameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/model"
+	"golang-example/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+	session     Session
+}
+
+func (suite *SessionTestSuite) SetupSuite() {
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+	suite.redisClient = redisClient
+
+	suite.e = echo.New()
+	suite.ctx = context.Background()
+	suite.session = Session{Redis: redisClient}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+}
+
+func (suite *SessionTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *SessionTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+// login issues tokens for user 1 as the given client would get them.
+func (suite *SessionTestSuite) login(ip string, userAgent string) *signupRes {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set("User-Agent", userAgent)
+	c := suite.e.NewContext(req, httptest.NewRecorder())
+
+	res, err := issueTokens(c, suite.redisClient, model.User{ID: 1}, []string{"metas:read"})
+	suite.Require().NoError(err)
+
+	return res
+}
+
+// CallHandler calls the handler authenticated by the access token.
+func (suite *SessionTestSuite) CallHandler(handler echo.HandlerFunc, token string, id string) (*httptest.ResponseRecorder, error) {
+	claims, err := utils.ValidateToken(token)
+	suite.Require().NoError(err)
+	suite.Require().NoError(utils.TouchSession(suite.ctx, suite.redisClient, claims.ID, claims.SessionID, claims.Id))
+
+	req := httptest.NewRequest(http.MethodGet, "/me/sessions", strings.NewReader(""))
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	c.Set(userIDContextField, claims.ID)
+	c.Set(sessionIDContextField, claims.SessionID)
+	err = handler(c)
+
+	return rec, err
+}
+
+func (suite *SessionTestSuite) TestSession_List_Success() {
+	require := suite.Require()
+
+	laptop := suite.login("10.0.0.1", "Firefox")
+	phone := suite.login("10.0.0.2", "Safari")
+
+	_, err := suite.CallHandler(suite.session.List, laptop.Token, "")
+	require.NoError(err)
+
+	response, err := suite.CallHandler(suite.session.List, phone.Token, "")
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+
+	var res []sessionRes
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &res))
+	require.Len(res, 2)
+
+	byIP := map[string]sessionRes{}
+	for _, session := range res {
+		byIP[session.IP] = session
+	}
+
+	require.Equal("Firefox", byIP["10.0.0.1"].UserAgent)
+	require.False(byIP["10.0.0.1"].Current)
+	require.Equal("Safari", byIP["10.0.0.2"].UserAgent)
+	require.True(byIP["10.0.0.2"].Current)
+	require.False(byIP["10.0.0.2"].CreatedAt.IsZero())
+	require.False(byIP["10.0.0.2"].LastSeen.IsZero())
+}
+
+func (suite *SessionTestSuite) TestSession_Revoke_NotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=session not found"
+
+	laptop := suite.login("10.0.0.1", "Firefox")
+
+	_, err := suite.CallHandler(suite.session.Revoke, laptop.Token, "unknown")
+	require.EqualError(err, expectedError)
+
+	// Sessions of other users can't be revoked either.
+	other, err := utils.CreateSession(suite.ctx, suite.redisClient, 2, "10.0.0.3", "Chrome")
+	require.NoError(err)
+
+	_, err = suite.CallHandler(suite.session.Revoke, laptop.Token, other.ID)
+	require.EqualError(err, expectedError)
+}
+
+func (suite *SessionTestSuite) TestSession_Revoke_Success() {
+	require := suite.Require()
+
+	laptop := suite.login("10.0.0.1", "Firefox")
+	phone := suite.login("10.0.0.2", "Safari")
+
+	phoneClaims, err := utils.ValidateToken(phone.Token)
+	require.NoError(err)
+	require.NoError(utils.TouchSession(suite.ctx, suite.redisClient, 1, phoneClaims.SessionID, phoneClaims.Id))
+
+	response, err := suite.CallHandler(suite.session.Revoke, laptop.Token, phoneClaims.SessionID)
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+
+	// The phone is logged out, with its access and refresh token.
+	require.Equal(utils.ErrTokenRevoked, utils.CheckTokenRevoked(suite.ctx, suite.redisClient, phoneClaims))
+	require.True(suite.redisServer.Exists("revoked_token:" + phoneClaims.Id))
+
+	_, _, _, err = refreshAccessToken(suite.ctx, nil, suite.redisClient, phone.RefreshToken, "")
+	require.Equal(utils.ErrRefreshTokenInvalid, err)
+
+	// The laptop is not.
+	laptopClaims, err := utils.ValidateToken(laptop.Token)
+	require.NoError(err)
+	require.NoError(utils.CheckTokenRevoked(suite.ctx, suite.redisClient, laptopClaims))
+
+	sessions, err := utils.UserSessions(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Len(sessions, 1)
+	require.Equal(laptopClaims.SessionID, sessions[0].ID)
+}
+
+func TestSession(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+const (
+	tokenIDContextField        = "token_id"
+	tokenExpiresAtContextField = "token_expires_at"
+	scopesContextField         = "scopes"
+	sessionIDContextField      = "session_id"
+)
+
+type Token struct {
+	DB    *gorm.DB
+	Redis *goredis.Client
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (req *refreshReq) validate() error {
+	if req.RefreshToken == "" {
+		return errors.New("refresh token is required")
+	}
+
+	return nil
+}
+
+func (t *Token) Refresh(ctx echo.Context) error {
+	var req refreshReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	fromCookie := false
+	if req.RefreshToken == "" {
+		req.RefreshToken = sessionRefreshToken(ctx)
+		fromCookie = req.RefreshToken != ""
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	token, refreshToken, scopes, err := refreshAccessToken(ctx.Request().Context(), t.DB, t.Redis, req.RefreshToken, "")
+	if err == utils.ErrRefreshTokenInvalid {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	res := &signupRes{Status: "success", Token: token, RefreshToken: refreshToken, Scope: strings.Join(scopes, " ")}
+	if fromCookie {
+		if err = writeSession(ctx, res); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// refreshAccessToken rotates the refresh token of the client and issues an
+// access token for its grant. Refresh tokens that can't be used anymore,
+// including reused ones, those of ended sessions and those of another
+// client, are reported as utils.ErrRefreshTokenInvalid.
+func refreshAccessToken(ctx context.Context, db *gorm.DB, redis *goredis.Client, refreshToken string, clientID string) (string, string, []string, error) {
+	grant, newRefreshToken, err := utils.RotateRefreshToken(ctx, redis, refreshToken, clientID)
+	if err == utils.ErrRefreshTokenReused {
+		return "", "", nil, utils.ErrRefreshTokenInvalid
+	}
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if grant.SessionID != "" {
+		exists, err := utils.SessionExists(ctx, redis, grant.UserID, grant.SessionID)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		if !exists {
+			return "", "", nil, utils.ErrRefreshTokenInvalid
+		}
+	}
+
+	var user model.User
+	err = db.Where(model.User{ID: grant.UserID}).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", "", nil, utils.ErrRefreshTokenInvalid
+	}
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	generation, err := utils.TokenGeneration(ctx, redis, user.ID)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: user.ID, Generation: generation, Roles: user.Roles.Strings(), Scopes: grant.Scopes, SessionID: grant.SessionID, ClientID: grant.ClientID})
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return token, newRefreshToken, grant.Scopes, nil
+}
+
+type logoutReq struct {
+	All          bool   `json:"all"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (t *Token) Logout(ctx echo.Context) error {
+	var req logoutReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if req.RefreshToken == "" {
+		req.RefreshToken = sessionRefreshToken(ctx)
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+	if req.All {
+		err = utils.RevokeUserTokens(ctx.Request().Context(), t.Redis, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		clearSession(ctx)
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	jti := ctx.Get(tokenIDContextField).(string)
+	expiresAt := ctx.Get(tokenExpiresAtContextField).(int64)
+	err = utils.RevokeToken(ctx.Request().Context(), t.Redis, jti, expiresAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if req.RefreshToken != "" {
+		err = utils.RevokeRefreshToken(ctx.Request().Context(), t.Redis, req.RefreshToken)
+		if err != nil && err != utils.ErrRefreshTokenInvalid {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	if sessionID, ok := ctx.Get(sessionIDContextField).(string); ok {
+		_, err = utils.DeleteSession(ctx.Request().Context(), t.Redis, id, sessionID)
+		if err != nil && err != utils.ErrSessionNotFound {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	clearSession(ctx)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (t *Token) JWKS(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, utils.JWKS())
+}
@@ -0,0 +1,384 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type RefreshTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	endpoint    string
+	ctx         context.Context
+	sqlMock     sqlmock.Sqlmock
+	redisServer *miniredis.Miniredis
+	patch       *gomonkey.Patches
+	token       Token
+}
+
+func (suite *RefreshTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.endpoint = "/token/refresh"
+	suite.ctx = context.Background()
+	suite.token = Token{DB: db, Redis: redisClient}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+
+	suite.patch = gomonkey.NewPatches()
+}
+
+func (suite *RefreshTestSuite) TearDownSuite() {
+	suite.patch.Reset()
+	suite.redisServer.Close()
+
+	sqlDB, _ := suite.token.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *RefreshTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *RefreshTestSuite) TearDownTest() {
+	suite.patch.Reset()
+}
+
+func (suite *RefreshTestSuite) CallHandler(requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := suite.token.Refresh(c)
+
+	return rec, err
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_Binding_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=error in parse request data"
+
+	requestBody := `{"refresh_token:"token"}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_EmptyToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=refresh token is required"
+
+	requestBody := `{}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_InvalidToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=invalid refresh token"
+
+	requestBody := `{"refresh_token":"unknown"}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_ReusedToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=invalid refresh token"
+
+	suite.patch.ApplyFunc(utils.RotateRefreshToken, func(ctx context.Context, redis *goredis.Client, token string, clientID string) (*utils.RefreshTokenGrant, string, error) {
+		return nil, "", utils.ErrRefreshTokenReused
+	})
+
+	requestBody := `{"refresh_token":"reused"}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_UserNotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=401, message=invalid refresh token"
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.token.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	requestBody := `{"refresh_token":"` + refreshToken + `"}`
+	_, err = suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_GenerateToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.token.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	suite.patch.ApplyFunc(utils.GenerateToken, func(params utils.TokenParams) (string, error) {
+		return "", errors.New("error")
+	})
+
+	requestBody := `{"refresh_token":"` + refreshToken + `"}`
+	_, err = suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_Success() {
+	require := suite.Require()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.token.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	requestBody := `{"refresh_token":"` + refreshToken + `"}`
+	response, err := suite.CallHandler(requestBody)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.Contains(response.Body.String(), `"status":"success"`)
+	require.Contains(response.Body.String(), `"scope":"metas:read"`)
+	require.NotContains(response.Body.String(), refreshToken)
+
+	_, err = suite.CallHandler(requestBody)
+	require.EqualError(err, "code=401, message=invalid refresh token")
+}
+
+func (suite *RefreshTestSuite) TestRefresh_Refresh_CookieMode_Success() {
+	require := suite.Require()
+
+	config.C.Session = config.Session{
+		CookieMode:        true,
+		CookieName:        "access_token",
+		RefreshCookieName: "refresh_token",
+		CSRFCookieName:    "csrf_token",
+	}
+	defer func() { config.C.Session = config.Session{} }()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.token.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: refreshToken})
+	rec := httptest.NewRecorder()
+	err = suite.token.Refresh(suite.e.NewContext(req, rec))
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, rec.Code)
+	require.JSONEq(`{"status":"success","scope":"metas:read"}`, rec.Body.String())
+
+	cookies := make(map[string]string)
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	require.NotEmpty(cookies["access_token"])
+	require.NotEmpty(cookies["csrf_token"])
+	require.NotEmpty(cookies["refresh_token"])
+	require.NotEqual(refreshToken, cookies["refresh_token"])
+}
+
+func TestRefresh(t *testing.T) {
+	suite.Run(t, new(RefreshTestSuite))
+}
+
+type LogoutTestSuite struct {
+	suite.Suite
+	e           *echo.Echo
+	endpoint    string
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	patch       *gomonkey.Patches
+	token       Token
+}
+
+func (suite *LogoutTestSuite) SetupSuite() {
+	redisServer, redisClient := database.NewRedisMock()
+	suite.redisServer = redisServer
+
+	suite.e = echo.New()
+	suite.endpoint = "/logout"
+	suite.ctx = context.Background()
+	suite.token = Token{Redis: redisClient}
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+
+	suite.patch = gomonkey.NewPatches()
+}
+
+func (suite *LogoutTestSuite) TearDownSuite() {
+	suite.patch.Reset()
+	suite.redisServer.Close()
+}
+
+func (suite *LogoutTestSuite) SetupTest() {
+	suite.redisServer.FlushAll()
+}
+
+func (suite *LogoutTestSuite) TearDownTest() {
+	suite.patch.Reset()
+}
+
+func (suite *LogoutTestSuite) CallHandler(requestBody string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPost, suite.endpoint, strings.NewReader(requestBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set(userIDContextField, uint(1))
+	c.Set(tokenIDContextField, "jti")
+	c.Set(tokenExpiresAtContextField, time.Now().Add(time.Minute).Unix())
+	err := suite.token.Logout(c)
+
+	return rec, err
+}
+
+func (suite *LogoutTestSuite) TestLogout_Logout_Binding_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=error in parse request data"
+
+	requestBody := `{"all:true}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *LogoutTestSuite) TestLogout_Logout_RevokeToken_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	suite.patch.ApplyFunc(utils.RevokeToken, func(ctx context.Context, redis *goredis.Client, jti string, expiresAt int64) error {
+		return errors.New("error")
+	})
+
+	requestBody := `{}`
+	_, err := suite.CallHandler(requestBody)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *LogoutTestSuite) TestLogout_Logout_Success() {
+	require := suite.Require()
+
+	refreshToken, err := utils.GenerateRefreshToken(suite.ctx, suite.token.Redis, utils.RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	requestBody := `{"refresh_token":"` + refreshToken + `"}`
+	response, err := suite.CallHandler(requestBody)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.True(suite.redisServer.Exists("revoked_token:jti"))
+
+	_, _, err = utils.RotateRefreshToken(suite.ctx, suite.token.Redis, refreshToken, "")
+	require.Equal(utils.ErrRefreshTokenInvalid, err)
+}
+
+func (suite *LogoutTestSuite) TestLogout_Logout_All_Success() {
+	require := suite.Require()
+
+	requestBody := `{"all":true}`
+	response, err := suite.CallHandler(requestBody)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+
+	generation, err := utils.TokenGeneration(suite.ctx, suite.token.Redis, 1)
+	require.NoError(err)
+	require.Equal(int64(1), generation)
+}
+
+func TestLogout(t *testing.T) {
+	suite.Run(t, new(LogoutTestSuite))
+}
+
+type JWKSTestSuite struct {
+	suite.Suite
+	e        *echo.Echo
+	endpoint string
+	token    Token
+}
+
+func (suite *JWKSTestSuite) SetupSuite() {
+	suite.e = echo.New()
+	suite.endpoint = "/.well-known/jwks.json"
+	suite.token = Token{}
+	config.C = config.Config{}
+}
+
+func (suite *JWKSTestSuite) TestJWKS_JWKS_NoKeys_Success() {
+	require := suite.Require()
+	expectedMsg := `{"keys":[]}`
+
+	req := httptest.NewRequest(http.MethodGet, suite.endpoint, nil)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	err := suite.token.JWKS(c)
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, rec.Code)
+	require.JSONEq(expectedMsg, rec.Body.String())
+}
+
+func TestJWKS(t *testing.T) {
+	suite.Run(t, new(JWKSTestSuite))
+}
@@ -2,14 +2,27 @@ package controller
 
 import (
 	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
 	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"golang-example/config"
 	"golang-example/model"
 	"golang-example/utils"
 	"gorm.io/gorm"
+	"math"
 	"net/http"
+	"net/mail"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// mysqlDuplicateEntry is the MySQL error number of a unique key violation.
+const mysqlDuplicateEntry = 1062
+
 var userNamePattern *regexp.Regexp
 
 func init() {
@@ -17,11 +30,14 @@ func init() {
 }
 
 type User struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Redis  *goredis.Client
+	Mailer utils.Mailer
 }
 
 type signupReq struct {
 	UserName string `json:"user_name"`
+	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
@@ -30,16 +46,40 @@ func (req *signupReq) validate() error {
 		return errors.New("username is invalid")
 	}
 
-	if err := utils.ValidatePasswordPattern(req.Password); err != nil {
-		return errors.New("password isn't strong enough")
+	req.Email = normalizeEmail(req.Email)
+	if req.Email == "" && (config.C.EmailVerification.EmailRequired() || config.C.HardenedMode) {
+		return errors.New("email is required")
+	}
+
+	if req.Email != "" && !validEmail(req.Email) {
+		return errors.New("email is invalid")
+	}
+
+	if err := utils.ValidatePassword(req.Password); err != nil {
+		return err
 	}
 
 	return nil
 }
 
 type signupRes struct {
-	Status string `json:"status"`
-	Token  string `json:"token"`
+	Status       string `json:"status"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// validEmail reports whether the email is a bare address like
+// "user@example.com", without a display name.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email && len(email) <= 255
+}
+
+// normalizeEmail lower cases the email so lookups don't depend on how it
+// was typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func (u *User) Signup(ctx echo.Context) error {
@@ -50,7 +90,11 @@ func (u *User) Signup(ctx echo.Context) error {
 	}
 
 	if err = req.validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return validationError(err)
+	}
+
+	if config.C.HardenedMode {
+		return u.hardenedSignup(ctx, req)
 	}
 
 	var user model.User
@@ -63,30 +107,216 @@ func (u *User) Signup(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "username is already taken")
 	}
 
+	if req.Email != "" {
+		taken, err := emailTaken(u.DB, req.Email)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		if taken {
+			return echo.NewHTTPError(http.StatusBadRequest, "email is already taken")
+		}
+	}
+
 	hashedPass, err := utils.HashPassword(req.Password)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
 	user.UserName = req.UserName
+	user.Email = req.Email
 	user.Password = hashedPass
+	user.Roles = model.Roles{model.RoleUser}
+
+	err = createUser(u.DB, &user)
+	if duplicateKey(err) {
+		return echo.NewHTTPError(http.StatusBadRequest, "email is already taken")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if user.Email != "" {
+		if err = sendEmailVerification(ctx.Request().Context(), u.Redis, u.Mailer, user); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	res, err := issueTokens(ctx, u.Redis, user, model.ScopeStrings(model.DefaultScopes))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = writeSession(ctx, res); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusCreated, res)
+}
+
+// hardenedSignup answers every valid signup the same way, whether or not
+// the username or email is taken. The outcome is mailed to the email: a
+// verification mail for a new account, or a notice that the account could
+// not be created. The password is hashed either way so the response time
+// doesn't tell the cases apart.
+func (u *User) hardenedSignup(ctx echo.Context, req signupReq) error {
+	var userCount int64
+	err := u.DB.Model(&model.User{}).Where("user_name = ?", req.UserName).Count(&userCount).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	taken, err := emailTaken(u.DB, req.Email)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
 
-	err = u.DB.Create(&user).Error
+	hashedPass, err := utils.HashPassword(req.Password)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
-	token, err := utils.GenerateToken(user.ID)
+	if userCount != 0 || taken {
+		return u.signupRejected(ctx, req, taken)
+	}
+
+	user := model.User{
+		UserName: req.UserName,
+		Email:    req.Email,
+		Password: hashedPass,
+		Roles:    model.Roles{model.RoleUser},
+	}
+
+	err = createUser(u.DB, &user)
+	if duplicateKey(err) {
+		return u.signupRejected(ctx, req, true)
+	}
+
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
-	return ctx.JSON(http.StatusCreated, signupRes{Status: "success", Token: token})
+	if err = sendEmailVerification(ctx.Request().Context(), u.Redis, u.Mailer, user); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusAccepted, signupRes{Status: "pending"})
+}
+
+// signupRejected mails why a hardened signup failed and answers like a
+// successful one.
+func (u *User) signupRejected(ctx echo.Context, req signupReq, emailTaken bool) error {
+	msg := signupRejectedMessage(req, emailTaken)
+	if err := u.Mailer.Send(ctx.Request().Context(), msg); err != nil {
+		log.Errorf("sending signup notice mail failed: %s", err)
+	}
+
+	return ctx.JSON(http.StatusAccepted, signupRes{Status: "pending"})
+}
+
+// emailTaken reports whether the email belongs to an account. An unverified
+// email whose claim expired doesn't count, createUser releases it.
+func emailTaken(db *gorm.DB, email string) (bool, error) {
+	query := db.Model(&model.User{}).Where("email = ?", email)
+	if ttl := config.C.EmailVerification.ClaimTTL; ttl > 0 {
+		query = query.Where("email_verified_at IS NOT NULL OR created_at >= ?", time.Now().Add(-ttl))
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
+
+// createUser inserts the new user. Expired unverified claims of its email
+// are released in the same transaction, so a signup made with somebody
+// else's email can't keep them from signing up.
+func createUser(db *gorm.DB, user *model.User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if user.Email != "" {
+			released, err := releaseExpiredEmailClaims(tx, user.Email)
+			if err != nil {
+				return err
+			}
+
+			if released > 0 {
+				log.Infof("released the email of [%d] unverified users for a signup", released)
+			}
+		}
+
+		return tx.Create(user).Error
+	})
+}
+
+// releaseExpiredEmailClaims clears the email of the accounts which didn't
+// verify it within the claim TTL and returns how many there were.
+func releaseExpiredEmailClaims(tx *gorm.DB, email string) (int64, error) {
+	ttl := config.C.EmailVerification.ClaimTTL
+	if ttl <= 0 {
+		return 0, nil
+	}
+
+	result := tx.Model(&model.User{}).
+		Where("email = ? AND email_verified_at IS NULL AND created_at < ?", email, time.Now().Add(-ttl)).
+		Update("email", "")
+
+	return result.RowsAffected, result.Error
+}
+
+// duplicateKey reports whether the error is a violation of a unique key.
+// Emails are the only unique column of users, so two signups racing for the
+// same email end up here.
+func duplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry
+}
+
+// signupRejectedMessage tells the owner of the email why the signup did
+// not create an account.
+func signupRejectedMessage(req signupReq, emailTaken bool) utils.Message {
+	if emailTaken {
+		return utils.Message{
+			To:      req.Email,
+			Subject: "Your signup",
+			Body: "Hi,\n\nSomebody tried to sign up with this email, but it already belongs to an account. " +
+				"If it was you, log in or reset your password instead. If it wasn't, you can ignore this mail.\n",
+		}
+	}
+
+	return utils.Message{
+		To:      req.Email,
+		Subject: "Your signup",
+		Body: fmt.Sprintf("Hi,\n\nThe username %s is already taken, so no account was created. "+
+			"Please sign up again with another username. If you didn't sign up, you can ignore this mail.\n", req.UserName),
+	}
 }
 
 type loginReq struct {
 	UserName string `json:"user_name"`
 	Password string `json:"password"`
+	Scope    string `json:"scope"`
+}
+
+func (req *loginReq) validate() error {
+	for _, scope := range model.ParseScopes(req.Scope) {
+		if _, ok := model.ScopesMap[scope]; !ok {
+			return errors.New("invalid scope")
+		}
+	}
+
+	return nil
+}
+
+func (req *loginReq) scopes() []string {
+	scopes := model.ParseScopes(req.Scope)
+	if len(scopes) == 0 {
+		scopes = model.DefaultScopes
+	}
+
+	return model.ScopeStrings(scopes)
 }
 
 func (u *User) Login(ctx echo.Context) error {
@@ -96,24 +326,152 @@ func (u *User) Login(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
 	}
 
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	lockedFor, err := utils.LoginLockedFor(ctx.Request().Context(), u.Redis, req.UserName, clientIP(ctx))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if lockedFor > 0 {
+		return tooManyRequests(ctx, lockedFor)
+	}
+
 	var user model.User
 	err = u.DB.Where(model.User{UserName: req.UserName}).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusBadRequest, "invalid username or password")
+			if config.C.HardenedMode {
+				utils.VerifyDummyPassword(req.Password)
+			}
+
+			return u.loginFailed(ctx, req.UserName)
 		}
 
 		return err
 	}
 
 	if err = utils.VerifyPassword(user.Password, req.Password); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid username or password")
+		return u.loginFailed(ctx, req.UserName)
+	}
+
+	if err = utils.ResetLoginFailures(ctx.Request().Context(), u.Redis, req.UserName); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if utils.PasswordNeedsRehash(user.Password) {
+		u.rehashPassword(&user, req.Password)
+	}
+
+	if user.EmailUnverified() && config.C.EmailVerification.Policy == config.EmailVerificationPolicyLogin {
+		return echo.NewHTTPError(http.StatusForbidden, "email is not verified")
+	}
+
+	if user.MFAEnabled {
+		res, err := issueMFAPendingToken(ctx.Request().Context(), u.Redis, user, req.scopes())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+
+		return ctx.JSON(http.StatusOK, res)
+	}
+
+	res, err := issueTokens(ctx, u.Redis, user, req.scopes())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	if err = writeSession(ctx, res); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// validationError returns the error of an invalid request. The failed
+// rules of a password are sent along with the message.
+func validationError(err error) *echo.HTTPError {
+	if policyErr, ok := err.(*utils.PasswordPolicyError); ok {
+		return echo.NewHTTPError(http.StatusBadRequest, policyErr)
 	}
 
-	token, err := utils.GenerateToken(user.ID)
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// loginFailed counts a failed login towards the lockout of the username and
+// the client IP.
+func (u *User) loginFailed(ctx echo.Context, userName string) error {
+	lockedFor, err := utils.RecordLoginFailure(ctx.Request().Context(), u.Redis, userName, clientIP(ctx))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
-	return ctx.JSON(http.StatusOK, signupRes{Status: "success", Token: token})
+	if lockedFor > 0 {
+		return tooManyRequests(ctx, lockedFor)
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, "invalid username or password")
+}
+
+// rehashPassword saves the password hashed with the current settings. A
+// failure is only logged, the old hash still works.
+func (u *User) rehashPassword(user *model.User, password string) {
+	hashedPass, err := utils.HashPassword(password)
+	if err != nil {
+		log.Errorf("rehashing password of user [%d] failed: %s", user.ID, err)
+		return
+	}
+
+	if err = u.DB.Model(user).Update("password", hashedPass).Error; err != nil {
+		log.Errorf("rehashing password of user [%d] failed: %s", user.ID, err)
+	}
+}
+
+// clientIP returns the IP of the client as found by the IP extractor of
+// echo. Without one it is the address of the connection, forwarding headers
+// are never trusted by default.
+func clientIP(ctx echo.Context) string {
+	extractor := ctx.Echo().IPExtractor
+	if extractor == nil {
+		extractor = echo.ExtractIPDirect()
+	}
+
+	return extractor(ctx.Request())
+}
+
+// tooManyRequests tells the client to come back after retryAfter.
+func tooManyRequests(ctx echo.Context, retryAfter time.Duration) error {
+	ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "too many requests")
+}
+
+// issueTokens starts a session for the user on the client of the request
+// and returns its access token and refresh token, limited to the given
+// scopes.
+func issueTokens(ctx echo.Context, redis *goredis.Client, user model.User, scopes []string) (*signupRes, error) {
+	reqCtx := ctx.Request().Context()
+
+	session, err := utils.CreateSession(reqCtx, redis, user.ID, clientIP(ctx), ctx.Request().UserAgent())
+	if err != nil {
+		return nil, err
+	}
+
+	generation, err := utils.TokenGeneration(reqCtx, redis, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: user.ID, Generation: generation, Roles: user.Roles.Strings(), Scopes: scopes, SessionID: session.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateRefreshToken(reqCtx, redis, utils.RefreshTokenGrant{UserID: user.ID, Scopes: scopes, SessionID: session.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &signupRes{Status: "success", Token: token, RefreshToken: refreshToken, Scope: strings.Join(scopes, " ")}, nil
 }
@@ -1,16 +1,31 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"golang-example/model"
+	"golang-example/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 )
 
-const userIDContextField = "user_id"
+const (
+	userIDContextField   = "user_id"
+	apiKeyIDContextField = "api_key_id"
+
+	// deprecationHeader marks responses to requests using a deprecated form.
+	deprecationHeader = "Deprecation"
+)
+
+var errMetaNotFound = errors.New("meta not found")
 
 type UserMeta struct {
 	DB *gorm.DB
@@ -27,67 +42,134 @@ func (um *UserMeta) Update(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
-	params := ctx.QueryParams()
-	var userMetas []model.UserMeta
-	if v, ok := params["age"]; ok {
-		age, err := strconv.Atoi(v[0])
-		if err != nil || age <= 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("invalid age"))
+	updates, err := metaUpdates(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(updates))
+	for key := range updates {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	changes := make(map[string]*string, len(keys))
+	for _, key := range keys {
+		if updates[key] == nil {
+			if !utils.MetaKeyExists(key) {
+				return echo.NewHTTPError(http.StatusBadRequest, utils.ErrMetaKeyInvalid.Error())
+			}
+
+			changes[key] = nil
+			continue
 		}
 
-		userMetas = append(userMetas, model.UserMeta{
-			MetaKey:   model.UMKAge,
-			MetaValue: fmt.Sprint(age),
-			UserID:    id,
-		})
+		value, err := utils.NormalizeMetaValue(key, *updates[key])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		changes[key] = &value
+	}
+
+	if len(changes) == 0 {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	if err = saveMetas(um.DB, ctx, id, changes, false); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// metaUpdates returns the metas to change by their key, a nil value removes
+// the key. They are read from a JSON object in the body like
+// {"age": 30, "gender": null}, or from the query parameters for older
+// clients when the body is empty.
+func metaUpdates(ctx echo.Context) (map[string]*string, error) {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
 	}
 
-	if v, ok := params["gender"]; ok {
-		gender := v[0]
-		if _, ok := model.GendersMap[gender]; !ok {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("invalid gender"))
+	updates := make(map[string]*string)
+	if len(bytes.TrimSpace(body)) == 0 {
+		params := ctx.QueryParams()
+		if len(params) != 0 {
+			ctx.Response().Header().Set(deprecationHeader, "true")
 		}
 
-		userMetas = append(userMetas, model.UserMeta{
-			MetaKey:   model.UMKGender,
-			MetaValue: gender,
-			UserID:    id,
-		})
+		for key := range params {
+			value := params.Get(key)
+			updates[key] = &value
+		}
+
+		return updates, nil
 	}
 
-	if len(userMetas) != 0 {
-		for _, userMeta := range userMetas {
-			result := um.DB.Model(&model.UserMeta{}).
-				Where("user_id = ?", userMeta.UserID).
-				Where("meta_key = ?", userMeta.MetaKey).Update("meta_value", userMeta.MetaValue)
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
 
-			if result.Error != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
-			}
+	for key, raw := range fields {
+		var field interface{}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err = decoder.Decode(&field); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+		}
 
-			if result.RowsAffected == 0 {
-				err = um.DB.Create(&userMeta).Error
-				if err != nil {
-					return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
-				}
+		var value string
+		switch v := field.(type) {
+		case nil:
+			updates[key] = nil
+			continue
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			if !utils.MetaKeyExists(key) {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, utils.ErrMetaKeyInvalid.Error())
 			}
+
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s", key))
 		}
+
+		updates[key] = &value
 	}
 
-	return ctx.NoContent(http.StatusNoContent)
+	return updates, nil
 }
 
 type getReq struct {
-	Key string `query:"key"`
+	Key  string `query:"key"`
+	AsOf string `query:"as_of"`
+
+	asOf time.Time
 }
 
 func (req *getReq) validate() error {
 	if req.Key != "" {
-		if _, ok := model.KeysMap[model.UserMetaKey(req.Key)]; !ok {
-			return errors.New("invalid key")
+		if !utils.MetaKeyExists(req.Key) {
+			return utils.ErrMetaKeyInvalid
 		}
 	}
 
+	if req.AsOf != "" {
+		asOf, err := time.Parse(time.RFC3339, req.AsOf)
+		if err != nil {
+			return errors.New("invalid as_of")
+		}
+
+		req.asOf = asOf
+	}
+
 	return nil
 }
 
@@ -127,13 +209,355 @@ func (um *UserMeta) Get(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
 	}
 
+	if !req.asOf.IsZero() {
+		userMetas, err = metasAsOf(um.DB, id, req.Key, req.asOf, userMetas)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	defaults := utils.MetaKeyDefaults()
 	var response []getRes
 	for _, userMeta := range userMetas {
+		delete(defaults, string(userMeta.MetaKey))
 		response = append(response, getRes{
 			Key:   string(userMeta.MetaKey),
 			Value: userMeta.MetaValue,
 		})
 	}
 
+	// Keys the user never set are returned with their default, if any.
+	for _, key := range utils.MetaKeyNames() {
+		value, ok := defaults[key]
+		if ok && (req.Key == "" || req.Key == key) {
+			response = append(response, getRes{Key: key, Value: value})
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// metasAsOf rewinds the current metas of the user to what they were at the
+// given time. The first change of a key after that time holds the value it
+// had then, and keys not changed since still have their current value.
+func metasAsOf(db *gorm.DB, userID uint, key string, asOf time.Time, current []model.UserMeta) ([]model.UserMeta, error) {
+	firstChanges := db.Model(&model.UserMetaHistory{}).
+		Select("MIN(id)").
+		Where("user_id = ?", userID).
+		Where("created_at > ?", asOf)
+	if key != "" {
+		firstChanges = firstChanges.Where("meta_key = ?", key)
+	}
+
+	var histories []model.UserMetaHistory
+	err := db.Where("id IN (?)", firstChanges.Group("meta_key")).Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(current))
+	for _, userMeta := range current {
+		values[string(userMeta.MetaKey)] = userMeta.MetaValue
+	}
+
+	for _, history := range histories {
+		if history.PreviousValue == nil {
+			delete(values, string(history.MetaKey))
+			continue
+		}
+
+		values[string(history.MetaKey)] = *history.PreviousValue
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	userMetas := make([]model.UserMeta, 0, len(keys))
+	for _, k := range keys {
+		userMetas = append(userMetas, model.UserMeta{MetaKey: model.UserMetaKey(k), MetaValue: values[k], UserID: userID})
+	}
+
+	return userMetas, nil
+}
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type historyReq struct {
+	Key    string `query:"key"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+
+	cursor uint64
+}
+
+func (req *historyReq) validate() error {
+	if req.Key != "" && !utils.MetaKeyExists(req.Key) {
+		return utils.ErrMetaKeyInvalid
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultHistoryLimit
+	}
+
+	if req.Limit < 0 || req.Limit > maxHistoryLimit {
+		return errors.New("invalid limit")
+	}
+
+	if req.Cursor != "" {
+		cursor, err := strconv.ParseUint(req.Cursor, 10, 64)
+		if err != nil || cursor == 0 {
+			return errors.New("invalid cursor")
+		}
+
+		req.cursor = cursor
+	}
+
+	return nil
+}
+
+type historyItemRes struct {
+	Key           string    `json:"key"`
+	PreviousValue *string   `json:"previous_value"`
+	NewValue      *string   `json:"new_value"`
+	ActorID       uint      `json:"actor_id"`
+	ActorAPIKeyID *uint     `json:"actor_api_key_id,omitempty"`
+	IP            string    `json:"ip"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type historyRes struct {
+	Items      []historyItemRes `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// History lists the changes of the metas of the user, the latest first. The
+// next page is requested with the next_cursor of the previous one.
+func (um *UserMeta) History(ctx echo.Context) error {
+	var req historyReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+	err = um.DB.Where(model.User{ID: id}).First(&model.User{}).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	query := um.DB.Where("user_id = ?", id)
+	if req.Key != "" {
+		query = query.Where("meta_key = ?", req.Key)
+	}
+
+	if req.cursor != 0 {
+		query = query.Where("id < ?", req.cursor)
+	}
+
+	// One more than a page tells whether there is a next one.
+	var histories []model.UserMetaHistory
+	err = query.Order("id DESC").Limit(req.Limit + 1).Find(&histories).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	response := historyRes{Items: make([]historyItemRes, 0, len(histories))}
+	if len(histories) > req.Limit {
+		histories = histories[:req.Limit]
+		response.NextCursor = strconv.FormatUint(uint64(histories[req.Limit-1].ID), 10)
+	}
+
+	for _, history := range histories {
+		response.Items = append(response.Items, historyItemRes{
+			Key:           string(history.MetaKey),
+			PreviousValue: history.PreviousValue,
+			NewValue:      history.NewValue,
+			ActorID:       history.ActorID,
+			ActorAPIKeyID: history.ActorAPIKeyID,
+			IP:            history.IP,
+			CreatedAt:     history.CreatedAt,
+		})
+	}
+
 	return ctx.JSON(http.StatusOK, response)
 }
+
+type deleteReq struct {
+	Keys []string `query:"key"`
+}
+
+func (req *deleteReq) validate() error {
+	if len(req.Keys) == 0 {
+		return errors.New("key is required")
+	}
+
+	seen := make(map[string]struct{}, len(req.Keys))
+	keys := req.Keys[:0]
+	for _, key := range req.Keys {
+		if !utils.MetaKeyExists(key) {
+			return utils.ErrMetaKeyInvalid
+		}
+
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+
+	req.Keys = keys
+	return nil
+}
+
+// Delete removes the given meta keys of the user. The key parameter can be
+// repeated to remove several at once; if any of them was never set nothing
+// is removed.
+func (um *UserMeta) Delete(ctx echo.Context) error {
+	var req deleteReq
+	err := ctx.Bind(&req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error in parse request data")
+	}
+
+	if err = req.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	id := ctx.Get(userIDContextField).(uint)
+	err = um.DB.Where(model.User{ID: id}).First(&model.User{}).Error
+	if err == gorm.ErrRecordNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	changes := make(map[string]*string, len(req.Keys))
+	for _, key := range req.Keys {
+		changes[key] = nil
+	}
+
+	err = saveMetas(um.DB, ctx, id, changes, true)
+	if err == errMetaNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// saveMetas applies the changes to the metas of the user in one transaction,
+// a nil value removes the key. Each key whose value actually changes is
+// recorded in the history along with who changed it. With mustExist every
+// key has to be set already, or errMetaNotFound is returned.
+func saveMetas(db *gorm.DB, ctx echo.Context, userID uint, changes map[string]*string, mustExist bool) error {
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current []model.UserMeta
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			Where("meta_key IN ?", keys).
+			Find(&current).Error
+		if err != nil {
+			return err
+		}
+
+		previous := make(map[string]string, len(current))
+		for _, userMeta := range current {
+			previous[string(userMeta.MetaKey)] = userMeta.MetaValue
+		}
+
+		if mustExist && len(previous) < len(keys) {
+			return errMetaNotFound
+		}
+
+		var userMetas []model.UserMeta
+		var removedKeys []string
+		var histories []model.UserMetaHistory
+		for _, key := range keys {
+			value := changes[key]
+			previousValue, ok := previous[key]
+			if value == nil && !ok || value != nil && ok && *value == previousValue {
+				continue
+			}
+
+			history := model.UserMetaHistory{
+				UserID:   userID,
+				MetaKey:  model.UserMetaKey(key),
+				NewValue: value,
+				ActorID:  ctx.Get(userIDContextField).(uint),
+				IP:       clientIP(ctx),
+			}
+
+			if ok {
+				history.PreviousValue = &previousValue
+			}
+
+			if apiKeyID, ok := ctx.Get(apiKeyIDContextField).(uint); ok {
+				history.ActorAPIKeyID = &apiKeyID
+			}
+
+			histories = append(histories, history)
+
+			if value == nil {
+				removedKeys = append(removedKeys, key)
+				continue
+			}
+
+			userMetas = append(userMetas, model.UserMeta{
+				MetaKey:   model.UserMetaKey(key),
+				MetaValue: *value,
+				UserID:    userID,
+			})
+		}
+
+		if len(histories) == 0 {
+			return nil
+		}
+
+		// Keys the user already has are updated in place through the unique
+		// (user_id, meta_key) key, so concurrent requests can't add a key twice.
+		if len(userMetas) != 0 {
+			err = tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}, {Name: "meta_key"}},
+				DoUpdates: clause.AssignmentColumns([]string{"meta_value", "updated_at"}),
+			}).Create(&userMetas).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(removedKeys) != 0 {
+			err = tx.Where("user_id = ?", userID).Where("meta_key IN ?", removedKeys).Delete(&model.UserMeta{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&histories).Error
+	})
+}
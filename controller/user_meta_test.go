@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
@@ -60,7 +61,11 @@ func (suite *UpdateTestSuite) SetupSuite() {
 	})
 }
 
-func (suite *UpdateTestSuite) TearDownSuit() {
+func (suite *UpdateTestSuite) SetupTest() {
+	suite.endpoint = "/metas"
+}
+
+func (suite *UpdateTestSuite) TearDownSuite() {
 	suite.patch.Reset()
 
 	sqlDB, _ := suite.userMeta.DB.DB()
@@ -145,7 +150,21 @@ func (suite *UpdateTestSuite) TestUpdate_Update_EmptyQuery() {
 	require.Equal(http.StatusNoContent, response.Code)
 }
 
-func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMetaDBErr_Failure() {
+// expectCurrentMetas expects the current values of the keys being changed
+// to be read and locked, rows are meta_key and meta_value pairs.
+func expectCurrentMetas(mock sqlmock.Sqlmock, userID uint, keys []driver.Value, rows ...string) {
+	result := sqlmock.NewRows([]string{"meta_key", "meta_value", "user_id"})
+	for i := 0; i+1 < len(rows); i += 2 {
+		result.AddRow(rows[i], rows[i+1], userID)
+	}
+
+	syntax := "^SELECT \\* FROM `user_meta` WHERE user_id = .+ AND meta_key IN \\(.+\\) FOR UPDATE$"
+	mock.ExpectQuery(syntax).
+		WithArgs(append([]driver.Value{userID}, keys...)...).
+		WillReturnRows(result)
+}
+
+func (suite *UpdateTestSuite) TestUpdate_Update_UpsertMetaDBErr_Failure() {
 	require := suite.Require()
 	expectedError := "code=500, message=Internal Server Error"
 
@@ -156,10 +175,11 @@ func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMetaDBErr_Failure() {
 		WithArgs(suite.userID).
 		WillReturnRows(rows)
 
-	syntax = "^UPDATE `user_meta` SET `meta_value`=.+,`updated_at`=.+ WHERE user_id = .+ AND meta_key = .+"
 	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"})
+	syntax = "^INSERT INTO `user_meta` .+ ON DUPLICATE KEY UPDATE `meta_value`=VALUES\\(`meta_value`\\),`updated_at`=VALUES\\(`updated_at`\\)"
 	suite.sqlMock.ExpectExec(syntax).
-		WithArgs("male", time.Now(), suite.userID, model.UMKGender).
+		WithArgs(model.UMKGender, "male", suite.userID, time.Now(), time.Now()).
 		WillReturnError(errors.New("database err"))
 	suite.sqlMock.ExpectRollback()
 
@@ -169,7 +189,7 @@ func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMetaDBErr_Failure() {
 	require.EqualError(err, expectedError)
 }
 
-func (suite *UpdateTestSuite) TestUpdate_Update_CreateMetaDBErr_Failure() {
+func (suite *UpdateTestSuite) TestUpdate_Update_HistoryDBErr_Failure() {
 	require := suite.Require()
 	expectedError := "code=500, message=Internal Server Error"
 
@@ -180,15 +200,12 @@ func (suite *UpdateTestSuite) TestUpdate_Update_CreateMetaDBErr_Failure() {
 		WithArgs(suite.userID).
 		WillReturnRows(rows)
 
-	syntax = "^UPDATE `user_meta` SET `meta_value`=.+,`updated_at`=.+ WHERE user_id = .+ AND meta_key = .+"
 	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"})
+	syntax = "^INSERT INTO `user_meta` .+ ON DUPLICATE KEY UPDATE"
 	suite.sqlMock.ExpectExec(syntax).
-		WithArgs("male", time.Now(), suite.userID, model.UMKGender).
-		WillReturnResult(sqlmock.NewResult(0, 0))
-	suite.sqlMock.ExpectCommit()
-
-	suite.sqlMock.ExpectBegin()
-	syntax = "^INSERT INTO `user_meta`"
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	syntax = "^INSERT INTO `user_meta_histories`"
 	suite.sqlMock.ExpectExec(syntax).
 		WillReturnError(errors.New("database err"))
 	suite.sqlMock.ExpectRollback()
@@ -199,7 +216,7 @@ func (suite *UpdateTestSuite) TestUpdate_Update_CreateMetaDBErr_Failure() {
 	require.EqualError(err, expectedError)
 }
 
-func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMeta_Success() {
+func (suite *UpdateTestSuite) TestUpdate_Update_UpsertMeta_Success() {
 	require := suite.Require()
 
 	rows := sqlmock.NewRows([]string{"id"}).
@@ -209,10 +226,15 @@ func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMeta_Success() {
 		WithArgs(suite.userID).
 		WillReturnRows(rows)
 
-	syntax = "^UPDATE `user_meta` SET `meta_value`=.+,`updated_at`=.+ WHERE user_id = .+ AND meta_key = .+"
 	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"})
+	syntax = "^INSERT INTO `user_meta` .+ ON DUPLICATE KEY UPDATE `meta_value`=VALUES\\(`meta_value`\\),`updated_at`=VALUES\\(`updated_at`\\)"
 	suite.sqlMock.ExpectExec(syntax).
-		WithArgs("male", time.Now(), suite.userID, model.UMKGender).
+		WithArgs(model.UMKGender, "male", suite.userID, time.Now(), time.Now()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	syntax = "^INSERT INTO `user_meta_histories` \\(`user_id`,`meta_key`,`previous_value`,`new_value`,`actor_id`,`actor_api_key_id`,`ip`,`created_at`\\)"
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs(suite.userID, model.UMKGender, nil, "male", suite.userID, nil, "192.0.2.1", time.Now()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	suite.sqlMock.ExpectCommit()
 
@@ -223,7 +245,7 @@ func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMeta_Success() {
 	require.Equal(http.StatusNoContent, response.Code)
 }
 
-func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMeta_TwoKeysSent_Success() {
+func (suite *UpdateTestSuite) TestUpdate_Update_UpsertMeta_TwoKeysSent_Success() {
 	require := suite.Require()
 
 	rows := sqlmock.NewRows([]string{"id"}).
@@ -233,57 +255,585 @@ func (suite *UpdateTestSuite) TestUpdate_Update_UpdateMeta_TwoKeysSent_Success()
 		WithArgs(suite.userID).
 		WillReturnRows(rows)
 
-	syntax = "^UPDATE `user_meta` SET `meta_value`=.+,`updated_at`=.+ WHERE user_id = .+ AND meta_key = .+"
 	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age", "gender"}, "age", "21")
+	syntax = "^INSERT INTO `user_meta` .+ VALUES \\(.+\\),\\(.+\\) ON DUPLICATE KEY UPDATE"
 	suite.sqlMock.ExpectExec(syntax).
-		WithArgs("22", time.Now(), suite.userID, model.UMKAge).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WithArgs(model.UMKAge, "22", suite.userID, time.Now(), time.Now(),
+			model.UMKGender, "male", suite.userID, time.Now(), time.Now()).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	syntax = "^INSERT INTO `user_meta_histories` .+ VALUES \\(.+\\),\\(.+\\)"
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs(suite.userID, model.UMKAge, "21", "22", suite.userID, nil, "192.0.2.1", time.Now(),
+			suite.userID, model.UMKGender, nil, "male", suite.userID, nil, "192.0.2.1", time.Now()).
+		WillReturnResult(sqlmock.NewResult(1, 2))
 	suite.sqlMock.ExpectCommit()
 
-	syntax = "^UPDATE `user_meta` SET `meta_value`=.+,`updated_at`=.+ WHERE user_id = .+ AND meta_key = .+"
+	query := `?gender=male&&age=22`
+	response, err := suite.CallHandler(query)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+}
+
+func (suite *UpdateTestSuite) TestUpdate_Update_UpsertMeta_SameValue_Success() {
+	require := suite.Require()
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID).
+		WillReturnRows(rows)
+
 	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"}, "gender", "male")
+	suite.sqlMock.ExpectCommit()
+
+	query := `?gender=male`
+	response, err := suite.CallHandler(query)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+}
+
+type UpdateBodyTestSuite struct {
+	suite.Suite
+	e        *echo.Echo
+	sqlMock  sqlmock.Sqlmock
+	userMeta UserMeta
+	userID   uint
+}
+
+func (suite *UpdateBodyTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	suite.e = echo.New()
+	suite.userMeta = UserMeta{DB: db}
+	suite.userID = 1
+	config.C = config.Config{}
+}
+
+func (suite *UpdateBodyTestSuite) TearDownTest() {
+	suite.Require().NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *UpdateBodyTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.userMeta.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *UpdateBodyTestSuite) CallHandler(target string, body string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set("user_id", suite.userID)
+	err := suite.userMeta.Update(c)
+
+	return rec, err
+}
+
+func (suite *UpdateBodyTestSuite) expectUser() {
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID).
+		WillReturnRows(rows)
+}
+
+func (suite *UpdateBodyTestSuite) expectUpsert(key model.UserMetaKey, value string) {
+	syntax := "^INSERT INTO `user_meta` .+ ON DUPLICATE KEY UPDATE `meta_value`=VALUES\\(`meta_value`\\),`updated_at`=VALUES\\(`updated_at`\\)"
 	suite.sqlMock.ExpectExec(syntax).
-		WithArgs("male", time.Now(), suite.userID, model.UMKGender).
+		WithArgs(key, value, suite.userID, sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func (suite *UpdateBodyTestSuite) expectHistories(args ...driver.Value) {
+	syntax := "^INSERT INTO `user_meta_histories`"
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func (suite *UpdateBodyTestSuite) TestUpdate_Body_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age", "gender"}, "gender", "female")
+	suite.expectUpsert(model.UMKAge, "30")
+
+	syntax := "^DELETE FROM `user_meta` WHERE user_id = .+ AND meta_key IN \\(.+\\)"
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs(suite.userID, "gender").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.expectHistories(
+		suite.userID, model.UMKAge, nil, "30", suite.userID, nil, "192.0.2.1", sqlmock.AnyArg(),
+		suite.userID, model.UMKGender, "female", nil, suite.userID, nil, "192.0.2.1", sqlmock.AnyArg())
 	suite.sqlMock.ExpectCommit()
 
-	query := `?gender=male&&age=22`
-	response, err := suite.CallHandler(query)
+	response, err := suite.CallHandler("/metas", `{"age": 30, "gender": null}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.Empty(response.Header().Get("Deprecation"))
+}
+
+func (suite *UpdateBodyTestSuite) TestUpdate_Body_RemoveUnsetKey() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"})
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler("/metas", `{"gender": null}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+}
+
+func (suite *UpdateBodyTestSuite) TestUpdate_Body_DeleteMetaDBErr_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"}, "gender", "female")
+
+	syntax := "^DELETE FROM `user_meta`"
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnError(errors.New("database err"))
+	suite.sqlMock.ExpectRollback()
+
+	_, err := suite.CallHandler("/metas", `{"gender": null}`)
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *UpdateBodyTestSuite) TestUpdate_Body_OverridesQuery() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"})
+	suite.expectUpsert(model.UMKGender, "female")
+	suite.expectHistories(suite.userID, model.UMKGender, nil, "female", suite.userID, nil, "192.0.2.1", sqlmock.AnyArg())
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler("/metas?gender=male&age=22", `{"gender": "female"}`)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+}
+
+func (suite *UpdateBodyTestSuite) TestUpdate_Query_Deprecated() {
+	require := suite.Require()
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"gender"})
+	suite.expectUpsert(model.UMKGender, "male")
+	suite.expectHistories(suite.userID, model.UMKGender, nil, "male", suite.userID, nil, "192.0.2.1", sqlmock.AnyArg())
+	suite.sqlMock.ExpectCommit()
+
+	response, err := suite.CallHandler("/metas?gender=male", "")
 
 	require.NoError(err)
 	require.Equal(http.StatusNoContent, response.Code)
+	require.Equal("true", response.Header().Get("Deprecation"))
 }
 
-func (suite *UpdateTestSuite) TestUpdate_Update_CreateMeta_Success() {
+func (suite *UpdateBodyTestSuite) TestUpdate_Body_APIKeyActor() {
 	require := suite.Require()
 
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age"}, "age", "29")
+	suite.expectUpsert(model.UMKAge, "30")
+	suite.expectHistories(suite.userID, model.UMKAge, "29", "30", suite.userID, uint(5), "203.0.113.9", sqlmock.AnyArg())
+	suite.sqlMock.ExpectCommit()
+
+	// The history keeps the address of the connection, not the one the
+	// client claims in forwarding headers.
+	req := httptest.NewRequest(http.MethodPut, "/metas", strings.NewReader(`{"age": 30}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRealIP, "198.51.100.1")
+	req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.2")
+	req.RemoteAddr = "203.0.113.9:1234"
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set("user_id", suite.userID)
+	c.Set("api_key_id", uint(5))
+
+	err := suite.userMeta.Update(c)
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, rec.Code)
+}
+
+func (suite *UpdateBodyTestSuite) TestUpdate_Body_Invalid_Failure() {
+	require := suite.Require()
+
+	cases := map[string]string{
+		`[{"age": 30}]`:         "code=400, message=error in parse request data",
+		`{"age": 30`:            "code=400, message=error in parse request data",
+		`null`:                  "code=400, message=error in parse request data",
+		`{"age": 30.5}`:         "code=400, message=invalid age",
+		`{"age": "thirty"}`:     "code=400, message=invalid age",
+		`{"gender": ["male"]}`:  "code=400, message=invalid gender",
+		`{"height": 180}`:       "code=400, message=invalid key",
+		`{"height": null}`:      "code=400, message=invalid key",
+		`{"height": {"cm": 1}}`: "code=400, message=invalid key",
+	}
+
+	for body, expectedError := range cases {
+		suite.expectUser()
+
+		_, err := suite.CallHandler("/metas", body)
+
+		require.EqualError(err, expectedError, body)
+	}
+}
+
+type DeleteTestSuite struct {
+	suite.Suite
+	e        *echo.Echo
+	sqlMock  sqlmock.Sqlmock
+	userMeta UserMeta
+	userID   uint
+}
+
+func (suite *DeleteTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	suite.e = echo.New()
+	suite.userMeta = UserMeta{DB: db}
+	suite.userID = 1
+	config.C = config.Config{}
+}
+
+func (suite *DeleteTestSuite) TearDownTest() {
+	suite.Require().NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *DeleteTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.userMeta.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *DeleteTestSuite) CallHandler(query string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodDelete, "/metas"+query, nil)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set("user_id", suite.userID)
+	err := suite.userMeta.Delete(c)
+
+	return rec, err
+}
+
+func (suite *DeleteTestSuite) expectUser() {
 	rows := sqlmock.NewRows([]string{"id"}).
 		AddRow(1)
 	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
 	suite.sqlMock.ExpectQuery(syntax).
 		WithArgs(suite.userID).
 		WillReturnRows(rows)
+}
+
+func (suite *DeleteTestSuite) expectHistories() {
+	syntax := "^INSERT INTO `user_meta_histories`"
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
 
-	syntax = "^UPDATE `user_meta` SET `meta_value`=.+,`updated_at`=.+ WHERE user_id = .+ AND meta_key = .+"
+func (suite *DeleteTestSuite) TestDelete_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
 	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age"}, "age", "22")
+	syntax := "^DELETE FROM `user_meta` WHERE user_id = .+ AND meta_key IN \\(.+\\)"
+	suite.sqlMock.ExpectExec(syntax).
+		WithArgs(suite.userID, "age").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	syntax = "^INSERT INTO `user_meta_histories`"
 	suite.sqlMock.ExpectExec(syntax).
-		WithArgs("male", time.Now(), suite.userID, model.UMKGender).
-		WillReturnResult(sqlmock.NewResult(0, 0))
+		WithArgs(suite.userID, model.UMKAge, "22", nil, suite.userID, nil, "192.0.2.1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	suite.sqlMock.ExpectCommit()
 
+	response, err := suite.CallHandler("?key=age")
+
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+}
+
+func (suite *DeleteTestSuite) TestDelete_Bulk_Success() {
+	require := suite.Require()
+
+	suite.expectUser()
 	suite.sqlMock.ExpectBegin()
-	syntax = "^INSERT INTO `user_meta`"
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age", "gender"}, "age", "22", "gender", "male")
+	syntax := "^DELETE FROM `user_meta` WHERE user_id = .+ AND meta_key IN \\(.+,.+\\)"
 	suite.sqlMock.ExpectExec(syntax).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WithArgs(suite.userID, "age", "gender").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	suite.expectHistories()
 	suite.sqlMock.ExpectCommit()
 
-	query := `?gender=male`
-	response, err := suite.CallHandler(query)
+	response, err := suite.CallHandler("?key=age&key=gender&key=age")
 
 	require.NoError(err)
 	require.Equal(http.StatusNoContent, response.Code)
 }
 
+func (suite *DeleteTestSuite) TestDelete_NeverSet_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=meta not found"
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age", "gender"}, "age", "22")
+	suite.sqlMock.ExpectRollback()
+
+	_, err := suite.CallHandler("?key=age&key=gender")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *DeleteTestSuite) TestDelete_DBErr_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	suite.expectUser()
+	suite.sqlMock.ExpectBegin()
+	expectCurrentMetas(suite.sqlMock, suite.userID, []driver.Value{"age"}, "age", "22")
+	syntax := "^DELETE FROM `user_meta`"
+	suite.sqlMock.ExpectExec(syntax).
+		WillReturnError(errors.New("database err"))
+	suite.sqlMock.ExpectRollback()
+
+	_, err := suite.CallHandler("?key=age")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *DeleteTestSuite) TestDelete_UserIDNotFound_Failure() {
+	require := suite.Require()
+	expectedError := "code=404, message=user not found"
+
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := suite.CallHandler("?key=age")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *DeleteTestSuite) TestDelete_InvalidKey_Failure() {
+	require := suite.Require()
+
+	_, err := suite.CallHandler("")
+	require.EqualError(err, "code=400, message=key is required")
+
+	_, err = suite.CallHandler("?key=age&key=height")
+	require.EqualError(err, "code=400, message=invalid key")
+}
+
+type MetaHistoryTestSuite struct {
+	suite.Suite
+	e        *echo.Echo
+	sqlMock  sqlmock.Sqlmock
+	userMeta UserMeta
+	userID   uint
+}
+
+func (suite *MetaHistoryTestSuite) SetupSuite() {
+	sqlMock, db := database.NewMySQLDBGormMock()
+	suite.sqlMock = sqlMock
+
+	suite.e = echo.New()
+	suite.userMeta = UserMeta{DB: db}
+	suite.userID = 1
+	config.C = config.Config{}
+}
+
+func (suite *MetaHistoryTestSuite) TearDownTest() {
+	suite.Require().NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *MetaHistoryTestSuite) TearDownSuite() {
+	sqlDB, _ := suite.userMeta.DB.DB()
+	_ = sqlDB.Close()
+}
+
+func (suite *MetaHistoryTestSuite) CallHandler(handler echo.HandlerFunc, target string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	rec := httptest.NewRecorder()
+	c := suite.e.NewContext(req, rec)
+	c.Set("user_id", suite.userID)
+	err := handler(c)
+
+	return rec, err
+}
+
+func (suite *MetaHistoryTestSuite) expectUser() {
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	syntax := "^SELECT (.+) FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID).
+		WillReturnRows(rows)
+}
+
+func (suite *MetaHistoryTestSuite) historyRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "user_id", "meta_key", "previous_value", "new_value", "actor_id", "actor_api_key_id", "ip", "created_at"})
+}
+
+func (suite *MetaHistoryTestSuite) TestHistory_Success() {
+	require := suite.Require()
+	createdAt := time.Date(2023, 4, 20, 10, 0, 0, 0, time.UTC)
+
+	suite.expectUser()
+	rows := suite.historyRows().
+		AddRow(9, 1, "age", "22", "23", 1, 5, "203.0.113.9", createdAt).
+		AddRow(7, 1, "age", nil, "22", 1, nil, "192.0.2.1", createdAt).
+		AddRow(4, 1, "age", "20", nil, 1, nil, "192.0.2.1", createdAt)
+	syntax := "^SELECT \\* FROM `user_meta_histories` WHERE user_id = .+ AND meta_key = .+ AND id < .+ ORDER BY id DESC LIMIT 3$"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID, "age", uint64(12)).
+		WillReturnRows(rows)
+
+	response, err := suite.CallHandler(suite.userMeta.History, "/metas/history?key=age&limit=2&cursor=12")
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.JSONEq(`{
+		"items": [
+			{"key": "age", "previous_value": "22", "new_value": "23", "actor_id": 1, "actor_api_key_id": 5, "ip": "203.0.113.9", "created_at": "2023-04-20T10:00:00Z"},
+			{"key": "age", "previous_value": null, "new_value": "22", "actor_id": 1, "ip": "192.0.2.1", "created_at": "2023-04-20T10:00:00Z"}
+		],
+		"next_cursor": "7"
+	}`, response.Body.String())
+}
+
+func (suite *MetaHistoryTestSuite) TestHistory_LastPage() {
+	require := suite.Require()
+
+	suite.expectUser()
+	syntax := "^SELECT \\* FROM `user_meta_histories` WHERE user_id = .+ ORDER BY id DESC LIMIT 21$"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID).
+		WillReturnRows(suite.historyRows())
+
+	response, err := suite.CallHandler(suite.userMeta.History, "/metas/history")
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.JSONEq(`{"items": []}`, response.Body.String())
+}
+
+func (suite *MetaHistoryTestSuite) TestHistory_DBErr_Failure() {
+	require := suite.Require()
+	expectedError := "code=500, message=Internal Server Error"
+
+	suite.expectUser()
+	syntax := "^SELECT \\* FROM `user_meta_histories`"
+	suite.sqlMock.ExpectQuery(syntax).
+		WillReturnError(errors.New("database err"))
+
+	_, err := suite.CallHandler(suite.userMeta.History, "/metas/history")
+
+	require.EqualError(err, expectedError)
+}
+
+func (suite *MetaHistoryTestSuite) TestHistory_Invalid_Failure() {
+	require := suite.Require()
+
+	cases := map[string]string{
+		"?key=height":    "code=400, message=invalid key",
+		"?limit=101":     "code=400, message=invalid limit",
+		"?limit=-1":      "code=400, message=invalid limit",
+		"?cursor=abc":    "code=400, message=invalid cursor",
+		"?cursor=0":      "code=400, message=invalid cursor",
+		"?limit=several": "code=400, message=error in parse request data",
+	}
+
+	for query, expectedError := range cases {
+		_, err := suite.CallHandler(suite.userMeta.History, "/metas/history"+query)
+
+		require.EqualError(err, expectedError, query)
+	}
+}
+
+func (suite *MetaHistoryTestSuite) TestGet_AsOf_Success() {
+	require := suite.Require()
+	asOf := time.Date(2023, 4, 20, 10, 0, 0, 0, time.UTC)
+
+	suite.expectUser()
+	rows := sqlmock.NewRows([]string{"meta_key", "meta_value", "user_id"}).
+		AddRow(model.UMKGender, "male", 1).AddRow(model.UMKAge, "30", 1)
+	syntax := "^SELECT (.+) FROM `user_meta` WHERE user_id = (.+)"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID).
+		WillReturnRows(rows)
+
+	// Age changed from 25 after the time and gender was only set since.
+	rows = suite.historyRows().
+		AddRow(3, 1, "age", "25", "30", 1, nil, "192.0.2.1", asOf.Add(time.Hour)).
+		AddRow(4, 1, "gender", nil, "male", 1, nil, "192.0.2.1", asOf.Add(time.Hour))
+	syntax = "^SELECT \\* FROM `user_meta_histories` WHERE id IN \\(SELECT MIN\\(id\\) FROM `user_meta_histories` WHERE user_id = .+ AND created_at > .+ GROUP BY `meta_key`\\)$"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID, asOf).
+		WillReturnRows(rows)
+
+	response, err := suite.CallHandler(suite.userMeta.Get, "/metas?as_of=2023-04-20T10:00:00Z")
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.Equal("[{\"key\":\"age\",\"value\":\"25\"}]\n", response.Body.String())
+}
+
+func (suite *MetaHistoryTestSuite) TestGet_AsOf_RemovedKey() {
+	require := suite.Require()
+	asOf := time.Date(2023, 4, 20, 10, 0, 0, 0, time.UTC)
+
+	suite.expectUser()
+	syntax := "^SELECT (.+) FROM `user_meta` WHERE user_id = (.+) AND meta_key = (.+)"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID, "gender").
+		WillReturnRows(sqlmock.NewRows([]string{"meta_key", "meta_value", "user_id"}))
+
+	rows := suite.historyRows().
+		AddRow(6, 1, "gender", "female", nil, 1, nil, "192.0.2.1", asOf.Add(time.Minute))
+	syntax = "^SELECT \\* FROM `user_meta_histories` WHERE id IN \\(SELECT MIN\\(id\\) FROM `user_meta_histories` WHERE user_id = .+ AND created_at > .+ AND meta_key = .+ GROUP BY `meta_key`\\)$"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(suite.userID, asOf, "gender").
+		WillReturnRows(rows)
+
+	response, err := suite.CallHandler(suite.userMeta.Get, "/metas?key=gender&as_of=2023-04-20T10:00:00Z")
+
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.Equal("[{\"key\":\"gender\",\"value\":\"female\"}]\n", response.Body.String())
+}
+
+func (suite *MetaHistoryTestSuite) TestGet_AsOf_Invalid_Failure() {
+	require := suite.Require()
+	expectedError := "code=400, message=invalid as_of"
+
+	_, err := suite.CallHandler(suite.userMeta.Get, "/metas?as_of=yesterday")
+
+	require.EqualError(err, expectedError)
+}
+
 type GetTestSuite struct {
 	suite.Suite
 	e        *echo.Echo
@@ -324,7 +874,7 @@ func (suite *GetTestSuite) SetupSuite() {
 	})
 }
 
-func (suite *GetTestSuite) TearDownSuit() {
+func (suite *GetTestSuite) TearDownSuite() {
 	suite.patch.Reset()
 
 	sqlDB, _ := suite.userMeta.DB.DB()
@@ -463,6 +1013,18 @@ func TestUpdate(t *testing.T) {
 	suite.Run(t, new(UpdateTestSuite))
 }
 
+func TestUpdateBody(t *testing.T) {
+	suite.Run(t, new(UpdateBodyTestSuite))
+}
+
+func TestDelete(t *testing.T) {
+	suite.Run(t, new(DeleteTestSuite))
+}
+
+func TestMetaHistory(t *testing.T) {
+	suite.Run(t, new(MetaHistoryTestSuite))
+}
+
 func TestGet(t *testing.T) {
 	suite.Run(t, new(GetTestSuite))
 }
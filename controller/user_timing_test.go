@@ -0,0 +1,70 @@
+//go:build timing
+
+package controller
+
+import (
+	"sort"
+	"time"
+)
+
+// The timing tests compare wall-clock durations, which a loaded machine
+// skews, so they only run with -tags timing.
+
+// medianDuration returns the median time fn takes over n runs.
+func medianDuration(n int, fn func()) time.Duration {
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		start := time.Now()
+		fn()
+		durations[i] = time.Since(start)
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[n/2]
+}
+
+// requireSimilar fails unless the durations are within a factor of two of
+// each other, which is far below the gap a password hash makes.
+func (suite *HardenedModeTestSuite) requireSimilar(a time.Duration, b time.Duration) {
+	ratio := float64(a) / float64(b)
+	suite.Require().Truef(ratio > 0.5 && ratio < 2, "durations %s and %s differ too much", a, b)
+}
+
+func (suite *HardenedModeTestSuite) TestHardenedMode_Signup_SimilarTiming() {
+	requestBody := `{"user_name":"username","email":"user@example.com","password":"Aaaaaaaa768!"}`
+
+	created := medianDuration(5, func() {
+		suite.expectSignupLookups(0, 0)
+		suite.expectSignupCreate()
+		_, err := suite.CallHandler(suite.user.Signup, requestBody)
+		suite.Require().NoError(err)
+	})
+
+	taken := medianDuration(5, func() {
+		suite.expectSignupLookups(1, 0)
+		_, err := suite.CallHandler(suite.user.Signup, requestBody)
+		suite.Require().NoError(err)
+	})
+
+	suite.requireSimilar(created, taken)
+	suite.Require().NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *HardenedModeTestSuite) TestHardenedMode_Login_SimilarTiming() {
+	requestBody := `{"user_name":"username","password":"Bbbbbbbb768!"}`
+
+	wrongPassword := medianDuration(5, func() {
+		suite.expectLoginUser(true)
+		_, err := suite.CallHandler(suite.user.Login, requestBody)
+		suite.Require().EqualError(err, "code=400, message=invalid username or password")
+	})
+
+	unknownUser := medianDuration(5, func() {
+		suite.expectLoginUser(false)
+		_, err := suite.CallHandler(suite.user.Login, requestBody)
+		suite.Require().EqualError(err, "code=400, message=invalid username or password")
+	})
+
+	suite.requireSimilar(wrongPassword, unknownUser)
+	suite.Require().NoError(suite.sqlMock.ExpectationsWereMet())
+}
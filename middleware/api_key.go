@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/model"
+	"golang-example/utils"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// APIKeyAuthorized authorizes service to service calls by the X-API-Key
+// header. The request runs as the owner of the key, limited to its scopes.
+func APIKeyAuthorized(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			key := ctx.Request().Header.Get(apiKeyHeader)
+			if key == "" {
+				return ctx.JSON(http.StatusUnauthorized, "Unauthorized")
+			}
+
+			var apiKey model.APIKey
+			err := db.Where("key_hash = ?", utils.HashAPIKey(key)).
+				Where("revoked_at IS NULL").
+				First(&apiKey).Error
+			if err == gorm.ErrRecordNotFound {
+				return ctx.JSON(http.StatusUnauthorized, "Unauthorized")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			ctx.Set(userIDContextField, apiKey.OwnerID)
+			ctx.Set(apiKeyIDContextField, apiKey.ID)
+			ctx.Set(scopesContextField, model.ScopeStrings(apiKey.Scopes))
+
+			return next(ctx)
+		}
+	}
+}
+
+// UserOrAPIKeyAuthorized accepts either an API key or a user token.
+func UserOrAPIKeyAuthorized(redis *goredis.Client, db *gorm.DB) echo.MiddlewareFunc {
+	apiKeyAuthorized := APIKeyAuthorized(db)
+	userAuthorized := UserAuthorized(redis)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		byAPIKey := apiKeyAuthorized(next)
+		byToken := userAuthorized(next)
+
+		return func(ctx echo.Context) error {
+			if ctx.Request().Header.Get(apiKeyHeader) != "" {
+				return byAPIKey(ctx)
+			}
+
+			return byToken(ctx)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"errors"
+	"golang-example/database"
+	"golang-example/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+func apiKeyNewEchoContext(key string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodGet, "/metas", nil)
+	if key != "" {
+		request.Header.Set(apiKeyHeader, key)
+	}
+	response := httptest.NewRecorder()
+	e := echo.New()
+	ctx := e.NewContext(request, response)
+
+	return ctx, response
+}
+
+type APIKeyTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	db      *gorm.DB
+	handler echo.HandlerFunc
+}
+
+func (suite *APIKeyTestSuite) SetupSuite() {
+	suite.sqlMock, suite.db = database.NewMySQLDBGormMock()
+
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+func (suite *APIKeyTestSuite) TestAPIKeyAuthorized_MissingKey() {
+	require := suite.Require()
+
+	ctx, resp := apiKeyNewEchoContext("")
+
+	err := APIKeyAuthorized(suite.db)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (suite *APIKeyTestSuite) TestAPIKeyAuthorized_UnknownKey() {
+	require := suite.Require()
+
+	syntax := "^SELECT (.+) FROM `api_keys` WHERE key_hash = (.+) AND revoked_at IS NULL ORDER BY `api_keys`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(utils.HashAPIKey("gek_unknown")).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	ctx, resp := apiKeyNewEchoContext("gek_unknown")
+
+	err := APIKeyAuthorized(suite.db)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (suite *APIKeyTestSuite) TestAPIKeyAuthorized_DBErr() {
+	require := suite.Require()
+
+	syntax := "^SELECT (.+) FROM `api_keys` WHERE key_hash = (.+) AND revoked_at IS NULL ORDER BY `api_keys`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(utils.HashAPIKey("gek_key")).
+		WillReturnError(errors.New("database err"))
+
+	ctx, _ := apiKeyNewEchoContext("gek_key")
+
+	err := APIKeyAuthorized(suite.db)(suite.handler)(ctx)
+	require.EqualError(err, "database err")
+}
+
+func (suite *APIKeyTestSuite) TestAPIKeyAuthorized_Success() {
+	require := suite.Require()
+
+	rows := sqlmock.NewRows([]string{"id", "owner_id", "scopes"}).
+		AddRow(1, 7, "metas:read profile:read")
+	syntax := "^SELECT (.+) FROM `api_keys` WHERE key_hash = (.+) AND revoked_at IS NULL ORDER BY `api_keys`.`id` LIMIT 1"
+	suite.sqlMock.ExpectQuery(syntax).
+		WithArgs(utils.HashAPIKey("gek_key")).
+		WillReturnRows(rows)
+
+	ctx, resp := apiKeyNewEchoContext("gek_key")
+
+	err := APIKeyAuthorized(suite.db)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusOK, resp.Code)
+	require.Equal(uint(7), ctx.Get(userIDContextField))
+	require.Equal(uint(1), ctx.Get(apiKeyIDContextField))
+	require.Equal([]string{"metas:read", "profile:read"}, ctx.Get(scopesContextField))
+}
+
+func (suite *APIKeyTestSuite) TestUserOrAPIKeyAuthorized_FallsBackToToken() {
+	require := suite.Require()
+
+	ctx, resp := apiKeyNewEchoContext("")
+
+	err := UserOrAPIKeyAuthorized(nil, suite.db)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func TestAPIKeyAuthorized(t *testing.T) {
+	suite.Run(t, new(APIKeyTestSuite))
+}
@@ -1,28 +1,89 @@
 package middleware
 
 import (
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
+	"golang-example/config"
 	"golang-example/utils"
 	"net/http"
+	"strings"
 )
 
 const (
-	authorization      = "authorization"
-	userIDContextField = "user_id"
+	authorization              = "authorization"
+	bearerPrefix               = "Bearer "
+	userIDContextField         = "user_id"
+	tokenIDContextField        = "token_id"
+	tokenExpiresAtContextField = "token_expires_at"
+	rolesContextField          = "roles"
+	scopesContextField         = "scopes"
+	sessionIDContextField      = "session_id"
+	apiKeyIDContextField       = "api_key_id"
 )
 
-func UserAuthorized() echo.MiddlewareFunc {
+// UserAuthorized authorizes requests by the access token sent as
+// "Authorization: Bearer <token>" or, in cookie mode, in the session cookie.
+// A bare token in the header is still accepted for older clients.
+func UserAuthorized(redis *goredis.Client) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) error {
-			token := ctx.Request().Header.Get(authorization)
-			id, err := utils.ValidateToken(token)
+			token := accessToken(ctx)
+			claims, err := utils.ValidateToken(token)
 			if err != nil {
 				return ctx.JSON(http.StatusUnauthorized, "Unauthorized")
 			}
 
-			ctx.Set(userIDContextField, id)
+			err = utils.CheckTokenRevoked(ctx.Request().Context(), redis, claims)
+			if err == utils.ErrTokenRevoked {
+				return ctx.JSON(http.StatusUnauthorized, "Unauthorized")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if claims.SessionID != "" {
+				err = utils.TouchSession(ctx.Request().Context(), redis, claims.ID, claims.SessionID, claims.Id)
+				if err == utils.ErrSessionNotFound {
+					return ctx.JSON(http.StatusUnauthorized, "Unauthorized")
+				}
+
+				if err != nil {
+					return err
+				}
+
+				ctx.Set(sessionIDContextField, claims.SessionID)
+			}
+
+			ctx.Set(userIDContextField, claims.ID)
+			ctx.Set(tokenIDContextField, claims.Id)
+			ctx.Set(tokenExpiresAtContextField, claims.ExpiresAt)
+			ctx.Set(rolesContextField, claims.Roles)
+			ctx.Set(scopesContextField, claims.Scopes())
 
 			return next(ctx)
 		}
 	}
 }
+
+func accessToken(ctx echo.Context) string {
+	header := ctx.Request().Header.Get(authorization)
+	if header != "" {
+		if len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+			return header[len(bearerPrefix):]
+		}
+
+		return header
+	}
+
+	if !config.C.Session.CookieMode {
+		return ""
+	}
+
+	cookie, err := ctx.Cookie(config.C.Session.CookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
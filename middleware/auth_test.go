@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+func authNewEchoContext(token string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodGet, "/metas", nil)
+	request.Header.Set(authorization, token)
+	response := httptest.NewRecorder()
+	e := echo.New()
+	ctx := e.NewContext(request, response)
+
+	return ctx, response
+}
+
+type AuthTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+	handler     echo.HandlerFunc
+}
+
+func (suite *AuthTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.redisServer = server
+	suite.redisClient = client
+
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+}
+
+func (suite *AuthTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(context.Background())
+}
+
+func (suite *AuthTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_InvalidToken() {
+	require := suite.Require()
+
+	ctx, resp := authNewEchoContext("invalid")
+
+	err := UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_Success() {
+	require := suite.Require()
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+
+	ctx, resp := authNewEchoContext(token)
+
+	err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusOK, resp.Code)
+	require.Equal(uint(1), ctx.Get(userIDContextField))
+	require.NotEmpty(ctx.Get(tokenIDContextField))
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_RevokedToken() {
+	require := suite.Require()
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+
+	claims, err := utils.ValidateToken(token)
+	require.NoError(err)
+
+	err = utils.RevokeToken(context.Background(), suite.redisClient, claims.Id, claims.ExpiresAt)
+	require.NoError(err)
+
+	ctx, resp := authNewEchoContext(token)
+
+	err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_OldGeneration() {
+	require := suite.Require()
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+
+	err = utils.RevokeUserTokens(context.Background(), suite.redisClient, 1)
+	require.NoError(err)
+
+	ctx, resp := authNewEchoContext(token)
+
+	err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_Session() {
+	require := suite.Require()
+
+	session, err := utils.CreateSession(context.Background(), suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1, SessionID: session.ID})
+	require.NoError(err)
+
+	ctx, resp := authNewEchoContext(token)
+
+	err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusOK, resp.Code)
+	require.Equal(session.ID, ctx.Get(sessionIDContextField))
+
+	// The session is tied to the token it was last seen with.
+	sessions, err := utils.UserSessions(context.Background(), suite.redisClient, 1)
+	require.NoError(err)
+	require.Len(sessions, 1)
+	require.Equal(ctx.Get(tokenIDContextField), sessions[0].TokenID)
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_EndedSession() {
+	require := suite.Require()
+
+	session, err := utils.CreateSession(context.Background(), suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1, SessionID: session.ID})
+	require.NoError(err)
+
+	_, err = utils.DeleteSession(context.Background(), suite.redisClient, 1, session.ID)
+	require.NoError(err)
+
+	ctx, resp := authNewEchoContext(token)
+
+	err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_BearerToken() {
+	require := suite.Require()
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+
+	ctx, resp := authNewEchoContext("Bearer " + token)
+
+	err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusOK, resp.Code)
+	require.Equal(uint(1), ctx.Get(userIDContextField))
+}
+
+func (suite *AuthTestSuite) TestUserAuthorized_Cookie() {
+	require := suite.Require()
+
+	token, err := utils.GenerateToken(utils.TokenParams{UserID: 1})
+	require.NoError(err)
+
+	testCases := map[string]struct {
+		cookieMode   bool
+		expectedCode int
+	}{
+		"Cookie mode disabled": {cookieMode: false, expectedCode: http.StatusUnauthorized},
+		"Cookie mode enabled":  {cookieMode: true, expectedCode: http.StatusOK},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			config.C.Session = config.Session{CookieMode: v.cookieMode, CookieName: "access_token"}
+			defer func() { config.C.Session = config.Session{} }()
+
+			ctx, resp := authNewEchoContext("")
+			ctx.Request().AddCookie(&http.Cookie{Name: "access_token", Value: token})
+
+			err = UserAuthorized(suite.redisClient)(suite.handler)(ctx)
+			require.NoError(err)
+			require.Equal(v.expectedCode, resp.Code)
+		})
+	}
+}
+
+func TestUserAuthorized(t *testing.T) {
+	suite.Run(t, new(AuthTestSuite))
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/utils"
+	"net/http"
+)
+
+// CSRFProtected requires a double-submit CSRF token on state-changing
+// requests that carry session cookies. Requests with an Authorization
+// header are left alone since browsers never attach it on their own.
+func CSRFProtected() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if !config.C.Session.CookieMode || !stateChanging(ctx.Request().Method) {
+				return next(ctx)
+			}
+
+			if ctx.Request().Header.Get(authorization) != "" || !hasSessionCookie(ctx) {
+				return next(ctx)
+			}
+
+			var csrfCookie string
+			if cookie, err := ctx.Cookie(config.C.Session.CSRFCookieName); err == nil {
+				csrfCookie = cookie.Value
+			}
+
+			if !utils.VerifyCSRFToken(csrfCookie, ctx.Request().Header.Get(config.C.Session.CSRFHeader)) {
+				return ctx.JSON(http.StatusForbidden, "Forbidden")
+			}
+
+			return next(ctx)
+		}
+	}
+}
+
+func stateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+func hasSessionCookie(ctx echo.Context) bool {
+	for _, name := range []string{config.C.Session.CookieName, config.C.Session.RefreshCookieName} {
+		if _, err := ctx.Cookie(name); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
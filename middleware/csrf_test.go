@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"golang-example/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type CSRFTestSuite struct {
+	suite.Suite
+	handler echo.HandlerFunc
+}
+
+func (suite *CSRFTestSuite) SetupSuite() {
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+func (suite *CSRFTestSuite) SetupTest() {
+	config.C = config.Config{
+		Session: config.Session{
+			CookieMode:        true,
+			CookieName:        "access_token",
+			RefreshCookieName: "refresh_token",
+			CSRFCookieName:    "csrf_token",
+			CSRFHeader:        "X-CSRF-Token",
+		},
+	}
+}
+
+func (suite *CSRFTestSuite) TestCSRFProtected() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		method        string
+		cookies       map[string]string
+		headers       map[string]string
+		disableCookie bool
+		expectedCode  int
+	}{
+		"Safe method": {
+			method:       http.MethodGet,
+			cookies:      map[string]string{"access_token": "token"},
+			expectedCode: http.StatusOK,
+		},
+		"No session cookie": {
+			method:       http.MethodPut,
+			expectedCode: http.StatusOK,
+		},
+		"Bearer token": {
+			method:       http.MethodPut,
+			cookies:      map[string]string{"access_token": "token"},
+			headers:      map[string]string{"Authorization": "Bearer token"},
+			expectedCode: http.StatusOK,
+		},
+		"Cookie mode disabled": {
+			method:        http.MethodPut,
+			cookies:       map[string]string{"access_token": "token"},
+			disableCookie: true,
+			expectedCode:  http.StatusOK,
+		},
+		"Missing token": {
+			method:       http.MethodPut,
+			cookies:      map[string]string{"access_token": "token", "csrf_token": "csrf"},
+			expectedCode: http.StatusForbidden,
+		},
+		"Missing cookie": {
+			method:       http.MethodPut,
+			cookies:      map[string]string{"access_token": "token"},
+			headers:      map[string]string{"X-CSRF-Token": "csrf"},
+			expectedCode: http.StatusForbidden,
+		},
+		"Mismatch": {
+			method:       http.MethodPost,
+			cookies:      map[string]string{"refresh_token": "token", "csrf_token": "csrf"},
+			headers:      map[string]string{"X-CSRF-Token": "other"},
+			expectedCode: http.StatusForbidden,
+		},
+		"Match": {
+			method:       http.MethodPut,
+			cookies:      map[string]string{"access_token": "token", "csrf_token": "csrf"},
+			headers:      map[string]string{"X-CSRF-Token": "csrf"},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			config.C.Session.CookieMode = !v.disableCookie
+
+			request := httptest.NewRequest(v.method, "/metas", nil)
+			for name, value := range v.cookies {
+				request.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			for name, value := range v.headers {
+				request.Header.Set(name, value)
+			}
+			response := httptest.NewRecorder()
+			ctx := echo.New().NewContext(request, response)
+
+			err := CSRFProtected()(suite.handler)(ctx)
+			require.NoError(err)
+			require.Equal(v.expectedCode, response.Code)
+		})
+	}
+}
+
+func TestCSRFProtected(t *testing.T) {
+	suite.Run(t, new(CSRFTestSuite))
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/model"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// RequireVerifiedEmail blocks users with an unverified email when the email
+// verification policy is set to the given one. It has to run after
+// UserAuthorized or APIKeyAuthorized.
+func RequireVerifiedEmail(db *gorm.DB, policy string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if config.C.EmailVerification.Policy != policy {
+				return next(ctx)
+			}
+
+			id, _ := ctx.Get(userIDContextField).(uint)
+
+			var user model.User
+			err := db.Select("id", "email", "email_verified_at").Where(model.User{ID: id}).First(&user).Error
+			if err == gorm.ErrRecordNotFound {
+				return ctx.JSON(http.StatusUnauthorized, "Unauthorized")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if user.EmailUnverified() {
+				return ctx.JSON(http.StatusForbidden, "email is not verified")
+			}
+
+			return next(ctx)
+		}
+	}
+}
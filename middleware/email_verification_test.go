@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"golang-example/config"
+	"golang-example/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type RequireVerifiedEmailTestSuite struct {
+	suite.Suite
+	sqlMock sqlmock.Sqlmock
+	db      *gorm.DB
+	handler echo.HandlerFunc
+}
+
+func (suite *RequireVerifiedEmailTestSuite) SetupSuite() {
+	suite.sqlMock, suite.db = database.NewMySQLDBGormMock()
+
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+func (suite *RequireVerifiedEmailTestSuite) TearDownTest() {
+	config.C.EmailVerification = config.EmailVerification{}
+}
+
+func (suite *RequireVerifiedEmailTestSuite) call() *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPut, "/metas", nil)
+	response := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, response)
+	ctx.Set(userIDContextField, uint(1))
+
+	err := RequireVerifiedEmail(suite.db, config.EmailVerificationPolicyMetaUpdates)(suite.handler)(ctx)
+	suite.Require().NoError(err)
+
+	return response
+}
+
+func (suite *RequireVerifiedEmailTestSuite) TestRequireVerifiedEmail_OtherPolicy() {
+	require := suite.Require()
+
+	config.C.EmailVerification.Policy = config.EmailVerificationPolicyLogin
+
+	require.Equal(http.StatusOK, suite.call().Code)
+	require.NoError(suite.sqlMock.ExpectationsWereMet())
+}
+
+func (suite *RequireVerifiedEmailTestSuite) TestRequireVerifiedEmail() {
+	config.C.EmailVerification.Policy = config.EmailVerificationPolicyMetaUpdates
+	syntax := "^SELECT `id`,`email`,`email_verified_at` FROM `users` WHERE `users`.`id` = (.+) ORDER BY `users`.`id` LIMIT 1"
+
+	tests := map[string]struct {
+		email      string
+		verifiedAt *time.Time
+		status     int
+	}{
+		"Unverified": {email: "user@example.com", status: http.StatusForbidden},
+		"Verified":   {email: "user@example.com", verifiedAt: &time.Time{}, status: http.StatusOK},
+		"NoEmail":    {status: http.StatusOK},
+	}
+
+	for name, test := range tests {
+		suite.Run(name, func() {
+			suite.sqlMock.ExpectQuery(syntax).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email", "email_verified_at"}).AddRow(1, test.email, test.verifiedAt))
+
+			suite.Require().Equal(test.status, suite.call().Code)
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	suite.Run(t, new(RequireVerifiedEmailTestSuite))
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"net"
+)
+
+// IPExtractor returns how the client IP of a request is found. Without
+// trusted proxies it is the address of the connection, so clients can't
+// pick their IP by sending X-Forwarded-For or X-Real-IP. Behind proxies the
+// X-Forwarded-For entries added by the configured proxies are skipped and
+// the first address before them is the client.
+func IPExtractor() (echo.IPExtractor, error) {
+	if len(config.C.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range config.C.TrustedProxies {
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy [%s] is not a CIDR: %w", proxy, err)
+		}
+
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type IPExtractorTestSuite struct {
+	suite.Suite
+}
+
+func (suite *IPExtractorTestSuite) TearDownTest() {
+	config.C.TrustedProxies = nil
+}
+
+func (suite *IPExtractorTestSuite) request(remoteAddr string, forwardedFor string) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = remoteAddr + ":1234"
+	request.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+	request.Header.Set(echo.HeaderXRealIP, "198.51.100.1")
+
+	return request
+}
+
+func (suite *IPExtractorTestSuite) TestIPExtractor_Direct() {
+	require := suite.Require()
+
+	extractor, err := IPExtractor()
+	require.NoError(err)
+	require.Equal("10.0.0.1", extractor(suite.request("10.0.0.1", "203.0.113.7")))
+	require.Equal("127.0.0.1", extractor(suite.request("127.0.0.1", "203.0.113.7")))
+}
+
+func (suite *IPExtractorTestSuite) TestIPExtractor_TrustedProxies() {
+	require := suite.Require()
+	config.C.TrustedProxies = []string{"10.0.0.0/24"}
+
+	extractor, err := IPExtractor()
+	require.NoError(err)
+
+	// The proxy appends the address it saw, anything before it came from
+	// the client and is ignored.
+	require.Equal("192.0.2.5", extractor(suite.request("10.0.0.1", "203.0.113.7, 192.0.2.5")))
+	require.Equal("192.0.2.5", extractor(suite.request("10.0.0.1", "203.0.113.7, 192.0.2.5, 10.0.0.2")))
+
+	// Requests which didn't come through a trusted proxy keep their address.
+	require.Equal("10.0.1.1", extractor(suite.request("10.0.1.1", "203.0.113.7")))
+	require.Equal("127.0.0.1", extractor(suite.request("127.0.0.1", "203.0.113.7")))
+}
+
+func (suite *IPExtractorTestSuite) TestIPExtractor_InvalidProxy() {
+	config.C.TrustedProxies = []string{"10.0.0.1"}
+
+	_, err := IPExtractor()
+	suite.Require().Error(err)
+}
+
+func TestIPExtractor(t *testing.T) {
+	suite.Run(t, new(IPExtractorTestSuite))
+}
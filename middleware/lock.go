@@ -1,49 +1,113 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	goredis "github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
 	"golang-example/config"
+	"golang-example/utils"
+	"io"
 	"net/http"
 )
 
+const lockKeyPrefix = "meta_lock"
+
+// Lock keeps concurrent requests of a user from changing the same metas. The
+// keys are the query parameters and the fields of a JSON object body, or the
+// keys being deleted. Only defined meta keys can be locked.
 func Lock(redis *goredis.Client) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) error {
-			keys := ctx.QueryParams()
-			userID := ctx.Get(userIDContextField)
-			var redisKeys []string
-			for k := range keys {
-				redisKey := fmt.Sprintf("%s:%v", k, userID)
-				redisKeys = append(redisKeys, redisKey)
+			keys, err := lockKeys(ctx)
+			if err != nil {
+				return err
+			}
 
-				_, err := redis.Get(ctx.Request().Context(), redisKey).Result()
-				if err == nil {
-					return ctx.NoContent(http.StatusTooManyRequests)
+			for _, k := range keys {
+				if !utils.MetaKeyExists(k) {
+					return echo.NewHTTPError(http.StatusBadRequest, utils.ErrMetaKeyInvalid.Error())
 				}
+			}
 
-				if err != goredis.Nil {
-					return err
-				}
+			userID := ctx.Get(userIDContextField)
+			var redisKeys []string
+			for _, k := range keys {
+				redisKey := fmt.Sprintf("%s:%s:%v", lockKeyPrefix, k, userID)
 
-				err = redis.Set(ctx.Request().Context(), redisKey, userID, config.C.LockTTL).Err()
+				locked, err := redis.SetNX(ctx.Request().Context(), redisKey, userID, config.C.LockTTL).Result()
 				if err != nil {
+					unlock(ctx, redis, redisKeys)
 					return err
 				}
+
+				if !locked {
+					unlock(ctx, redis, redisKeys)
+					return ctx.NoContent(http.StatusTooManyRequests)
+				}
+
+				redisKeys = append(redisKeys, redisKey)
 			}
 
 			nerr := next(ctx)
 
-			for _, key := range redisKeys {
-				err := redis.Del(ctx.Request().Context(), key).Err()
-				if err != nil {
-					log.Errorf("redis delete failed key [%s] : %s", key, err)
-				}
-			}
+			unlock(ctx, redis, redisKeys)
 
 			return nerr
 		}
 	}
 }
+
+func unlock(ctx echo.Context, redis *goredis.Client, keys []string) {
+	for _, key := range keys {
+		err := redis.Del(ctx.Request().Context(), key).Err()
+		if err != nil {
+			log.Errorf("redis delete failed key [%s] : %s", key, err)
+		}
+	}
+}
+
+// lockKeys returns the keys a request changes. A delete names them in its
+// key parameters. The body is put back for the handler after it is read.
+func lockKeys(ctx echo.Context) ([]string, error) {
+	if ctx.Request().Method == http.MethodDelete {
+		var keys []string
+		seen := make(map[string]struct{})
+		for _, k := range ctx.QueryParams()["key"] {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+
+		return keys, nil
+	}
+
+	var keys []string
+	for k := range ctx.QueryParams() {
+		keys = append(keys, k)
+	}
+
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	// A body which isn't a JSON object is rejected by the handler.
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return keys, nil
+	}
+
+	for k := range fields {
+		if _, ok := ctx.QueryParams()[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, nil
+}
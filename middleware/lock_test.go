@@ -4,8 +4,10 @@ import (
 	"context"
 	"github.com/alicebob/miniredis/v2"
 	"golang-example/database"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	goredis "github.com/go-redis/redis/v8"
@@ -59,7 +61,7 @@ func (suite *LockTestSuite) TestSuccess() {
 	require.NoError(err)
 	require.Equal(resp.Code, http.StatusOK)
 
-	_, err = suite.redisServer.Get("gender:1")
+	_, err = suite.redisServer.Get("meta_lock:gender:1")
 	require.EqualError(err, expectedErrorMessage)
 }
 
@@ -68,7 +70,7 @@ func (suite *LockTestSuite) TestTooManyRequests() {
 
 	ctx, resp := lockNewEchoContext()
 
-	err := suite.redisServer.Set("gender:1", "1")
+	err := suite.redisServer.Set("meta_lock:gender:1", "1")
 	require.NoError(err)
 
 	err = Lock(suite.redisClient)(suite.handler)(ctx)
@@ -76,6 +78,93 @@ func (suite *LockTestSuite) TestTooManyRequests() {
 	require.Equal(http.StatusTooManyRequests, resp.Code)
 }
 
+func (suite *LockTestSuite) TestBodyKeys() {
+	require := suite.Require()
+
+	request := httptest.NewRequest(http.MethodPut, "/metas", strings.NewReader(`{"age":30,"gender":null}`))
+	response := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, response)
+	ctx.Set(userIDContextField, 1)
+
+	var body []byte
+	var locked []string
+	handler := func(ctx echo.Context) error {
+		body, _ = io.ReadAll(ctx.Request().Body)
+		locked = suite.redisServer.Keys()
+		return ctx.NoContent(http.StatusOK)
+	}
+
+	err := Lock(suite.redisClient)(handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusOK, response.Code)
+	require.Equal(`{"age":30,"gender":null}`, string(body))
+	require.ElementsMatch([]string{"meta_lock:age:1", "meta_lock:gender:1"}, locked)
+	require.Empty(suite.redisServer.Keys())
+
+	err = suite.redisServer.Set("meta_lock:age:1", "1")
+	require.NoError(err)
+
+	request = httptest.NewRequest(http.MethodPut, "/metas", strings.NewReader(`{"age":30}`))
+	response = httptest.NewRecorder()
+	ctx = echo.New().NewContext(request, response)
+	ctx.Set(userIDContextField, 1)
+
+	err = Lock(suite.redisClient)(handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusTooManyRequests, response.Code)
+}
+
+func (suite *LockTestSuite) TestDeleteKeys() {
+	require := suite.Require()
+
+	request := httptest.NewRequest(http.MethodDelete, "/metas?key=age&key=gender&key=age", nil)
+	response := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, response)
+	ctx.Set(userIDContextField, 1)
+
+	var locked []string
+	handler := func(ctx echo.Context) error {
+		locked = suite.redisServer.Keys()
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	err := Lock(suite.redisClient)(handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusNoContent, response.Code)
+	require.ElementsMatch([]string{"meta_lock:age:1", "meta_lock:gender:1"}, locked)
+	require.Empty(suite.redisServer.Keys())
+}
+
+func (suite *LockTestSuite) TestUnknownKey() {
+	require := suite.Require()
+
+	request := httptest.NewRequest(http.MethodPut, "/metas", strings.NewReader(`{"age":30,"token_generation":1}`))
+	response := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, response)
+	ctx.Set(userIDContextField, 1)
+
+	err := Lock(suite.redisClient)(suite.handler)(ctx)
+	require.EqualError(err, "code=400, message=invalid key")
+	require.Empty(suite.redisServer.Keys())
+}
+
+func (suite *LockTestSuite) TestReleasesAcquiredKeys() {
+	require := suite.Require()
+
+	err := suite.redisServer.Set("meta_lock:gender:1", "1")
+	require.NoError(err)
+
+	request := httptest.NewRequest(http.MethodDelete, "/metas?key=age&key=gender", nil)
+	response := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, response)
+	ctx.Set(userIDContextField, 1)
+
+	err = Lock(suite.redisClient)(suite.handler)(ctx)
+	require.NoError(err)
+	require.Equal(http.StatusTooManyRequests, response.Code)
+	require.Equal([]string{"meta_lock:gender:1"}, suite.redisServer.Keys())
+}
+
 func TestLock(t *testing.T) {
 	suite.Run(t, new(LockTestSuite))
 }
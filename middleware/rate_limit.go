@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang-example/config"
+	"golang-example/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const rateLimitKeyPrefix = "rate_limit"
+
+// tokenBucket takes a token from the bucket in KEYS[1], which holds up to
+// ARGV[1] tokens and gets one back every ARGV[2] microseconds. The time
+// comes from the Redis server, so every instance of the app sees the same
+// clock. It returns whether the request is allowed, the tokens left, and
+// the microseconds until the next token and until the bucket is full.
+var tokenBucket = goredis.NewScript(`
+redis.replicate_commands()
+
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) / interval)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+local retry = 0
+if allowed == 0 then
+	retry = math.ceil((1 - tokens) * interval)
+end
+
+local reset = math.ceil((capacity - tokens) * interval)
+
+redis.call('HMSET', KEYS[1], 'tokens', tokens, 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000) + 1000)
+
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// RateLimit limits the requests of the route by the rate limit configured
+// under name. It has to run after the authorization middleware when the
+// limit is by user id or API key; requests without one are limited by IP.
+func RateLimit(redis *goredis.Client, name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			limit, ok := config.C.RateLimits[name]
+			if !ok || limit.Limit <= 0 || limit.Period <= 0 {
+				return next(ctx)
+			}
+
+			key := fmt.Sprintf("%s:%s:%s", rateLimitKeyPrefix, name, rateLimitKey(ctx, limit.Key))
+			interval := limit.Period.Microseconds() / limit.Limit
+			if interval < 1 {
+				interval = 1
+			}
+
+			res, err := tokenBucket.Run(ctx.Request().Context(), redis, []string{key}, limit.Limit, interval).Result()
+			if err != nil {
+				return err
+			}
+
+			reply, ok := res.([]interface{})
+			if !ok || len(reply) != 4 {
+				return fmt.Errorf("unexpected rate limit reply: %v", res)
+			}
+
+			values := make([]int64, len(reply))
+			for i, v := range reply {
+				values[i], _ = v.(int64)
+			}
+
+			allowed, remaining, retryAfter, reset := values[0] == 1, values[1], values[2], values[3]
+
+			header := ctx.Response().Header()
+			header.Set("RateLimit-Limit", strconv.FormatInt(limit.Limit, 10))
+			header.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			header.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(reset), 10))
+
+			if !allowed {
+				header.Set("Retry-After", strconv.FormatInt(ceilSeconds(retryAfter), 10))
+				return ctx.JSON(http.StatusTooManyRequests, "too many requests")
+			}
+
+			return next(ctx)
+		}
+	}
+}
+
+// rateLimitKey returns who the request is counted for. API keys are hashed
+// so they don't end up in Redis in plain text.
+func rateLimitKey(ctx echo.Context, kind string) string {
+	switch kind {
+	case config.RateLimitKeyAPIKey:
+		if key := ctx.Request().Header.Get(apiKeyHeader); key != "" {
+			return "api_key:" + utils.HashAPIKey(key)
+		}
+
+		fallthrough
+	case config.RateLimitKeyUserID:
+		if userID := ctx.Get(userIDContextField); userID != nil {
+			return fmt.Sprintf("user_id:%v", userID)
+		}
+	}
+
+	return "ip:" + ctx.RealIP()
+}
+
+func ceilSeconds(microseconds int64) int64 {
+	return (microseconds + int64(time.Second/time.Microsecond) - 1) / int64(time.Second/time.Microsecond)
+}
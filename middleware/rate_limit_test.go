@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"golang-example/config"
+	"golang-example/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+func rateLimitNewEchoContext(ip string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodPost, "/login", nil)
+	request.RemoteAddr = ip + ":1234"
+	response := httptest.NewRecorder()
+	e := echo.New()
+	ctx := e.NewContext(request, response)
+
+	return ctx, response
+}
+
+type RateLimitTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+	handler     echo.HandlerFunc
+	now         time.Time
+}
+
+func (suite *RateLimitTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.redisServer = server
+	suite.redisClient = client
+
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+func (suite *RateLimitTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(context.Background())
+	suite.now = time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	suite.redisServer.SetTime(suite.now)
+	config.C.RateLimits = config.RateLimits{
+		"login": {Key: config.RateLimitKeyIP, Limit: 3, Period: time.Minute},
+		"metas": {Key: config.RateLimitKeyUserID, Limit: 2, Period: time.Minute},
+		"keys":  {Key: config.RateLimitKeyAPIKey, Limit: 1, Period: time.Minute},
+	}
+}
+
+func (suite *RateLimitTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+	config.C.RateLimits = nil
+}
+
+func (suite *RateLimitTestSuite) advance(d time.Duration) {
+	suite.now = suite.now.Add(d)
+	suite.redisServer.SetTime(suite.now)
+}
+
+func (suite *RateLimitTestSuite) call(name string, ctx echo.Context) {
+	suite.Require().NoError(RateLimit(suite.redisClient, name)(suite.handler)(ctx))
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_Burst() {
+	require := suite.Require()
+
+	for _, remaining := range []string{"2", "1", "0"} {
+		ctx, resp := rateLimitNewEchoContext("10.0.0.1")
+		suite.call("login", ctx)
+
+		require.Equal(http.StatusOK, resp.Code)
+		require.Equal("3", resp.Header().Get("RateLimit-Limit"))
+		require.Equal(remaining, resp.Header().Get("RateLimit-Remaining"))
+		require.Empty(resp.Header().Get("Retry-After"))
+	}
+
+	ctx, resp := rateLimitNewEchoContext("10.0.0.1")
+	suite.call("login", ctx)
+
+	require.Equal(http.StatusTooManyRequests, resp.Code)
+	require.Equal("0", resp.Header().Get("RateLimit-Remaining"))
+	require.Equal("20", resp.Header().Get("Retry-After"))
+	require.Equal("60", resp.Header().Get("RateLimit-Reset"))
+
+	// Other clients have their own bucket.
+	ctx, resp = rateLimitNewEchoContext("10.0.0.2")
+	suite.call("login", ctx)
+	require.Equal(http.StatusOK, resp.Code)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_Refill() {
+	require := suite.Require()
+
+	for i := 0; i < 3; i++ {
+		ctx, _ := rateLimitNewEchoContext("10.0.0.1")
+		suite.call("login", ctx)
+	}
+
+	suite.advance(15 * time.Second)
+	ctx, resp := rateLimitNewEchoContext("10.0.0.1")
+	suite.call("login", ctx)
+	require.Equal(http.StatusTooManyRequests, resp.Code)
+	require.Equal("5", resp.Header().Get("Retry-After"))
+
+	suite.advance(5 * time.Second)
+	ctx, resp = rateLimitNewEchoContext("10.0.0.1")
+	suite.call("login", ctx)
+	require.Equal(http.StatusOK, resp.Code)
+
+	// A full period refills the bucket but never past the limit.
+	suite.advance(10 * time.Minute)
+	for i := 0; i < 3; i++ {
+		ctx, resp = rateLimitNewEchoContext("10.0.0.1")
+		suite.call("login", ctx)
+		require.Equal(http.StatusOK, resp.Code)
+	}
+
+	ctx, resp = rateLimitNewEchoContext("10.0.0.1")
+	suite.call("login", ctx)
+	require.Equal(http.StatusTooManyRequests, resp.Code)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_ByUserID() {
+	require := suite.Require()
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		ctx, resp := rateLimitNewEchoContext(ip)
+		ctx.Set(userIDContextField, uint(1))
+		suite.call("metas", ctx)
+
+		if i < 2 {
+			require.Equal(http.StatusOK, resp.Code)
+		} else {
+			require.Equal(http.StatusTooManyRequests, resp.Code)
+		}
+	}
+
+	ctx, resp := rateLimitNewEchoContext("10.0.0.3")
+	ctx.Set(userIDContextField, uint(2))
+	suite.call("metas", ctx)
+	require.Equal(http.StatusOK, resp.Code)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_ByAPIKey() {
+	require := suite.Require()
+
+	ctx, resp := rateLimitNewEchoContext("10.0.0.1")
+	ctx.Request().Header.Set(apiKeyHeader, "key1")
+	suite.call("keys", ctx)
+	require.Equal(http.StatusOK, resp.Code)
+
+	ctx, resp = rateLimitNewEchoContext("10.0.0.2")
+	ctx.Request().Header.Set(apiKeyHeader, "key1")
+	suite.call("keys", ctx)
+	require.Equal(http.StatusTooManyRequests, resp.Code)
+
+	keys := suite.redisServer.Keys()
+	require.Len(keys, 1)
+	require.NotContains(keys[0], "key1")
+
+	// Without a key the IP is limited.
+	ctx, resp = rateLimitNewEchoContext("10.0.0.1")
+	suite.call("keys", ctx)
+	require.Equal(http.StatusOK, resp.Code)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_NotConfigured() {
+	require := suite.Require()
+
+	for i := 0; i < 10; i++ {
+		ctx, resp := rateLimitNewEchoContext("10.0.0.1")
+		suite.call("signup", ctx)
+		require.Equal(http.StatusOK, resp.Code)
+		require.Empty(resp.Header().Get("RateLimit-Limit"))
+	}
+
+	require.Empty(suite.redisServer.Keys())
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_SpoofedForwardedFor() {
+	require := suite.Require()
+
+	extractor, err := IPExtractor()
+	require.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		ctx, resp := rateLimitNewEchoContext("10.0.0.1")
+		ctx.Echo().IPExtractor = extractor
+		ctx.Request().Header.Set(echo.HeaderXForwardedFor, fmt.Sprintf("203.0.113.%d", i))
+		ctx.Request().Header.Set(echo.HeaderXRealIP, fmt.Sprintf("198.51.100.%d", i))
+		suite.call("login", ctx)
+
+		if i < 3 {
+			require.Equal(http.StatusOK, resp.Code)
+			continue
+		}
+
+		require.Equal(http.StatusTooManyRequests, resp.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
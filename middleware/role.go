@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"golang-example/model"
+	"net/http"
+)
+
+// RequireRole lets the request through when the authorized user has any of
+// the given roles. It has to run after UserAuthorized.
+func RequireRole(roles ...model.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			userRoles, _ := ctx.Get(rolesContextField).([]string)
+			for _, userRole := range userRoles {
+				for _, role := range roles {
+					if model.Role(userRole) == role {
+						return next(ctx)
+					}
+				}
+			}
+
+			return ctx.JSON(http.StatusForbidden, "Forbidden")
+		}
+	}
+}
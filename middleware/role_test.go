@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"golang-example/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+func roleNewEchoContext(roles []string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	response := httptest.NewRecorder()
+	e := echo.New()
+	ctx := e.NewContext(request, response)
+	ctx.Set(rolesContextField, roles)
+
+	return ctx, response
+}
+
+type RoleTestSuite struct {
+	suite.Suite
+	handler echo.HandlerFunc
+}
+
+func (suite *RoleTestSuite) SetupSuite() {
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+func (suite *RoleTestSuite) TestRequireRole() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		roles        []string
+		expectedCode int
+	}{
+		"No roles": {
+			roles:        nil,
+			expectedCode: http.StatusForbidden,
+		},
+		"Missing role": {
+			roles:        []string{"user"},
+			expectedCode: http.StatusForbidden,
+		},
+		"Has one of the roles": {
+			roles:        []string{"user", "support"},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			ctx, resp := roleNewEchoContext(v.roles)
+
+			err := RequireRole(model.RoleAdmin, model.RoleSupport)(suite.handler)(ctx)
+			require.NoError(err)
+			require.Equal(v.expectedCode, resp.Code)
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	suite.Run(t, new(RoleTestSuite))
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"golang-example/model"
+	"net/http"
+)
+
+// RequireScope lets the request through only when the token was granted
+// every one of the given scopes. It has to run after UserAuthorized.
+func RequireScope(scopes ...model.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			granted := make(map[model.Scope]struct{})
+			tokenScopes, _ := ctx.Get(scopesContextField).([]string)
+			for _, scope := range tokenScopes {
+				granted[model.Scope(scope)] = struct{}{}
+			}
+
+			for _, scope := range scopes {
+				if _, ok := granted[scope]; !ok {
+					return ctx.JSON(http.StatusForbidden, "Forbidden")
+				}
+			}
+
+			return next(ctx)
+		}
+	}
+}
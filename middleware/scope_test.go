@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"golang-example/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+func scopeNewEchoContext(scopes []string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(http.MethodPut, "/metas", nil)
+	response := httptest.NewRecorder()
+	e := echo.New()
+	ctx := e.NewContext(request, response)
+	ctx.Set(scopesContextField, scopes)
+
+	return ctx, response
+}
+
+type ScopeTestSuite struct {
+	suite.Suite
+	handler echo.HandlerFunc
+}
+
+func (suite *ScopeTestSuite) SetupSuite() {
+	suite.handler = func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+}
+
+func (suite *ScopeTestSuite) TestRequireScope() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		scopes       []string
+		expectedCode int
+	}{
+		"No scopes": {
+			scopes:       nil,
+			expectedCode: http.StatusForbidden,
+		},
+		"Read only": {
+			scopes:       []string{"metas:read"},
+			expectedCode: http.StatusForbidden,
+		},
+		"Only one of the scopes": {
+			scopes:       []string{"metas:write"},
+			expectedCode: http.StatusForbidden,
+		},
+		"All of the scopes": {
+			scopes:       []string{"metas:read", "metas:write"},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			ctx, resp := scopeNewEchoContext(v.scopes)
+
+			err := RequireScope(model.ScopeMetasRead, model.ScopeMetasWrite)(suite.handler)(ctx)
+			require.NoError(err)
+			require.Equal(v.expectedCode, resp.Code)
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	suite.Run(t, new(ScopeTestSuite))
+}
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN roles VARCHAR(255) NOT NULL DEFAULT 'user' AFTER password;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id INT NOT NULL AUTO_INCREMENT,
+    client_id VARCHAR(32) NOT NULL,
+    secret_hash CHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    owner_id INT NOT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(id),
+    UNIQUE KEY oauth_clients_client_id_unique (client_id),
+    KEY oauth_clients_owner_id_index (owner_id)
+)
+CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;
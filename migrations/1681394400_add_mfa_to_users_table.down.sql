@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN mfa_enabled, DROP COLUMN mfa_secret;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN mfa_secret VARCHAR(255) NOT NULL DEFAULT '' AFTER roles,
+    ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE AFTER mfa_secret;
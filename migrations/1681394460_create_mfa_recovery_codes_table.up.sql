@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(id),
+    KEY mfa_recovery_codes_user_id_index (user_id)
+)
+CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;
@@ -0,0 +1 @@
+ALTER TABLE users DROP KEY users_email_index, DROP COLUMN email;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '' AFTER user_name,
+    ADD KEY users_email_index (email);
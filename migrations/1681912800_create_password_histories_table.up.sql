@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS password_histories (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(id),
+    KEY password_histories_user_id_index (user_id)
+)
+CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;
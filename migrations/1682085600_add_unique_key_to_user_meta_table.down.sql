@@ -0,0 +1 @@
+ALTER TABLE user_meta DROP KEY user_meta_user_id_meta_key_unique, DROP KEY user_meta_user_id_index;
@@ -0,0 +1,7 @@
+-- Keep only the latest row of each key before it becomes unique.
+DELETE older FROM user_meta older
+    JOIN user_meta newer ON newer.user_id = older.user_id AND newer.meta_key = older.meta_key AND newer.id > older.id;
+
+ALTER TABLE user_meta
+    ADD UNIQUE KEY user_meta_user_id_meta_key_unique (user_id, meta_key),
+    ADD KEY user_meta_user_id_index (user_id);
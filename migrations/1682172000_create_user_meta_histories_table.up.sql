@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_meta_histories (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    meta_key VARCHAR(255) NOT NULL,
+    previous_value VARCHAR(255) NULL DEFAULT NULL,
+    new_value VARCHAR(255) NULL DEFAULT NULL,
+    actor_id INT NOT NULL,
+    actor_api_key_id INT NULL DEFAULT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY(id),
+    KEY user_meta_histories_user_id_meta_key_index (user_id, meta_key),
+    KEY user_meta_histories_user_id_created_at_index (user_id, created_at)
+)
+CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP KEY users_email_unique,
+    DROP COLUMN email_key,
+    ADD KEY users_email_index (email);
@@ -0,0 +1,14 @@
+-- Keep each email only on one account before it becomes unique: a verified
+-- one over an unverified one, otherwise the oldest.
+UPDATE users duplicate
+    JOIN users kept ON kept.email = duplicate.email AND kept.id <> duplicate.id
+SET duplicate.email = '', duplicate.email_verified_at = NULL
+WHERE duplicate.email <> ''
+    AND (duplicate.email_verified_at IS NULL AND kept.email_verified_at IS NOT NULL
+        OR (duplicate.email_verified_at IS NULL) = (kept.email_verified_at IS NULL) AND kept.id < duplicate.id);
+
+-- Users without an email keep the empty string, which the unique key skips.
+ALTER TABLE users
+    DROP KEY users_email_index,
+    ADD COLUMN email_key VARCHAR(255) GENERATED ALWAYS AS (NULLIF(email, '')) VIRTUAL AFTER email,
+    ADD UNIQUE KEY users_email_unique (email_key);
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+type APIKey struct {
+	ID        uint       `gorm:"Column:id"`
+	Name      string     `gorm:"Column:name"`
+	Prefix    string     `gorm:"Column:prefix"`
+	KeyHash   string     `gorm:"Column:key_hash"`
+	Scopes    Scopes     `gorm:"Column:scopes"`
+	OwnerID   uint       `gorm:"Column:owner_id"`
+	RevokedAt *time.Time `gorm:"Column:revoked_at"`
+	UpdatedAt time.Time  `gorm:"Column:updated_at"`
+	CreatedAt time.Time  `gorm:"Column:created_at"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// MFARecoveryCode is a single-use code to pass two-factor authentication
+// without the authenticator app. Only its hash is stored.
+type MFARecoveryCode struct {
+	ID        uint       `gorm:"Column:id"`
+	UserID    uint       `gorm:"Column:user_id"`
+	CodeHash  string     `gorm:"Column:code_hash"`
+	UsedAt    *time.Time `gorm:"Column:used_at"`
+	CreatedAt time.Time  `gorm:"Column:created_at"`
+}
+
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
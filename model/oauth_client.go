@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+type OAuthClient struct {
+	ID         uint       `gorm:"Column:id"`
+	ClientID   string     `gorm:"Column:client_id"`
+	SecretHash string     `gorm:"Column:secret_hash"`
+	Name       string     `gorm:"Column:name"`
+	Scopes     Scopes     `gorm:"Column:scopes"`
+	OwnerID    uint       `gorm:"Column:owner_id"`
+	RevokedAt  *time.Time `gorm:"Column:revoked_at"`
+	UpdatedAt  time.Time  `gorm:"Column:updated_at"`
+	CreatedAt  time.Time  `gorm:"Column:created_at"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// PasswordHistory is a password hash a user had before. It keeps users from
+// going back to a recent password.
+type PasswordHistory struct {
+	ID           uint      `gorm:"Column:id"`
+	UserID       uint      `gorm:"Column:user_id"`
+	PasswordHash string    `gorm:"Column:password_hash"`
+	CreatedAt    time.Time `gorm:"Column:created_at"`
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_histories"
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+type Scope string
+
+const (
+	ScopeMetasRead   Scope = "metas:read"
+	ScopeMetasWrite  Scope = "metas:write"
+	ScopeProfileRead Scope = "profile:read"
+)
+
+var ScopesMap = map[Scope]struct{}{
+	ScopeMetasRead:   {},
+	ScopeMetasWrite:  {},
+	ScopeProfileRead: {},
+}
+
+// DefaultScopes are granted when a login doesn't ask for specific scopes.
+var DefaultScopes = []Scope{ScopeMetasRead, ScopeMetasWrite, ScopeProfileRead}
+
+// ParseScopes splits an OAuth style space separated scope list.
+func ParseScopes(scope string) []Scope {
+	var scopes []Scope
+	for _, s := range strings.Fields(scope) {
+		scopes = append(scopes, Scope(s))
+	}
+
+	return scopes
+}
+
+func ScopeStrings(scopes []Scope) []string {
+	s := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s = append(s, string(scope))
+	}
+
+	return s
+}
+
+// Scopes is stored as a space separated list in a single column.
+type Scopes []Scope
+
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(ScopeStrings(s), " "), nil
+}
+
+func (s *Scopes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+	case []byte:
+		*s = ParseScopes(string(v))
+	case string:
+		*s = ParseScopes(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", value)
+	}
+
+	return nil
+}
@@ -1,11 +1,84 @@
 package model
 
-import "time"
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
 
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleAdmin   Role = "admin"
+	RoleSupport Role = "support"
+)
+
+var RolesMap = map[Role]struct{}{
+	RoleUser:    {},
+	RoleAdmin:   {},
+	RoleSupport: {},
+}
+
+// Roles is stored as a comma separated list in a single column.
+type Roles []Role
+
+func (r Roles) Value() (driver.Value, error) {
+	return strings.Join(r.Strings(), ","), nil
+}
+
+func (r *Roles) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into Roles", value)
+	}
+
+	*r = nil
+	for _, role := range strings.Split(s, ",") {
+		if role != "" {
+			*r = append(*r, Role(role))
+		}
+	}
+
+	return nil
+}
+
+func (r Roles) Strings() []string {
+	roles := make([]string, 0, len(r))
+	for _, role := range r {
+		roles = append(roles, string(role))
+	}
+
+	return roles
+}
+
+// User is an account. Email is empty or unique among the users.
+// MFASecret is the TOTP secret encrypted with utils.EncryptSecret, it is set
+// on enrollment while MFAEnabled is only set once a code has been confirmed.
 type User struct {
-	ID        uint      `gorm:"Column:id"`
-	UserName  string    `gorm:"Column:user_name"`
-	Password  string    `gorm:"Column:password"`
-	UpdatedAt time.Time `gorm:"Column:updated_at"`
-	CreatedAt time.Time `gorm:"Column:created_at"`
+	ID              uint       `gorm:"Column:id"`
+	UserName        string     `gorm:"Column:user_name"`
+	Email           string     `gorm:"Column:email"`
+	EmailVerifiedAt *time.Time `gorm:"Column:email_verified_at"`
+	Password        string     `gorm:"Column:password"`
+	Roles           Roles      `gorm:"Column:roles"`
+	MFASecret       string     `gorm:"Column:mfa_secret"`
+	MFAEnabled      bool       `gorm:"Column:mfa_enabled"`
+	UpdatedAt       time.Time  `gorm:"Column:updated_at"`
+	CreatedAt       time.Time  `gorm:"Column:created_at"`
+}
+
+// EmailUnverified reports whether the user gave an email which isn't
+// verified yet.
+func (u User) EmailUnverified() bool {
+	return u.Email != "" && u.EmailVerifiedAt == nil
 }
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	ID        uint      `gorm:"Column:id"`
+	UserID    uint      `gorm:"Column:user_id"`
+	Provider  string    `gorm:"Column:provider"`
+	Subject   string    `gorm:"Column:subject"`
+	Email     string    `gorm:"Column:email"`
+	UpdatedAt time.Time `gorm:"Column:updated_at"`
+	CreatedAt time.Time `gorm:"Column:created_at"`
+}
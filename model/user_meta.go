@@ -9,17 +9,6 @@ const (
 	UMKGender UserMetaKey = "gender"
 )
 
-var GendersMap = map[string]struct{}{
-	"male":   {},
-	"female": {},
-	"none":   {},
-}
-
-var KeysMap = map[UserMetaKey]struct{}{
-	UMKAge:    {},
-	UMKGender: {},
-}
-
 type UserMeta struct {
 	ID        uint        `gorm:"Column:id"`
 	MetaKey   UserMetaKey `gorm:"Column:meta_key"`
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// UserMetaHistory is a change of a user meta. A nil PreviousValue is a key
+// that was added and a nil NewValue one that was removed. ActorAPIKeyID is
+// set when the actor made the change with an API key.
+type UserMetaHistory struct {
+	ID            uint        `gorm:"Column:id"`
+	UserID        uint        `gorm:"Column:user_id"`
+	MetaKey       UserMetaKey `gorm:"Column:meta_key"`
+	PreviousValue *string     `gorm:"Column:previous_value"`
+	NewValue      *string     `gorm:"Column:new_value"`
+	ActorID       uint        `gorm:"Column:actor_id"`
+	ActorAPIKeyID *uint       `gorm:"Column:actor_api_key_id"`
+	IP            string      `gorm:"Column:ip"`
+	CreatedAt     time.Time   `gorm:"Column:created_at"`
+}
+
+func (UserMetaHistory) TableName() string {
+	return "user_meta_histories"
+}
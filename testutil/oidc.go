@@ -0,0 +1,203 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang-example/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+const oidcMockKeyID = "mock"
+
+// OIDCProviderMock is an in-process OpenID Connect provider for tests. It
+// implements discovery, the key set and the token endpoint of the
+// authorization code flow.
+type OIDCProviderMock struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]oidcMockCode
+}
+
+type oidcMockCode struct {
+	claims        jwt.MapClaims
+	codeChallenge string
+	redirectURI   string
+}
+
+func NewOIDCProviderMock() *OIDCProviderMock {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m := &OIDCProviderMock{
+		ClientID:     "client",
+		ClientSecret: "client-secret",
+		key:          key,
+		codes:        make(map[string]oidcMockCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+
+	return m
+}
+
+func (m *OIDCProviderMock) Close() {
+	m.Server.Close()
+}
+
+// Config returns the provider config pointing at the mock.
+func (m *OIDCProviderMock) Config(name string) config.OIDCProvider {
+	return config.OIDCProvider{
+		Name:         name,
+		Issuer:       m.Server.URL,
+		ClientID:     m.ClientID,
+		ClientSecret: m.ClientSecret,
+		RedirectURL:  "http://localhost/auth/" + name + "/callback",
+		Scopes:       []string{"email"},
+	}
+}
+
+// Authorize plays the part of the user signing in at the provider. It takes
+// the authorization URL the user was sent to and returns the code the
+// provider would redirect back with.
+func (m *OIDCProviderMock) Authorize(authURL string, subject string, email string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	if query.Get("client_id") != m.ClientID || query.Get("code_challenge_method") != "S256" {
+		return "", fmt.Errorf("unexpected authorization request: %s", authURL)
+	}
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   m.Server.URL,
+		"sub":   subject,
+		"aud":   []string{m.ClientID},
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Get("nonce"),
+	}
+
+	if email != "" {
+		claims["email"] = email
+		claims["email_verified"] = true
+	}
+
+	code := hex.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code] = oidcMockCode{
+		claims:        claims,
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   query.Get("redirect_uri"),
+	}
+
+	return code, nil
+}
+
+// SignIDToken signs arbitrary claims with the key of the mock.
+func (m *OIDCProviderMock) SignIDToken(claims jwt.Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = oidcMockKeyID
+
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return signed
+}
+
+func (m *OIDCProviderMock) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 m.Server.URL,
+		"authorization_endpoint": m.Server.URL + "/authorize",
+		"token_endpoint":         m.Server.URL + "/token",
+		"jwks_uri":               m.Server.URL + "/jwks",
+	})
+}
+
+func (m *OIDCProviderMock) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": oidcMockKeyID,
+			"use": "sig",
+			"alg": jwt.SigningMethodRS256.Alg(),
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *OIDCProviderMock) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != m.ClientID || clientSecret != m.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	if !ok || code.redirectURI != r.PostForm.Get("redirect_uri") || code.codeChallenge != codeChallenge(r.PostForm.Get("code_verifier")) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     m.SignIDToken(code.claims),
+	})
+}
+
+// codeChallenge is the S256 PKCE challenge of the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const apiKeyPrefix = "gek"
+
+// GenerateAPIKey returns a new API key together with the part of it which is
+// safe to show in listings and the hash that gets stored.
+func GenerateAPIKey() (key string, prefix string, hash string, err error) {
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		return "", "", "", fmt.Errorf("generating API key failed: %w", err)
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", "", fmt.Errorf("generating API key failed: %w", err)
+	}
+
+	prefix = fmt.Sprintf("%s_%s", apiKeyPrefix, hex.EncodeToString(id))
+	key = fmt.Sprintf("%s_%s", prefix, secret)
+
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes the key for storage and lookup, so keys aren't usable
+// when the table leaks.
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+type APIKeyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *APIKeyTestSuite) TestAPIKey_GenerateAPIKey() {
+	require := suite.Require()
+
+	key, prefix, hash, err := GenerateAPIKey()
+	require.NoError(err)
+	require.True(strings.HasPrefix(key, prefix+"_"))
+	require.True(strings.HasPrefix(prefix, "gek_"))
+	require.Equal(HashAPIKey(key), hash)
+	require.Len(hash, 64)
+
+	otherKey, _, _, err := GenerateAPIKey()
+	require.NoError(err)
+	require.NotEqual(key, otherKey)
+}
+
+func TestAPIKey(t *testing.T) {
+	suite.Run(t, new(APIKeyTestSuite))
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"golang-example/config"
+	"math"
+	"os"
+	"strings"
+)
+
+// breachedFalsePositiveRate is how often PasswordBreached may report a
+// password that isn't on the list.
+const breachedFalsePositiveRate = 0.001
+
+var breachedPasswords *bloomFilter
+
+// InitBreachedPasswords loads the breached password list of the policy into
+// a bloom filter, which keeps lists of millions of hashes in a few
+// megabytes. Without a list the check is skipped.
+func InitBreachedPasswords() error {
+	path := config.C.PasswordPolicy.BreachedList
+	if path == "" {
+		breachedPasswords = nil
+		return nil
+	}
+
+	filter, err := loadBreachedPasswords(path)
+	if err != nil {
+		return fmt.Errorf("loading breached passwords failed: %w", err)
+	}
+
+	breachedPasswords = filter
+	return nil
+}
+
+// PasswordBreached reports whether the password is on the breached list.
+func PasswordBreached(password string) bool {
+	if breachedPasswords == nil {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	return breachedPasswords.contains(sum[:])
+}
+
+func loadBreachedPasswords(path string) (*bloomFilter, error) {
+	var n uint64
+	err := readBreachedPasswords(path, func([]byte) { n++ })
+	if err != nil {
+		return nil, err
+	}
+
+	filter := newBloomFilter(n, breachedFalsePositiveRate)
+	err = readBreachedPasswords(path, filter.add)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func readBreachedPasswords(path string, fn func(hash []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		hash, err := hex.DecodeString(text)
+		if err != nil || len(hash) != sha1.Size {
+			return fmt.Errorf("invalid SHA-1 hash on line %d", line)
+		}
+
+		fn(hash)
+	}
+
+	return scanner.Err()
+}
+
+// bloomFilter is a set of SHA-1 hashes that may report false positives but
+// never false negatives. The hashes are uniform already, so their bytes are
+// used as the two base hashes of double hashing.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+func newBloomFilter(n uint64, falsePositiveRate float64) *bloomFilter {
+	if n == 0 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+func (f *bloomFilter) add(hash []byte) {
+	h1, h2 := bloomHashes(hash)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) contains(hash []byte) bool {
+	h1, h2 := bloomHashes(hash)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func bloomHashes(hash []byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(hash[0:8]), binary.BigEndian.Uint64(hash[8:16]) | 1
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+// NewCSRFToken returns a random token for the double-submit cookie pattern.
+func NewCSRFToken() (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("generating CSRF token failed: %w", err)
+	}
+
+	return token, nil
+}
+
+// VerifyCSRFToken reports whether the token sent in the header matches the
+// one in the cookie.
+func VerifyCSRFToken(cookie string, header string) bool {
+	if cookie == "" || header == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"math/big"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	emailVerificationCodeKeyPrefix     = "email_verification_code"
+	emailVerificationAttemptsKeyPrefix = "email_verification_attempts"
+	emailVerificationResendKeyPrefix   = "email_verification_resend"
+	emailVerificationResendsKeyPrefix  = "email_verification_resends"
+
+	// TokenPurposeEmailVerification marks the tokens of verification links.
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+var ErrEmailVerificationInvalid = errors.New("email verification is invalid")
+
+type emailVerificationCode struct {
+	Email    string `json:"email"`
+	CodeHash string `json:"code_hash"`
+}
+
+// GenerateEmailVerificationToken returns the signed token of a verification
+// link for the email of the user.
+func GenerateEmailVerificationToken(userID uint, email string) (string, error) {
+	return GenerateToken(TokenParams{
+		UserID:    userID,
+		Purpose:   TokenPurposeEmailVerification,
+		ExpiresIn: config.C.EmailVerification.LinkTTL,
+		Email:     email,
+	})
+}
+
+// ValidateEmailVerificationToken returns the user and the email a
+// verification link was issued for.
+func ValidateEmailVerificationToken(token string) (uint, string, error) {
+	claims, err := ValidatePurposeToken(token, TokenPurposeEmailVerification)
+	if err != nil || claims.Email == "" {
+		return 0, "", ErrEmailVerificationInvalid
+	}
+
+	return claims.ID, claims.Email, nil
+}
+
+// GenerateEmailVerificationCode returns a 6-digit code for the email of the
+// user, replacing the previous one and its attempts.
+func GenerateEmailVerificationCode(ctx context.Context, redis *goredis.Client, userID uint, email string) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("generating email verification code failed: %w", err)
+	}
+
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	value, err := json.Marshal(emailVerificationCode{Email: email, CodeHash: hashToken(code)})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = redis.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Set(ctx, emailVerificationCodeKey(userID), value, config.C.EmailVerification.CodeTTL)
+		pipe.Del(ctx, emailVerificationAttemptsKey(userID))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyEmailVerificationCode checks the code sent for the email of the
+// user. A code is removed once it is used or after too many wrong attempts,
+// so it can't be guessed. Attempts are counted before the code is compared,
+// so requests racing each other can't make more guesses between them.
+func VerifyEmailVerificationCode(ctx context.Context, redis *goredis.Client, userID uint, email string, code string) error {
+	c := config.C.EmailVerification
+	key := emailVerificationCodeKey(userID)
+
+	value, err := redis.Get(ctx, key).Bytes()
+	if err == goredis.Nil {
+		return ErrEmailVerificationInvalid
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var data emailVerificationCode
+	if err = json.Unmarshal(value, &data); err != nil {
+		return err
+	}
+
+	attemptsKey := emailVerificationAttemptsKey(userID)
+	attempts, err := redis.Incr(ctx, attemptsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	if attempts == 1 {
+		if err = redis.Expire(ctx, attemptsKey, c.CodeTTL).Err(); err != nil {
+			return err
+		}
+	}
+
+	if c.MaxCodeAttempts > 0 && attempts > c.MaxCodeAttempts {
+		return ErrEmailVerificationInvalid
+	}
+
+	if data.Email == email && subtle.ConstantTimeCompare([]byte(data.CodeHash), []byte(hashToken(code))) == 1 {
+		return redis.Del(ctx, key).Err()
+	}
+
+	// The attempts are kept until the next code, so a request which read
+	// this one can't start counting over.
+	if data.Email != email || attempts >= c.MaxCodeAttempts {
+		if err = redis.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+
+	return ErrEmailVerificationInvalid
+}
+
+// AllowEmailVerificationResend records a resend for the email. When the
+// email is throttled nothing is recorded and the time until the next resend
+// is allowed is returned.
+func AllowEmailVerificationResend(ctx context.Context, redis *goredis.Client, email string) (time.Duration, error) {
+	c := config.C.EmailVerification
+	hash := hashToken(email)
+	intervalKey := fmt.Sprintf("%s:%s", emailVerificationResendKeyPrefix, hash)
+	windowKey := fmt.Sprintf("%s:%s", emailVerificationResendsKeyPrefix, hash)
+
+	resends, err := redis.Get(ctx, windowKey).Int64()
+	if err != nil && err != goredis.Nil {
+		return 0, err
+	}
+
+	if resends >= c.MaxResends {
+		ttl, err := redis.PTTL(ctx, windowKey).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		return ttl, nil
+	}
+
+	allowed, err := redis.SetNX(ctx, intervalKey, 1, c.ResendInterval).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if !allowed {
+		ttl, err := redis.PTTL(ctx, intervalKey).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		return ttl, nil
+	}
+
+	resends, err = redis.Incr(ctx, windowKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if resends == 1 {
+		if err = redis.Expire(ctx, windowKey, c.ResendWindow).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, nil
+}
+
+func emailVerificationCodeKey(userID uint) string {
+	return fmt.Sprintf("%s:%d", emailVerificationCodeKeyPrefix, userID)
+}
+
+func emailVerificationAttemptsKey(userID uint) string {
+	return fmt.Sprintf("%s:%d", emailVerificationAttemptsKeyPrefix, userID)
+}
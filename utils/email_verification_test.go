@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"testing"
+	"time"
+)
+
+type EmailVerificationTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *EmailVerificationTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+	config.C = config.Config{
+		Token: config.Token{ExpiresIn: time.Minute, Secret: "secret"},
+		EmailVerification: config.EmailVerification{
+			LinkTTL:         time.Hour,
+			CodeTTL:         time.Minute,
+			MaxCodeAttempts: 3,
+			ResendInterval:  time.Minute,
+			MaxResends:      2,
+			ResendWindow:    time.Hour,
+		},
+	}
+}
+
+func (suite *EmailVerificationTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+}
+
+func (suite *EmailVerificationTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Token() {
+	require := suite.Require()
+
+	token, err := GenerateEmailVerificationToken(1, "user@example.com")
+	require.NoError(err)
+
+	userID, email, err := ValidateEmailVerificationToken(token)
+	require.NoError(err)
+	require.Equal(uint(1), userID)
+	require.Equal("user@example.com", email)
+
+	_, err = ValidateToken(token)
+	require.Error(err)
+
+	accessToken, err := GenerateToken(TokenParams{UserID: 1})
+	require.NoError(err)
+	_, _, err = ValidateEmailVerificationToken(accessToken)
+	require.Equal(ErrEmailVerificationInvalid, err)
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Code() {
+	require := suite.Require()
+
+	code, err := GenerateEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com")
+	require.NoError(err)
+	require.Regexp("^[0-9]{6}$", code)
+
+	require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", "abcdef"))
+	require.NoError(VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", code))
+	require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", code))
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Code_OtherEmail() {
+	require := suite.Require()
+
+	code, err := GenerateEmailVerificationCode(suite.ctx, suite.redisClient, 1, "old@example.com")
+	require.NoError(err)
+
+	require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "new@example.com", code))
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Code_TooManyAttempts() {
+	require := suite.Require()
+
+	code, err := GenerateEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com")
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", "wrong"))
+	}
+
+	require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", code))
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_Code_StaleRead() {
+	require := suite.Require()
+
+	code, err := GenerateEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com")
+	require.NoError(err)
+
+	stored, err := suite.redisServer.Get("email_verification_code:1")
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", "wrong"))
+	}
+
+	// A request which read the code before it was removed still counts
+	// as one more attempt, attempts don't live in the code.
+	require.NoError(suite.redisServer.Set("email_verification_code:1", stored))
+	require.Equal(ErrEmailVerificationInvalid, VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", code))
+
+	// A new code starts over.
+	code, err = GenerateEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com")
+	require.NoError(err)
+	require.NoError(VerifyEmailVerificationCode(suite.ctx, suite.redisClient, 1, "user@example.com", code))
+}
+
+func (suite *EmailVerificationTestSuite) TestEmailVerification_AllowResend() {
+	require := suite.Require()
+
+	retryAfter, err := AllowEmailVerificationResend(suite.ctx, suite.redisClient, "user@example.com")
+	require.NoError(err)
+	require.Zero(retryAfter)
+
+	retryAfter, err = AllowEmailVerificationResend(suite.ctx, suite.redisClient, "user@example.com")
+	require.NoError(err)
+	require.InDelta(time.Minute, retryAfter, float64(time.Second))
+
+	retryAfter, err = AllowEmailVerificationResend(suite.ctx, suite.redisClient, "other@example.com")
+	require.NoError(err)
+	require.Zero(retryAfter)
+
+	suite.redisServer.FastForward(time.Minute)
+	retryAfter, err = AllowEmailVerificationResend(suite.ctx, suite.redisClient, "user@example.com")
+	require.NoError(err)
+	require.Zero(retryAfter)
+
+	// The second resend used up the window.
+	suite.redisServer.FastForward(time.Minute)
+	retryAfter, err = AllowEmailVerificationResend(suite.ctx, suite.redisClient, "user@example.com")
+	require.NoError(err)
+	require.InDelta(58*time.Minute, retryAfter, float64(time.Second))
+}
+
+func TestEmailVerification(t *testing.T) {
+	suite.Run(t, new(EmailVerificationTestSuite))
+}
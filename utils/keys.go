@@ -0,0 +1,305 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type tokenKey struct {
+	id         string
+	method     jwt.SigningMethod
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
+}
+
+type tokenKeySet struct {
+	signing *tokenKey
+	keys    map[string]*tokenKey
+}
+
+// tokenKeys is nil as long as no asymmetric key is configured, tokens are
+// signed with the shared HS256 secret in that case.
+var tokenKeys *tokenKeySet
+
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// InitKeys loads the asymmetric keys listed in the token config.
+func InitKeys() error {
+	if len(config.C.Token.Keys) == 0 {
+		if config.C.Token.SigningKeyID != "" {
+			return fmt.Errorf("signing key [%s] is not configured", config.C.Token.SigningKeyID)
+		}
+
+		tokenKeys = nil
+		return nil
+	}
+
+	set := &tokenKeySet{keys: make(map[string]*tokenKey)}
+	for _, c := range config.C.Token.Keys {
+		key, err := loadTokenKey(c)
+		if err != nil {
+			return fmt.Errorf("loading token key [%s] failed: %w", c.ID, err)
+		}
+
+		if _, ok := set.keys[key.id]; ok {
+			return fmt.Errorf("token key [%s] is duplicated", key.id)
+		}
+
+		set.keys[key.id] = key
+	}
+
+	if config.C.Token.SigningKeyID != "" {
+		key, ok := set.keys[config.C.Token.SigningKeyID]
+		if !ok {
+			return fmt.Errorf("signing key [%s] is not configured", config.C.Token.SigningKeyID)
+		}
+
+		if key.privateKey == nil {
+			return fmt.Errorf("signing key [%s] has no private key", key.id)
+		}
+
+		set.signing = key
+	}
+
+	tokenKeys = set
+	return nil
+}
+
+// JWKS returns the public part of every configured key so other services
+// can verify our tokens on their own.
+func JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if tokenKeys == nil {
+		return set
+	}
+
+	for _, key := range tokenKeys.keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+
+	return set
+}
+
+func (k *tokenKey) jwk() JSONWebKey {
+	jwk := JSONWebKey{
+		KeyID:     k.id,
+		Use:       "sig",
+		Algorithm: k.method.Alg(),
+	}
+
+	switch pub := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+// publicKey parses the public key of a JWK published by another issuer.
+func (k JSONWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curve [%s] is not supported", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return pub, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("key type [%s] is not supported", k.KeyType)
+	}
+}
+
+func loadTokenKey(c config.TokenKey) (*tokenKey, error) {
+	if c.ID == "" {
+		return nil, errors.New("key id is required")
+	}
+
+	method := jwt.GetSigningMethod(c.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("algorithm [%s] is not supported", c.Algorithm)
+	}
+
+	key := &tokenKey{id: c.ID, method: method}
+	if c.PrivateKeyPath != "" {
+		block, err := readPEM(c.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+
+		key.privateKey = signer
+		key.publicKey = signer.Public()
+	}
+
+	if c.PublicKeyPath != "" {
+		block, err := readPEM(c.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		key.publicKey, err = parsePublicKey(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if key.publicKey == nil {
+		return nil, errors.New("either private or public key is required")
+	}
+
+	if err := checkKeyType(method, key.publicKey); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("[%s] is not PEM encoded", path)
+	}
+
+	return block, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key type is not supported")
+	}
+
+	return signer, nil
+}
+
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func checkKeyType(method jwt.SigningMethod, publicKey crypto.PublicKey) error {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := publicKey.(*rsa.PublicKey); ok {
+			return nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if pub, ok := publicKey.(*ecdsa.PublicKey); ok && pub.Curve.Params().BitSize == m.CurveBits {
+			return nil
+		}
+	case *jwt.SigningMethodEd25519:
+		if _, ok := publicKey.(ed25519.PublicKey); ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key does not match algorithm [%s]", method.Alg())
+}
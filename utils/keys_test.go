@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type KeysTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *KeysTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn: time.Minute,
+			Secret:    "secret",
+		},
+	}
+}
+
+func (suite *KeysTestSuite) TearDownTest() {
+	tokenKeys = nil
+}
+
+func (suite *KeysTestSuite) writePrivateKey(name string, key crypto.Signer) string {
+	require := suite.Require()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(err)
+
+	path := filepath.Join(suite.dir, name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(err)
+
+	return path
+}
+
+func (suite *KeysTestSuite) writePublicKey(name string, key crypto.PublicKey) string {
+	require := suite.Require()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(err)
+
+	path := filepath.Join(suite.dir, name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	require.NoError(err)
+
+	return path
+}
+
+func (suite *KeysTestSuite) TestKeys_SignAndValidate() {
+	require := suite.Require()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	testCases := map[string]struct {
+		algorithm string
+		key       crypto.Signer
+	}{
+		"RS256": {algorithm: "RS256", key: rsaKey},
+		"ES256": {algorithm: "ES256", key: ecKey},
+		"EdDSA": {algorithm: "EdDSA", key: edKey},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			config.C.Token.SigningKeyID = desc
+			config.C.Token.Keys = []config.TokenKey{{
+				ID:             desc,
+				Algorithm:      v.algorithm,
+				PrivateKeyPath: suite.writePrivateKey(desc+".pem", v.key),
+			}}
+			require.NoError(InitKeys())
+
+			token, err := GenerateToken(TokenParams{UserID: 1})
+			require.NoError(err)
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwtClaim{})
+			require.NoError(err)
+			require.Equal(desc, parsed.Header["kid"])
+			require.Equal(v.algorithm, parsed.Method.Alg())
+
+			claims, err := ValidateToken(token)
+			require.NoError(err)
+			require.Equal(uint(1), claims.ID)
+		})
+	}
+}
+
+func (suite *KeysTestSuite) TestKeys_Rotation() {
+	require := suite.Require()
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	config.C.Token.SigningKeyID = "old"
+	config.C.Token.Keys = []config.TokenKey{
+		{ID: "old", Algorithm: "ES256", PrivateKeyPath: suite.writePrivateKey("old.pem", oldKey)},
+	}
+	require.NoError(InitKeys())
+
+	oldToken, err := GenerateToken(TokenParams{UserID: 1})
+	require.NoError(err)
+
+	config.C.Token.SigningKeyID = "new"
+	config.C.Token.Keys = []config.TokenKey{
+		{ID: "old", Algorithm: "ES256", PublicKeyPath: suite.writePublicKey("old.pub.pem", oldKey.Public())},
+		{ID: "new", Algorithm: "ES256", PrivateKeyPath: suite.writePrivateKey("new.pem", newKey)},
+	}
+	require.NoError(InitKeys())
+
+	newToken, err := GenerateToken(TokenParams{UserID: 1})
+	require.NoError(err)
+
+	_, err = ValidateToken(oldToken)
+	require.NoError(err)
+	_, err = ValidateToken(newToken)
+	require.NoError(err)
+
+	config.C.Token.Keys = config.C.Token.Keys[1:]
+	require.NoError(InitKeys())
+
+	_, err = ValidateToken(oldToken)
+	require.Error(err)
+}
+
+func (suite *KeysTestSuite) TestKeys_RejectsSecretTokens() {
+	require := suite.Require()
+
+	secretToken, err := GenerateToken(TokenParams{UserID: 1})
+	require.NoError(err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	config.C.Token.SigningKeyID = "key"
+	config.C.Token.Keys = []config.TokenKey{
+		{ID: "key", Algorithm: "ES256", PrivateKeyPath: suite.writePrivateKey("key.pem", key)},
+	}
+	require.NoError(InitKeys())
+
+	_, err = ValidateToken(secretToken)
+	require.Error(err)
+}
+
+func (suite *KeysTestSuite) TestKeys_InitKeys_Failure() {
+	require := suite.Require()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	rsaPath := suite.writePrivateKey("rsa.pem", rsaKey)
+
+	testCases := map[string]struct {
+		signingKeyID string
+		keys         []config.TokenKey
+		expectedErr  string
+	}{
+		"Unknown signing key": {
+			signingKeyID: "missing",
+			expectedErr:  "signing key [missing] is not configured",
+		},
+		"Unsupported algorithm": {
+			keys:        []config.TokenKey{{ID: "key", Algorithm: "XX256", PrivateKeyPath: rsaPath}},
+			expectedErr: "loading token key [key] failed: algorithm [XX256] is not supported",
+		},
+		"Key type mismatch": {
+			keys:        []config.TokenKey{{ID: "key", Algorithm: "ES256", PrivateKeyPath: rsaPath}},
+			expectedErr: "loading token key [key] failed: key does not match algorithm [ES256]",
+		},
+		"Signing key without private key": {
+			signingKeyID: "key",
+			keys:         []config.TokenKey{{ID: "key", Algorithm: "RS256", PublicKeyPath: suite.writePublicKey("rsa.pub.pem", rsaKey.Public())}},
+			expectedErr:  "signing key [key] has no private key",
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			config.C.Token.SigningKeyID = v.signingKeyID
+			config.C.Token.Keys = v.keys
+			require.EqualError(InitKeys(), v.expectedErr)
+		})
+	}
+}
+
+func (suite *KeysTestSuite) TestKeys_JWKS() {
+	require := suite.Require()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	require.Empty(JWKS().Keys)
+
+	config.C.Token.SigningKeyID = "rsa"
+	config.C.Token.Keys = []config.TokenKey{
+		{ID: "rsa", Algorithm: "RS256", PrivateKeyPath: suite.writePrivateKey("rsa.pem", rsaKey)},
+		{ID: "ec", Algorithm: "ES256", PrivateKeyPath: suite.writePrivateKey("ec.pem", ecKey)},
+		{ID: "ed", Algorithm: "EdDSA", PublicKeyPath: suite.writePublicKey("ed.pub.pem", edPublic)},
+	}
+	require.NoError(InitKeys())
+
+	keys := make(map[string]JSONWebKey)
+	for _, key := range JWKS().Keys {
+		keys[key.KeyID] = key
+	}
+
+	require.Len(keys, 3)
+	require.Equal("RSA", keys["rsa"].KeyType)
+	require.Equal("AQAB", keys["rsa"].E)
+	require.Equal("EC", keys["ec"].KeyType)
+	require.Equal("P-256", keys["ec"].Curve)
+	require.Len(keys["ec"].X, 43)
+	require.Equal("OKP", keys["ed"].KeyType)
+	require.Equal("EdDSA", keys["ed"].Algorithm)
+}
+
+func TestKeys(t *testing.T) {
+	suite.Run(t, new(KeysTestSuite))
+}
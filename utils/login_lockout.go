@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"golang-example/config"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	loginFailuresKeyPrefix = "login_failures"
+	loginLockKeyPrefix     = "login_lock"
+	loginLockoutsKeyPrefix = "login_lockouts"
+)
+
+// loginSubject is what failed logins are counted for, a username or a
+// client IP.
+type loginSubject struct {
+	name        string
+	maxAttempts int64
+}
+
+func loginSubjects(username string, ip string) []loginSubject {
+	c := config.C.LoginLockout
+
+	subjects := []loginSubject{{name: userLoginSubject(username), maxAttempts: c.MaxAttempts}}
+	if ip != "" {
+		subjects = append(subjects, loginSubject{name: "ip:" + ip, maxAttempts: c.IPMaxAttempts})
+	}
+
+	return subjects
+}
+
+// usernames are hashed, as they come unchecked from the request
+func userLoginSubject(username string) string {
+	return "user:" + hashToken(username)
+}
+
+// LoginLockedFor returns how long logins of the username or from the IP are
+// still locked, zero when they aren't.
+func LoginLockedFor(ctx context.Context, redis *goredis.Client, username string, ip string) (time.Duration, error) {
+	var lockedFor time.Duration
+	for _, subject := range loginSubjects(username, ip) {
+		if subject.maxAttempts <= 0 {
+			continue
+		}
+
+		ttl, err := redis.PTTL(ctx, loginLockKey(subject.name)).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		if ttl > lockedFor {
+			lockedFor = ttl
+		}
+	}
+
+	return lockedFor, nil
+}
+
+// RecordLoginFailure counts a failed login of the username from the IP.
+// When it reaches the limit of either, it is locked and the duration of the
+// lock is returned. Every lock within MaxDuration of the previous one lasts
+// twice as long.
+func RecordLoginFailure(ctx context.Context, redis *goredis.Client, username string, ip string) (time.Duration, error) {
+	c := config.C.LoginLockout
+
+	var lockedFor time.Duration
+	for _, subject := range loginSubjects(username, ip) {
+		if subject.maxAttempts <= 0 {
+			continue
+		}
+
+		failuresKey := loginFailuresKey(subject.name)
+		failures, err := redis.Incr(ctx, failuresKey).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		if failures == 1 {
+			if err = redis.Expire(ctx, failuresKey, c.Window).Err(); err != nil {
+				return 0, err
+			}
+		}
+
+		if failures < subject.maxAttempts {
+			continue
+		}
+
+		lockoutsKey := loginLockoutsKey(subject.name)
+		lockouts, err := redis.Incr(ctx, lockoutsKey).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		duration := loginLockDuration(lockouts)
+		if err = redis.Expire(ctx, lockoutsKey, duration+c.MaxDuration).Err(); err != nil {
+			return 0, err
+		}
+
+		if err = redis.Set(ctx, loginLockKey(subject.name), 1, duration).Err(); err != nil {
+			return 0, err
+		}
+
+		if err = redis.Del(ctx, failuresKey).Err(); err != nil {
+			return 0, err
+		}
+
+		if duration > lockedFor {
+			lockedFor = duration
+		}
+	}
+
+	return lockedFor, nil
+}
+
+// ResetLoginFailures forgets the failed logins of the username after a
+// successful one. Failures of the IP are kept, logging into an own account
+// must not clear them.
+func ResetLoginFailures(ctx context.Context, redis *goredis.Client, username string) error {
+	subject := userLoginSubject(username)
+
+	return redis.Del(ctx, loginFailuresKey(subject), loginLockoutsKey(subject)).Err()
+}
+
+// UnlockLogin removes the lock of the username and its failed logins.
+func UnlockLogin(ctx context.Context, redis *goredis.Client, username string) error {
+	subject := userLoginSubject(username)
+
+	return redis.Del(ctx, loginFailuresKey(subject), loginLockKey(subject), loginLockoutsKey(subject)).Err()
+}
+
+// loginLockDuration returns the duration of the nth lock in a row.
+func loginLockDuration(lockouts int64) time.Duration {
+	c := config.C.LoginLockout
+
+	duration := c.BaseDuration
+	for i := int64(1); i < lockouts && duration < c.MaxDuration; i++ {
+		duration *= 2
+	}
+
+	if duration > c.MaxDuration {
+		duration = c.MaxDuration
+	}
+
+	return duration
+}
+
+func loginFailuresKey(subject string) string {
+	return fmt.Sprintf("%s:%s", loginFailuresKeyPrefix, subject)
+}
+
+func loginLockKey(subject string) string {
+	return fmt.Sprintf("%s:%s", loginLockKeyPrefix, subject)
+}
+
+func loginLockoutsKey(subject string) string {
+	return fmt.Sprintf("%s:%s", loginLockoutsKeyPrefix, subject)
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"testing"
+	"time"
+)
+
+type LoginLockoutTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *LoginLockoutTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+}
+
+func (suite *LoginLockoutTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+	config.C.LoginLockout = config.LoginLockout{
+		MaxAttempts:   3,
+		IPMaxAttempts: 5,
+		Window:        15 * time.Minute,
+		BaseDuration:  time.Minute,
+		MaxDuration:   5 * time.Minute,
+	}
+}
+
+func (suite *LoginLockoutTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+	config.C.LoginLockout = config.LoginLockout{}
+}
+
+// fail records failed logins until the username gets locked and returns
+// the duration of the lock.
+func (suite *LoginLockoutTestSuite) fail(username string, ip string, times int) time.Duration {
+	var lockedFor time.Duration
+	for i := 0; i < times; i++ {
+		d, err := RecordLoginFailure(suite.ctx, suite.redisClient, username, ip)
+		suite.Require().NoError(err)
+
+		if i < times-1 {
+			suite.Require().Zero(d)
+		}
+		lockedFor = d
+	}
+
+	return lockedFor
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_LocksUsername() {
+	require := suite.Require()
+
+	require.Equal(time.Minute, suite.fail("username", "10.0.0.1", 3))
+
+	lockedFor, err := LoginLockedFor(suite.ctx, suite.redisClient, "username", "10.0.0.2")
+	require.NoError(err)
+	require.Equal(time.Minute, lockedFor)
+
+	lockedFor, err = LoginLockedFor(suite.ctx, suite.redisClient, "other", "10.0.0.2")
+	require.NoError(err)
+	require.Zero(lockedFor)
+
+	suite.redisServer.FastForward(time.Minute)
+
+	lockedFor, err = LoginLockedFor(suite.ctx, suite.redisClient, "username", "10.0.0.2")
+	require.NoError(err)
+	require.Zero(lockedFor)
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_ExponentialBackoff() {
+	require := suite.Require()
+
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		require.Equal(expected, suite.fail("username", "", 3))
+		suite.redisServer.FastForward(expected)
+	}
+
+	// Once nothing failed for MaxDuration after the last lock, it starts over.
+	suite.redisServer.FastForward(5 * time.Minute)
+	require.Equal(time.Minute, suite.fail("username", "", 3))
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_LocksIP() {
+	require := suite.Require()
+
+	for _, username := range []string{"user1", "user2", "user3", "user4"} {
+		d, err := RecordLoginFailure(suite.ctx, suite.redisClient, username, "10.0.0.1")
+		require.NoError(err)
+		require.Zero(d)
+	}
+
+	d, err := RecordLoginFailure(suite.ctx, suite.redisClient, "user5", "10.0.0.1")
+	require.NoError(err)
+	require.Equal(time.Minute, d)
+
+	lockedFor, err := LoginLockedFor(suite.ctx, suite.redisClient, "user6", "10.0.0.1")
+	require.NoError(err)
+	require.Equal(time.Minute, lockedFor)
+
+	lockedFor, err = LoginLockedFor(suite.ctx, suite.redisClient, "user6", "10.0.0.2")
+	require.NoError(err)
+	require.Zero(lockedFor)
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_FailuresExpire() {
+	require := suite.Require()
+
+	suite.fail("username", "", 2)
+	suite.redisServer.FastForward(15 * time.Minute)
+
+	d, err := RecordLoginFailure(suite.ctx, suite.redisClient, "username", "")
+	require.NoError(err)
+	require.Zero(d)
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_Reset() {
+	require := suite.Require()
+
+	suite.fail("username", "10.0.0.1", 2)
+	require.NoError(ResetLoginFailures(suite.ctx, suite.redisClient, "username"))
+
+	d, err := RecordLoginFailure(suite.ctx, suite.redisClient, "username", "10.0.0.1")
+	require.NoError(err)
+	require.Zero(d)
+
+	// The failures of the IP are kept.
+	failures, err := suite.redisServer.Get(loginFailuresKey("ip:10.0.0.1"))
+	require.NoError(err)
+	require.Equal("3", failures)
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_Unlock() {
+	require := suite.Require()
+
+	suite.fail("username", "", 3)
+	require.NoError(UnlockLogin(suite.ctx, suite.redisClient, "username"))
+
+	lockedFor, err := LoginLockedFor(suite.ctx, suite.redisClient, "username", "")
+	require.NoError(err)
+	require.Zero(lockedFor)
+
+	// The backoff starts over as well.
+	require.Equal(time.Minute, suite.fail("username", "", 3))
+}
+
+func (suite *LoginLockoutTestSuite) TestLoginLockout_Disabled() {
+	require := suite.Require()
+	config.C.LoginLockout.MaxAttempts = 0
+	config.C.LoginLockout.IPMaxAttempts = 0
+
+	require.Zero(suite.fail("username", "10.0.0.1", 10))
+
+	lockedFor, err := LoginLockedFor(suite.ctx, suite.redisClient, "username", "10.0.0.1")
+	require.NoError(err)
+	require.Zero(lockedFor)
+}
+
+func TestLoginLockout(t *testing.T) {
+	suite.Run(t, new(LoginLockoutTestSuite))
+}
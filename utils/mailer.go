@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MailDriverSMTP = "smtp"
+	MailDriverFile = "file"
+)
+
+// Message is a plain text mail.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends mail to users.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailer returns the mailer selected by the config.
+func NewMailer(c config.Mail) (Mailer, error) {
+	switch c.Driver {
+	case MailDriverSMTP:
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(c.SMTP.Host, strconv.Itoa(c.SMTP.Port)),
+			Host:     c.SMTP.Host,
+			Username: c.SMTP.Username,
+			Password: c.SMTP.Password,
+			From:     c.From,
+		}, nil
+	case MailDriverFile, "":
+		return &FileMailer{Path: c.FilePath, From: c.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %s", c.Driver)
+	}
+}
+
+// SMTPMailer sends mail through an SMTP server. The connection is upgraded
+// with STARTTLS when the server supports it, and authentication is only
+// used when a username is set. A deadline of the context bounds the whole
+// conversation with the server.
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := formatMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	if err = m.send(ctx, msg.To, data); err != nil {
+		return fmt.Errorf("sending mail failed: %w", err)
+	}
+
+	return nil
+}
+
+// send does what smtp.SendMail does, on a connection that gives up when the
+// context is done.
+func (m *SMTPMailer) send(ctx context.Context, to string, data []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+
+	if m.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(m.From); err != nil {
+		return err
+	}
+
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// FileMailer doesn't send anything. It appends each message to the file at
+// Path, or writes it to the log when Path is empty.
+type FileMailer struct {
+	Path string
+	From string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := formatMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	if m.Path == "" {
+		log.Infof("mail:\n%s", data)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("writing mail failed: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing mail failed: %w", err)
+	}
+
+	return nil
+}
+
+// formatMessage builds the mail with its headers. Line breaks in the header
+// values are rejected so they can't be used to add headers.
+func formatMessage(from string, msg Message) ([]byte, error) {
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("mail header contains a line break")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	return buf.Bytes(), nil
+}
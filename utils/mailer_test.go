@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MailerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MailerTestSuite) TestMailer_NewMailer() {
+	require := suite.Require()
+
+	mailer, err := NewMailer(config.Mail{Driver: MailDriverSMTP, From: "from@example.com", SMTP: config.SMTP{Host: "smtp.example.com", Port: 587}})
+	require.NoError(err)
+	require.Equal("smtp.example.com:587", mailer.(*SMTPMailer).Addr)
+
+	mailer, err = NewMailer(config.Mail{Driver: MailDriverFile, FilePath: "mail.log"})
+	require.NoError(err)
+	require.Equal("mail.log", mailer.(*FileMailer).Path)
+
+	_, err = NewMailer(config.Mail{Driver: "carrier-pigeon"})
+	require.EqualError(err, "unknown mail driver: carrier-pigeon")
+}
+
+func (suite *MailerTestSuite) TestMailer_FileMailer_Send() {
+	require := suite.Require()
+
+	path := filepath.Join(suite.T().TempDir(), "mail.log")
+	mailer := &FileMailer{Path: path, From: "no-reply@example.com"}
+
+	require.NoError(mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hello", Body: "first\nmail"}))
+	require.NoError(mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hello", Body: "second mail"}))
+
+	b, err := os.ReadFile(path)
+	require.NoError(err)
+	require.Contains(string(b), "From: no-reply@example.com\r\nTo: user@example.com\r\nSubject: Hello\r\n")
+	require.Contains(string(b), "\r\n\r\nfirst\r\nmail\r\n")
+	require.Contains(string(b), "second mail")
+}
+
+func (suite *MailerTestSuite) TestMailer_FileMailer_HeaderInjection_Failure() {
+	require := suite.Require()
+
+	mailer := &FileMailer{Path: filepath.Join(suite.T().TempDir(), "mail.log")}
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com\r\nBcc: other@example.com", Subject: "Hello"})
+	require.EqualError(err, "mail header contains a line break")
+}
+
+// smtpServer answers a single SMTP conversation on a local port and sends
+// the mail data it received to the returned channel.
+func (suite *MailerTestSuite) smtpServer() (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				_ = text.PrintfLine("250 localhost")
+			case line == "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotBytes()
+				received <- string(data)
+				_ = text.PrintfLine("250 OK")
+			case line == "QUIT":
+				_ = text.PrintfLine("221 bye")
+				return
+			default:
+				_ = text.PrintfLine("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func (suite *MailerTestSuite) TestMailer_SMTPMailer_Send() {
+	require := suite.Require()
+
+	addr, received := suite.smtpServer()
+	mailer := &SMTPMailer{Addr: addr, Host: "localhost", From: "no-reply@example.com"}
+
+	require.NoError(mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hello", Body: "mail"}))
+	require.Contains(<-received, "To: user@example.com\nSubject: Hello\n")
+}
+
+func (suite *MailerTestSuite) TestMailer_SMTPMailer_Send_Timeout() {
+	require := suite.Require()
+
+	// The server accepts the connection but never greets.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_, _ = bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	mailer := &SMTPMailer{Addr: listener.Addr().String(), Host: "localhost", From: "no-reply@example.com"}
+	start := time.Now()
+	err = mailer.Send(ctx, Message{To: "user@example.com", Subject: "Hello", Body: "mail"})
+
+	require.Error(err)
+	require.Less(time.Since(start), 5*time.Second)
+}
+
+func TestMailer(t *testing.T) {
+	suite.Run(t, new(MailerTestSuite))
+}
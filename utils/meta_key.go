@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	metaDateLayout = "2006-01-02"
+
+	// metaValueMaxLength is the size of the meta_value column.
+	metaValueMaxLength = 255
+)
+
+var ErrMetaKeyInvalid = errors.New("invalid key")
+
+// legacyMetaKeys holds the keys users had before they became configurable.
+var legacyMetaKeys = config.MetaKeys{
+	"age":    {Type: config.MetaTypeInt, Min: "1"},
+	"gender": {Type: config.MetaTypeEnum, Values: []string{"male", "female", "none"}},
+}
+
+// metaPatterns caches the compiled patterns of string keys by their source.
+var metaPatterns sync.Map
+
+// InitMetaKeys checks the configured meta keys, so a broken registry stops
+// the service from starting instead of failing requests.
+func InitMetaKeys() error {
+	for name, key := range metaKeys() {
+		if name == "" {
+			return errors.New("meta key needs a name")
+		}
+
+		if err := checkMetaKey(key); err != nil {
+			return fmt.Errorf("meta key [%s] %w", name, err)
+		}
+
+		if key.Default != "" {
+			if _, err := normalizeMetaValue(name, key, key.Default); err != nil {
+				return fmt.Errorf("meta key [%s] default is invalid", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// MetaKeyExists reports whether the meta key is defined.
+func MetaKeyExists(name string) bool {
+	_, ok := metaKeys()[name]
+	return ok
+}
+
+// MetaKeyNames returns the names of the defined meta keys in order.
+func MetaKeyNames() []string {
+	keys := metaKeys()
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// MetaKeyDefaults returns the default values of the keys which have one.
+func MetaKeyDefaults() map[string]string {
+	defaults := make(map[string]string)
+	for name, key := range metaKeys() {
+		if key.Default != "" {
+			defaults[name] = key.Default
+		}
+	}
+
+	return defaults
+}
+
+// NormalizeMetaValue checks the value against the definition of the meta
+// key and returns it the way it is stored, e.g. "007" as "7" for an int.
+// The error reads "invalid <key>" and can be sent to the client.
+func NormalizeMetaValue(name string, value string) (string, error) {
+	key, ok := metaKeys()[name]
+	if !ok {
+		return "", ErrMetaKeyInvalid
+	}
+
+	return normalizeMetaValue(name, key, value)
+}
+
+func normalizeMetaValue(name string, key config.MetaKey, value string) (string, error) {
+	invalid := fmt.Errorf("invalid %s", name)
+
+	switch key.Type {
+	case config.MetaTypeInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", invalid
+		}
+
+		if key.Min != "" {
+			if min, _ := strconv.Atoi(key.Min); n < min {
+				return "", invalid
+			}
+		}
+
+		if key.Max != "" {
+			if max, _ := strconv.Atoi(key.Max); n > max {
+				return "", invalid
+			}
+		}
+
+		value = strconv.Itoa(n)
+	case config.MetaTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", invalid
+		}
+
+		value = strconv.FormatBool(b)
+	case config.MetaTypeDate:
+		date, err := time.Parse(metaDateLayout, value)
+		if err != nil {
+			return "", invalid
+		}
+
+		if key.Min != "" {
+			if min, _ := time.Parse(metaDateLayout, key.Min); date.Before(min) {
+				return "", invalid
+			}
+		}
+
+		if key.Max != "" {
+			if max, _ := time.Parse(metaDateLayout, key.Max); date.After(max) {
+				return "", invalid
+			}
+		}
+
+		value = date.Format(metaDateLayout)
+	case config.MetaTypeEnum:
+		if !containsString(key.Values, value) {
+			return "", invalid
+		}
+	case config.MetaTypeString:
+		if key.Pattern != "" {
+			re, err := metaPattern(key.Pattern)
+			if err != nil || !re.MatchString(value) {
+				return "", invalid
+			}
+		}
+	default:
+		return "", invalid
+	}
+
+	length := utf8.RuneCountInString(value)
+	if length > metaValueMaxLength || key.MaxLength > 0 && length > key.MaxLength {
+		return "", invalid
+	}
+
+	return value, nil
+}
+
+func checkMetaKey(key config.MetaKey) error {
+	switch key.Type {
+	case config.MetaTypeInt:
+		for _, bound := range []string{key.Min, key.Max} {
+			if _, err := strconv.Atoi(bound); bound != "" && err != nil {
+				return fmt.Errorf("bound [%s] is not an int", bound)
+			}
+		}
+	case config.MetaTypeDate:
+		for _, bound := range []string{key.Min, key.Max} {
+			if _, err := time.Parse(metaDateLayout, bound); bound != "" && err != nil {
+				return fmt.Errorf("bound [%s] is not a date", bound)
+			}
+		}
+	case config.MetaTypeEnum:
+		if len(key.Values) == 0 {
+			return errors.New("needs values")
+		}
+	case config.MetaTypeString:
+		if _, err := metaPattern(key.Pattern); err != nil {
+			return fmt.Errorf("pattern is invalid: %w", err)
+		}
+	case config.MetaTypeBool:
+	default:
+		return fmt.Errorf("type [%s] is unknown", key.Type)
+	}
+
+	if key.MaxLength < 0 {
+		return errors.New("max length is negative")
+	}
+
+	return nil
+}
+
+func metaKeys() config.MetaKeys {
+	if len(config.C.MetaKeys) == 0 {
+		return legacyMetaKeys
+	}
+
+	return config.C.MetaKeys
+}
+
+func metaPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := metaPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	metaPatterns.Store(pattern, re)
+	return re, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
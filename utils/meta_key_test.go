@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"strings"
+	"testing"
+)
+
+type MetaKeyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MetaKeyTestSuite) SetupTest() {
+	config.C.MetaKeys = config.MetaKeys{
+		"age":      {Type: config.MetaTypeInt, Min: "1", Max: "150"},
+		"gender":   {Type: config.MetaTypeEnum, Values: []string{"male", "female", "none"}},
+		"nickname": {Type: config.MetaTypeString, Pattern: "^[a-z]+$", MaxLength: 8},
+		"bio":      {Type: config.MetaTypeString},
+		"verified": {Type: config.MetaTypeBool, Default: "false"},
+		"birthday": {Type: config.MetaTypeDate, Min: "1900-01-01", Max: "2100-01-01"},
+	}
+}
+
+func (suite *MetaKeyTestSuite) TearDownTest() {
+	config.C.MetaKeys = nil
+}
+
+func (suite *MetaKeyTestSuite) TestMetaKey_NormalizeMetaValue() {
+	require := suite.Require()
+
+	valid := []struct{ key, value, normalized string }{
+		{"age", "22", "22"},
+		{"age", "007", "7"},
+		{"age", "150", "150"},
+		{"gender", "female", "female"},
+		{"nickname", "bob", "bob"},
+		{"bio", "anything at all", "anything at all"},
+		{"verified", "1", "true"},
+		{"verified", "False", "false"},
+		{"birthday", "1990-05-17", "1990-05-17"},
+	}
+
+	for _, c := range valid {
+		value, err := NormalizeMetaValue(c.key, c.value)
+		require.NoError(err, c.key+"="+c.value)
+		require.Equal(c.normalized, value)
+	}
+
+	invalid := []struct{ key, value string }{
+		{"age", "0"},
+		{"age", "151"},
+		{"age", "twenty"},
+		{"gender", "mal"},
+		{"nickname", "Bob"},
+		{"nickname", "bobbybobby"},
+		{"bio", strings.Repeat("a", 256)},
+		{"verified", "yes"},
+		{"birthday", "17/05/1990"},
+		{"birthday", "1800-01-01"},
+	}
+
+	for _, c := range invalid {
+		_, err := NormalizeMetaValue(c.key, c.value)
+		require.EqualError(err, "invalid "+c.key, c.key+"="+c.value)
+	}
+
+	_, err := NormalizeMetaValue("height", "180")
+	require.Equal(ErrMetaKeyInvalid, err)
+}
+
+func (suite *MetaKeyTestSuite) TestMetaKey_NamesAndDefaults() {
+	require := suite.Require()
+
+	require.True(MetaKeyExists("nickname"))
+	require.False(MetaKeyExists("height"))
+	require.Equal([]string{"age", "bio", "birthday", "gender", "nickname", "verified"}, MetaKeyNames())
+	require.Equal(map[string]string{"verified": "false"}, MetaKeyDefaults())
+}
+
+func (suite *MetaKeyTestSuite) TestMetaKey_LegacyKeys() {
+	require := suite.Require()
+	config.C.MetaKeys = nil
+
+	require.Equal([]string{"age", "gender"}, MetaKeyNames())
+	require.NoError(InitMetaKeys())
+
+	_, err := NormalizeMetaValue("age", "0")
+	require.EqualError(err, "invalid age")
+
+	_, err = NormalizeMetaValue("gender", "none")
+	require.NoError(err)
+}
+
+func (suite *MetaKeyTestSuite) TestMetaKey_InitMetaKeys() {
+	require := suite.Require()
+	require.NoError(InitMetaKeys())
+
+	invalid := []config.MetaKey{
+		{Type: "float"},
+		{Type: config.MetaTypeInt, Min: "one"},
+		{Type: config.MetaTypeDate, Max: "tomorrow"},
+		{Type: config.MetaTypeEnum},
+		{Type: config.MetaTypeString, Pattern: "[a-"},
+		{Type: config.MetaTypeString, MaxLength: -1},
+		{Type: config.MetaTypeEnum, Values: []string{"a", "b"}, Default: "c"},
+	}
+
+	for _, key := range invalid {
+		config.C.MetaKeys = config.MetaKeys{"broken": key}
+		require.Error(InitMetaKeys(), key.Type)
+	}
+}
+
+func TestMetaKey(t *testing.T) {
+	suite.Run(t, new(MetaKeyTestSuite))
+}
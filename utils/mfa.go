@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"golang-example/config"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	totpUsedKeyPrefix    = "totp_used"
+	mfaAttemptsKeyPrefix = "mfa_attempts"
+	mfaFailuresKeyPrefix = "mfa_failures"
+	mfaLockKeyPrefix     = "mfa_lock"
+
+	// TokenPurposeMFAPending marks the token returned by a password login of
+	// a user with two-factor authentication. It can only be exchanged for an
+	// access token together with a second factor.
+	TokenPurposeMFAPending = "mfa_pending"
+
+	recoveryCodeCount = 10
+)
+
+// MarkTOTPUsed records that the user logged in with the code of the given
+// step. It returns false when the step was already used, so a code seen by
+// somebody else can't be replayed while it is still valid.
+func MarkTOTPUsed(ctx context.Context, redis *goredis.Client, userID uint, step int64) (bool, error) {
+	ttl := time.Duration(totpPeriod*(2*totpSkew+1)) * time.Second
+
+	return redis.SetNX(ctx, fmt.Sprintf("%s:%d:%d", totpUsedKeyPrefix, userID, step), 1, ttl).Result()
+}
+
+// CountMFAAttempt counts a second factor attempt made with the pending token
+// and returns the number of attempts so far.
+func CountMFAAttempt(ctx context.Context, redis *goredis.Client, jti string, expiresAt int64) (int64, error) {
+	key := fmt.Sprintf("%s:%s", mfaAttemptsKeyPrefix, jti)
+
+	attempts, err := redis.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if attempts == 1 {
+		if err = redis.ExpireAt(ctx, key, time.Unix(expiresAt, 0)).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return attempts, nil
+}
+
+// MFALockedFor returns how long second factors of the user are still not
+// checked, zero when they are.
+func MFALockedFor(ctx context.Context, redis *goredis.Client, userID uint) (time.Duration, error) {
+	if config.C.MFA.UserMaxAttempts <= 0 {
+		return 0, nil
+	}
+
+	ttl, err := redis.PTTL(ctx, fmt.Sprintf("%s:%d", mfaLockKeyPrefix, userID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// RecordMFAFailure counts a failed second factor of the user, whichever
+// pending token or session it came with. When it reaches the limit the user
+// is locked and the duration of the lock is returned.
+func RecordMFAFailure(ctx context.Context, redis *goredis.Client, userID uint) (time.Duration, error) {
+	c := config.C.MFA
+	if c.UserMaxAttempts <= 0 {
+		return 0, nil
+	}
+
+	failuresKey := fmt.Sprintf("%s:%d", mfaFailuresKeyPrefix, userID)
+	failures, err := redis.Incr(ctx, failuresKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if failures == 1 {
+		if err = redis.Expire(ctx, failuresKey, c.LockDuration).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	if failures < c.UserMaxAttempts {
+		return 0, nil
+	}
+
+	if err = redis.Set(ctx, fmt.Sprintf("%s:%d", mfaLockKeyPrefix, userID), 1, c.LockDuration).Err(); err != nil {
+		return 0, err
+	}
+
+	if err = redis.Del(ctx, failuresKey).Err(); err != nil {
+		return 0, err
+	}
+
+	return c.LockDuration, nil
+}
+
+// ResetMFAFailures forgets the failed second factors of the user after a
+// successful one.
+func ResetMFAFailures(ctx context.Context, redis *goredis.Client, userID uint) error {
+	return redis.Del(ctx, fmt.Sprintf("%s:%d", mfaFailuresKeyPrefix, userID)).Err()
+}
+
+// GenerateRecoveryCodes returns a set of single-use recovery codes and their
+// hashes, which is all that is stored.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generating recovery codes failed: %w", err)
+		}
+
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code as typed by the user. Case,
+// surrounding spaces and the dash don't matter.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+
+	return hashToken(code)
+}
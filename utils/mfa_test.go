@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MFATestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *MFATestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+	config.C.MFA.EncryptionKey = "c2VjcmV0LWtleS1mb3ItZGV2ZWxvcG1lbnQtb25seSE="
+}
+
+func (suite *MFATestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+}
+
+func (suite *MFATestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *MFATestSuite) TestMFA_TOTPCode() {
+	require := suite.Require()
+
+	// The SHA1 test vectors of RFC 6238, truncated to six digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range tests {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		require.NoError(err)
+		require.Equal(expected, code)
+	}
+}
+
+func (suite *MFATestSuite) TestMFA_VerifyTOTP() {
+	require := suite.Require()
+
+	secret, err := GenerateTOTPSecret()
+	require.NoError(err)
+
+	now := time.Unix(1681400000, 0)
+	for _, offset := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+		code, err := TOTPCode(secret, now.Add(offset))
+		require.NoError(err)
+
+		step, ok := VerifyTOTP(secret, code, now)
+		require.True(ok)
+		require.Equal(totpStep(now.Add(offset)), step)
+	}
+
+	code, err := TOTPCode(secret, now.Add(-90*time.Second))
+	require.NoError(err)
+	_, ok := VerifyTOTP(secret, code, now)
+	require.False(ok)
+
+	_, ok = VerifyTOTP(secret, "12345", now)
+	require.False(ok)
+}
+
+func (suite *MFATestSuite) TestMFA_TOTPURI() {
+	require := suite.Require()
+
+	u, err := url.Parse(TOTPURI("golang-example", "alice", "JBSWY3DPEHPK3PXP"))
+	require.NoError(err)
+	require.Equal("otpauth", u.Scheme)
+	require.Equal("totp", u.Host)
+	require.Equal("/golang-example:alice", u.Path)
+	require.Equal("JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	require.Equal("golang-example", u.Query().Get("issuer"))
+}
+
+func (suite *MFATestSuite) TestMFA_MarkTOTPUsed() {
+	require := suite.Require()
+
+	ok, err := MarkTOTPUsed(suite.ctx, suite.redisClient, 1, 100)
+	require.NoError(err)
+	require.True(ok)
+
+	ok, err = MarkTOTPUsed(suite.ctx, suite.redisClient, 1, 100)
+	require.NoError(err)
+	require.False(ok)
+
+	ok, err = MarkTOTPUsed(suite.ctx, suite.redisClient, 2, 100)
+	require.NoError(err)
+	require.True(ok)
+}
+
+func (suite *MFATestSuite) TestMFA_CountMFAAttempt() {
+	require := suite.Require()
+
+	expiresAt := time.Now().Add(time.Minute).Unix()
+	for i := int64(1); i <= 3; i++ {
+		attempts, err := CountMFAAttempt(suite.ctx, suite.redisClient, "jti", expiresAt)
+		require.NoError(err)
+		require.Equal(i, attempts)
+	}
+
+	require.True(suite.redisServer.TTL("mfa_attempts:jti") > 0)
+}
+
+func (suite *MFATestSuite) TestMFA_MFAFailures() {
+	require := suite.Require()
+	config.C.MFA.UserMaxAttempts = 3
+	config.C.MFA.LockDuration = time.Minute
+	defer func() { config.C.MFA.UserMaxAttempts, config.C.MFA.LockDuration = 0, 0 }()
+
+	for i := 0; i < 2; i++ {
+		lockedFor, err := RecordMFAFailure(suite.ctx, suite.redisClient, 1)
+		require.NoError(err)
+		require.Zero(lockedFor)
+	}
+
+	// A success starts the count over.
+	require.NoError(ResetMFAFailures(suite.ctx, suite.redisClient, 1))
+	for i := 0; i < 2; i++ {
+		_, err := RecordMFAFailure(suite.ctx, suite.redisClient, 1)
+		require.NoError(err)
+	}
+
+	lockedFor, err := MFALockedFor(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Zero(lockedFor)
+
+	lockedFor, err = RecordMFAFailure(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Equal(time.Minute, lockedFor)
+
+	lockedFor, err = MFALockedFor(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.True(lockedFor > 0)
+
+	lockedFor, err = MFALockedFor(suite.ctx, suite.redisClient, 2)
+	require.NoError(err)
+	require.Zero(lockedFor)
+}
+
+func (suite *MFATestSuite) TestMFA_RecoveryCodes() {
+	require := suite.Require()
+
+	codes, hashes, err := GenerateRecoveryCodes()
+	require.NoError(err)
+	require.Len(codes, 10)
+	require.Len(hashes, 10)
+	require.Regexp("^[0-9a-f]{5}-[0-9a-f]{5}$", codes[0])
+	require.Equal(hashes[0], HashRecoveryCode(" "+strings.ToUpper(codes[0])+" "))
+	require.Equal(hashes[0], HashRecoveryCode(strings.ReplaceAll(codes[0], "-", "")))
+	require.NotEqual(hashes[0], hashes[1])
+}
+
+func (suite *MFATestSuite) TestMFA_EncryptSecret() {
+	require := suite.Require()
+
+	encrypted, err := EncryptSecret("JBSWY3DPEHPK3PXP")
+	require.NoError(err)
+	require.NotContains(encrypted, "JBSWY3DPEHPK3PXP")
+
+	other, err := EncryptSecret("JBSWY3DPEHPK3PXP")
+	require.NoError(err)
+	require.NotEqual(encrypted, other)
+
+	decrypted, err := DecryptSecret(encrypted)
+	require.NoError(err)
+	require.Equal("JBSWY3DPEHPK3PXP", decrypted)
+
+	_, err = DecryptSecret(encrypted[:len(encrypted)-2] + "AA")
+	require.Equal(ErrSecretDecryption, err)
+
+	_, err = DecryptSecret("JBSWY3DPEHPK3PXP")
+	require.Equal(ErrSecretDecryption, err)
+}
+
+func (suite *MFATestSuite) TestMFA_InitSecretKey() {
+	require := suite.Require()
+	defer func() { config.C.MFA.EncryptionKey = "c2VjcmV0LWtleS1mb3ItZGV2ZWxvcG1lbnQtb25seSE=" }()
+
+	testCases := map[string]struct {
+		key         string
+		expectedErr string
+	}{
+		"Missing key": {
+			key:         "",
+			expectedErr: "mfa encryption key is not configured",
+		},
+		"Not base64": {
+			key:         "not base64!",
+			expectedErr: "mfa encryption key must be 32 bytes encoded in base64",
+		},
+		"Short key": {
+			key:         "c2hvcnQta2V5",
+			expectedErr: "mfa encryption key must be 32 bytes encoded in base64",
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			config.C.MFA.EncryptionKey = v.key
+			require.EqualError(InitSecretKey(), v.expectedErr)
+		})
+	}
+
+	config.C.MFA.EncryptionKey = "c2VjcmV0LWtleS1mb3ItZGV2ZWxvcG1lbnQtb25seSE="
+	require.NoError(InitSecretKey())
+}
+
+func TestMFA(t *testing.T) {
+	suite.Run(t, new(MFATestSuite))
+}
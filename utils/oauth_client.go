@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+)
+
+// GenerateClientCredentials returns the id and secret of a new OAuth client
+// together with the hash of the secret that gets stored.
+func GenerateClientCredentials() (clientID string, secret string, hash string, err error) {
+	id := make([]byte, 12)
+	if _, err = rand.Read(id); err != nil {
+		return "", "", "", fmt.Errorf("generating client credentials failed: %w", err)
+	}
+
+	secret, err = randomToken(32)
+	if err != nil {
+		return "", "", "", fmt.Errorf("generating client credentials failed: %w", err)
+	}
+
+	return hex.EncodeToString(id), secret, HashClientSecret(secret), nil
+}
+
+// HashClientSecret hashes a client secret for storage.
+func HashClientSecret(secret string) string {
+	return hashToken(secret)
+}
+
+// VerifyClientSecret compares the secret against the stored hash in
+// constant time.
+func VerifyClientSecret(hash string, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashClientSecret(secret))) == 1
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type OAuthClientTestSuite struct {
+	suite.Suite
+}
+
+func (suite *OAuthClientTestSuite) TestOAuthClient_GenerateClientCredentials() {
+	require := suite.Require()
+
+	clientID, secret, hash, err := GenerateClientCredentials()
+	require.NoError(err)
+	require.Len(clientID, 24)
+	require.Equal(HashClientSecret(secret), hash)
+	require.True(VerifyClientSecret(hash, secret))
+	require.False(VerifyClientSecret(hash, secret+"x"))
+
+	otherClientID, otherSecret, _, err := GenerateClientCredentials()
+	require.NoError(err)
+	require.NotEqual(clientID, otherClientID)
+	require.NotEqual(secret, otherSecret)
+}
+
+func TestOAuthClient(t *testing.T) {
+	suite.Run(t, new(OAuthClientTestSuite))
+}
@@ -0,0 +1,415 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+)
+
+const oidcStateKeyPrefix = "oidc_state"
+
+var (
+	ErrOIDCProviderNotFound = errors.New("oidc provider is not configured")
+	ErrOIDCStateInvalid     = errors.New("oidc state is invalid")
+	ErrIDTokenInvalid       = errors.New("id token is invalid")
+)
+
+// oidcProviders is keyed by the provider name used in the login URL.
+var oidcProviders map[string]*OIDCProvider
+
+// OIDCProvider signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE. Endpoints and keys are discovered on
+// first use, so the service starts even when a provider is unreachable.
+type OIDCProvider struct {
+	config config.OIDCProvider
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the claims of an ID token we rely on.
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+}
+
+// Valid only checks the expiry, the rest depends on the provider and is
+// checked by VerifyIDToken.
+func (c *IDTokenClaims) Valid() error {
+	if c.ExpiresAt < time.Now().Unix() {
+		return errors.New("token expired")
+	}
+
+	return nil
+}
+
+// audience accepts both forms of the aud claim, a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OIDCState is kept in Redis between the login redirect and the callback.
+type OIDCState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// InitOIDCProviders loads the providers listed in the OIDC config.
+func InitOIDCProviders() error {
+	providers := make(map[string]*OIDCProvider)
+	for _, c := range config.C.OIDC.Providers {
+		if c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+			return fmt.Errorf("oidc provider [%s] needs a name, issuer, client id and redirect url", c.Name)
+		}
+
+		if _, ok := providers[c.Name]; ok {
+			return fmt.Errorf("oidc provider [%s] is duplicated", c.Name)
+		}
+
+		providers[c.Name] = &OIDCProvider{config: c, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+
+	oidcProviders = providers
+	return nil
+}
+
+// GetOIDCProvider returns the configured provider with the given name.
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	provider, ok := oidcProviders[name]
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	return provider, nil
+}
+
+// Name returns the name the provider is configured with.
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL of the provider the user is sent to for login.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := append([]string{"openid"}, p.config.Scopes...)
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code at the token endpoint and returns
+// the raw ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var res struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = p.doJSON(req, &res); err != nil {
+		return "", fmt.Errorf("exchanging authorization code failed: %w", err)
+	}
+
+	if res.IDToken == "" {
+		return "", errors.New("exchanging authorization code failed: no id token in response")
+	}
+
+	return res.IDToken, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token issued by the provider.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*IDTokenClaims, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.getKey(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = checkKeyType(token.Method, key); err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIDTokenInvalid, err)
+	}
+
+	if claims.Issuer != discovery.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrIDTokenInvalid, claims.Issuer)
+	}
+
+	if !claims.Audience.contains(p.config.ClientID) {
+		return nil, fmt.Errorf("%w: token is not issued for this client", ErrIDTokenInvalid)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrIDTokenInvalid)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrIDTokenInvalid)
+	}
+
+	return claims, nil
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var discovery oidcDiscovery
+	if err = p.doJSON(req, &discovery); err != nil {
+		return nil, fmt.Errorf("discovering oidc provider [%s] failed: %w", p.config.Name, err)
+	}
+
+	if discovery.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovering oidc provider [%s] failed: issuer %s does not match", p.config.Name, discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovering oidc provider [%s] failed: endpoints are missing", p.config.Name)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// getKey returns the signing key with the given id. The key set is fetched
+// again when the id is unknown, so keys rotated by the provider are picked
+// up without a restart.
+func (p *OIDCProvider) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set JSONWebKeySet
+	if err = p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching keys of oidc provider [%s] failed: %w", p.config.Name, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+
+	return key, nil
+}
+
+func (p *OIDCProvider) doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", res.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// NewOIDCState stores the nonce and PKCE verifier of a new login and returns
+// the state value that identifies it.
+func NewOIDCState(ctx context.Context, redis *goredis.Client, provider string) (string, *OIDCState, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("generating oidc state failed: %w", err)
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("generating oidc state failed: %w", err)
+	}
+
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("generating oidc state failed: %w", err)
+	}
+
+	data := &OIDCState{Provider: provider, Nonce: nonce, CodeVerifier: verifier}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("generating oidc state failed: %w", err)
+	}
+
+	err = redis.Set(ctx, oidcStateKey(state), value, config.C.OIDC.StateTTL).Err()
+	if err != nil {
+		return "", nil, fmt.Errorf("generating oidc state failed: %w", err)
+	}
+
+	return state, data, nil
+}
+
+// ConsumeOIDCState returns the login identified by the state. A state can
+// only be used once.
+func ConsumeOIDCState(ctx context.Context, redis *goredis.Client, state string) (*OIDCState, error) {
+	value, err := redis.GetDel(ctx, oidcStateKey(state)).Result()
+	if err == goredis.Nil {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var data OIDCState
+	if err = json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	return &data, nil
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func oidcStateKey(state string) string {
+	return fmt.Sprintf("%s:%s", oidcStateKeyPrefix, state)
+}
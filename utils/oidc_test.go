@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"golang-example/testutil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type OIDCTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	idp         *testutil.OIDCProviderMock
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+	provider    *OIDCProvider
+}
+
+func (suite *OIDCTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.idp = testutil.NewOIDCProviderMock()
+	suite.redisServer = server
+	suite.redisClient = client
+}
+
+func (suite *OIDCTestSuite) SetupTest() {
+	config.C = config.Config{
+		OIDC: config.OIDC{
+			StateTTL:  time.Minute,
+			Providers: []config.OIDCProvider{suite.idp.Config("fake")},
+		},
+	}
+	suite.Require().NoError(InitOIDCProviders())
+
+	provider, err := GetOIDCProvider("fake")
+	suite.Require().NoError(err)
+	suite.provider = provider
+}
+
+func (suite *OIDCTestSuite) TearDownSuite() {
+	oidcProviders = nil
+	suite.idp.Close()
+	suite.redisServer.Close()
+}
+
+func (suite *OIDCTestSuite) claims() IDTokenClaims {
+	return IDTokenClaims{
+		Issuer:    suite.idp.Server.URL,
+		Subject:   "subject",
+		Audience:  audience{suite.idp.ClientID},
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		Nonce:     "nonce",
+	}
+}
+
+func (suite *OIDCTestSuite) TestOIDC_InitOIDCProviders_Failure() {
+	require := suite.Require()
+
+	config.C.OIDC.Providers = []config.OIDCProvider{{Name: "fake"}}
+	require.EqualError(InitOIDCProviders(), "oidc provider [fake] needs a name, issuer, client id and redirect url")
+
+	config.C.OIDC.Providers = []config.OIDCProvider{suite.idp.Config("fake"), suite.idp.Config("fake")}
+	require.EqualError(InitOIDCProviders(), "oidc provider [fake] is duplicated")
+
+	_, err := GetOIDCProvider("unknown")
+	require.Equal(ErrOIDCProviderNotFound, err)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_AuthorizationCodeFlow_Success() {
+	require := suite.Require()
+
+	state, data, err := NewOIDCState(suite.ctx, suite.redisClient, "fake")
+	require.NoError(err)
+
+	authURL, err := suite.provider.AuthCodeURL(suite.ctx, state, data.Nonce, data.CodeVerifier)
+	require.NoError(err)
+
+	u, err := url.Parse(authURL)
+	require.NoError(err)
+	require.Equal(suite.idp.Server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal("openid email", u.Query().Get("scope"))
+	require.Equal(state, u.Query().Get("state"))
+	require.Equal(codeChallenge(data.CodeVerifier), u.Query().Get("code_challenge"))
+
+	code, err := suite.idp.Authorize(authURL, "subject", "user@example.com")
+	require.NoError(err)
+
+	consumed, err := ConsumeOIDCState(suite.ctx, suite.redisClient, state)
+	require.NoError(err)
+	require.Equal(data, consumed)
+
+	_, err = ConsumeOIDCState(suite.ctx, suite.redisClient, state)
+	require.Equal(ErrOIDCStateInvalid, err)
+
+	rawIDToken, err := suite.provider.Exchange(suite.ctx, code, consumed.CodeVerifier)
+	require.NoError(err)
+
+	claims, err := suite.provider.VerifyIDToken(suite.ctx, rawIDToken, consumed.Nonce)
+	require.NoError(err)
+	require.Equal("subject", claims.Subject)
+	require.Equal("user@example.com", claims.Email)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_Exchange_WrongVerifier_Failure() {
+	require := suite.Require()
+
+	state, data, err := NewOIDCState(suite.ctx, suite.redisClient, "fake")
+	require.NoError(err)
+
+	authURL, err := suite.provider.AuthCodeURL(suite.ctx, state, data.Nonce, data.CodeVerifier)
+	require.NoError(err)
+
+	code, err := suite.idp.Authorize(authURL, "subject", "")
+	require.NoError(err)
+
+	_, err = suite.provider.Exchange(suite.ctx, code, "wrong")
+	require.Error(err)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_VerifyIDToken_Failure() {
+	require := suite.Require()
+
+	testCases := map[string]func(claims *IDTokenClaims){
+		"Expired":       func(claims *IDTokenClaims) { claims.ExpiresAt = time.Now().Add(-time.Minute).Unix() },
+		"Wrong issuer":  func(claims *IDTokenClaims) { claims.Issuer = "https://evil.example.com" },
+		"Wrong aud":     func(claims *IDTokenClaims) { claims.Audience = audience{"other"} },
+		"Wrong nonce":   func(claims *IDTokenClaims) { claims.Nonce = "other" },
+		"Empty subject": func(claims *IDTokenClaims) { claims.Subject = "" },
+	}
+
+	for desc, modify := range testCases {
+		suite.Run(desc, func() {
+			claims := suite.claims()
+			modify(&claims)
+
+			_, err := suite.provider.VerifyIDToken(suite.ctx, suite.idp.SignIDToken(&claims), "nonce")
+			require.ErrorIs(err, ErrIDTokenInvalid)
+		})
+	}
+}
+
+func (suite *OIDCTestSuite) TestOIDC_VerifyIDToken_ForeignKey_Failure() {
+	require := suite.Require()
+
+	other := testutil.NewOIDCProviderMock()
+	defer other.Close()
+
+	claims := suite.claims()
+	_, err := suite.provider.VerifyIDToken(suite.ctx, other.SignIDToken(&claims), "nonce")
+	require.ErrorIs(err, ErrIDTokenInvalid)
+}
+
+func (suite *OIDCTestSuite) TestOIDC_VerifyIDToken_AudienceList_Success() {
+	require := suite.Require()
+
+	claims := suite.claims()
+	claims.Audience = audience{"other", suite.idp.ClientID}
+
+	verified, err := suite.provider.VerifyIDToken(suite.ctx, suite.idp.SignIDToken(&claims), "nonce")
+	require.NoError(err)
+	require.Equal("subject", verified.Subject)
+}
+
+func TestOIDC(t *testing.T) {
+	suite.Run(t, new(OIDCTestSuite))
+}
@@ -1,64 +1,225 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"golang-example/config"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
-	"regexp"
+	"strings"
+	"sync"
 )
 
+// ValidatePasswordPattern returns the first rule of the password policy
+// the password fails. Use ValidatePassword to get all of them.
 func ValidatePasswordPattern(password string) error {
-	if len(password) < 8 {
-		return errors.New("password should be of 8 characters long")
+	err := ValidatePassword(password)
+	if policyErr, ok := err.(*PasswordPolicyError); ok {
+		return errors.New(policyErr.Rules[0].Message)
 	}
 
-	done, err := regexp.MatchString("([a-z])+", password)
+	return err
+}
+
+// HashPassword hashes the password with the configured algorithm. Argon2id
+// hashes are written in the PHC string format, bcrypt hashes in their own
+// $2a$ format, which is what PHC uses for bcrypt too.
+func HashPassword(password string) (string, error) {
+	c := config.C.PasswordHash
+	if c.Algorithm == config.PasswordHashArgon2id {
+		hashedPassword, err := hashArgon2id(password, argon2idParams(c.Argon2id))
+		if err != nil {
+			return "", fmt.Errorf("could not hash password %w", err)
+		}
+
+		return hashedPassword, nil
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost(c.BcryptCost))
+
 	if err != nil {
-		return err
+		return "", fmt.Errorf("could not hash password %w", err)
 	}
+	return string(hashedPassword), nil
+}
 
-	if !done {
-		return errors.New("password should contain at least one lower case character")
+// VerifyPassword checks the password against a hash of either algorithm,
+// which is told by the prefix of the hash.
+func VerifyPassword(hashedPassword string, candidatePassword string) error {
+	if strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		return verifyArgon2id(hashedPassword, candidatePassword)
 	}
 
-	done, err = regexp.MatchString("([A-Z])+", password)
-	if err != nil {
-		return err
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(candidatePassword))
+}
+
+var dummyPassword struct {
+	sync.Mutex
+	hash string
+}
+
+// VerifyDummyPassword takes as long as VerifyPassword against a hash made
+// with the current settings. It stands in for the check when there is no
+// user to check the password of, so the response time doesn't tell.
+func VerifyDummyPassword(candidatePassword string) {
+	_ = VerifyPassword(dummyPasswordHash(), candidatePassword)
+}
+
+func dummyPasswordHash() string {
+	dummyPassword.Lock()
+	defer dummyPassword.Unlock()
+
+	if dummyPassword.hash == "" || PasswordNeedsRehash(dummyPassword.hash) {
+		hash, err := HashPassword("dummy password")
+		if err != nil {
+			return ""
+		}
+
+		dummyPassword.hash = hash
+	}
+
+	return dummyPassword.hash
+}
+
+// PasswordNeedsRehash reports whether the hash was made with another
+// algorithm or other parameters than HashPassword would use now.
+func PasswordNeedsRehash(hashedPassword string) bool {
+	c := config.C.PasswordHash
+	if strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		if c.Algorithm != config.PasswordHashArgon2id {
+			return true
+		}
+
+		params, _, _, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return false
+		}
+
+		want := argon2idParams(c.Argon2id)
+		return params.Memory != want.Memory || params.Iterations != want.Iterations ||
+			params.Parallelism != want.Parallelism || params.KeyLength != want.KeyLength
 	}
 
-	if !done {
-		return errors.New("password should contain at least one upper case character")
+	if c.Algorithm == config.PasswordHashArgon2id {
+		return true
 	}
 
-	done, err = regexp.MatchString("([0-9])+", password)
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
 	if err != nil {
-		return err
+		return false
 	}
 
-	if !done {
-		return errors.New("password should contain at least one digit")
+	return cost != bcryptCost(c.BcryptCost)
+}
+
+const argon2idPrefix = "$argon2id$"
+
+var errInvalidArgon2idHash = errors.New("invalid argon2id hash")
+
+var defaultArgon2id = config.Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// argon2idParams fills the parameters left out of the config with the
+// defaults.
+func argon2idParams(c config.Argon2id) config.Argon2id {
+	if c.Memory == 0 {
+		c.Memory = defaultArgon2id.Memory
 	}
 
-	done, err = regexp.MatchString("([!@#$%^&*.?-])+", password)
+	if c.Iterations == 0 {
+		c.Iterations = defaultArgon2id.Iterations
+	}
+
+	if c.Parallelism == 0 {
+		c.Parallelism = defaultArgon2id.Parallelism
+	}
+
+	if c.SaltLength == 0 {
+		c.SaltLength = defaultArgon2id.SaltLength
+	}
+
+	if c.KeyLength == 0 {
+		c.KeyLength = defaultArgon2id.KeyLength
+	}
+
+	return c
+}
+
+func bcryptCost(cost int) int {
+	if cost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+
+	return cost
+}
+
+func hashArgon2id(password string, params config.Argon2id) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifyArgon2id(hashedPassword string, candidatePassword string) error {
+	params, salt, key, err := decodeArgon2id(hashedPassword)
 	if err != nil {
 		return err
 	}
 
-	if !done {
-		return errors.New("password should contain at least one special character")
+	candidate := argon2.IDKey([]byte(candidatePassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
 	}
+
 	return nil
 }
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// decodeArgon2id parses a hash like
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func decodeArgon2id(hashedPassword string) (config.Argon2id, []byte, []byte, error) {
+	var params config.Argon2id
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
 
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
 	if err != nil {
-		return "", fmt.Errorf("could not hash password %w", err)
+		return params, nil, nil, errInvalidArgon2idHash
 	}
-	return string(hashedPassword), nil
-}
 
-func VerifyPassword(hashedPassword string, candidatePassword string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(candidatePassword))
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
 }
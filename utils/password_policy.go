@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"fmt"
+	"golang-example/config"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxLength = "max_length"
+	PasswordRuleLower     = "lower"
+	PasswordRuleUpper     = "upper"
+	PasswordRuleDigit     = "digit"
+	PasswordRuleSpecial   = "special"
+	PasswordRuleScore     = "score"
+	PasswordRuleBreached  = "breached"
+	PasswordRuleHistory   = "history"
+)
+
+// The rules passwords had before the policy became configurable. They are
+// used for every rule the policy leaves out.
+const (
+	legacyMinLength         = 8
+	legacySpecialCharacters = "!@#$%^&*.?-"
+)
+
+// PasswordRule is a rule of the password policy a password failed.
+type PasswordRule struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule of the policy a password failed. It
+// is sent to the client as it is.
+type PasswordPolicyError struct {
+	Message string         `json:"message"`
+	Rules   []PasswordRule `json:"failed_rules"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+// ValidatePassword checks the password against the configured policy and
+// returns a *PasswordPolicyError with the failed rules.
+func ValidatePassword(password string) error {
+	policy := passwordPolicy()
+
+	var rules []PasswordRule
+	fail := func(rule string, format string, args ...interface{}) {
+		rules = append(rules, PasswordRule{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		fail(PasswordRuleMinLength, "password should be of %d characters long", policy.MinLength)
+	}
+
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		fail(PasswordRuleMaxLength, "password should be at most %d characters long", policy.MaxLength)
+	}
+
+	var lower, upper, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+
+		if isSpecialCharacter(r, *policy.SpecialCharacters) {
+			special = true
+		}
+	}
+
+	if *policy.RequireLower && !lower {
+		fail(PasswordRuleLower, "password should contain at least one lower case character")
+	}
+
+	if *policy.RequireUpper && !upper {
+		fail(PasswordRuleUpper, "password should contain at least one upper case character")
+	}
+
+	if *policy.RequireDigit && !digit {
+		fail(PasswordRuleDigit, "password should contain at least one digit")
+	}
+
+	if *policy.RequireSpecial && !special {
+		fail(PasswordRuleSpecial, "password should contain at least one special character")
+	}
+
+	if policy.MinScore > 0 && PasswordScore(password) < policy.MinScore {
+		fail(PasswordRuleScore, "password is too easy to guess")
+	}
+
+	if PasswordBreached(password) {
+		fail(PasswordRuleBreached, "password appears in a list of breached passwords")
+	}
+
+	if len(rules) > 0 {
+		return &PasswordPolicyError{Message: "password isn't strong enough", Rules: rules}
+	}
+
+	return nil
+}
+
+// PasswordReusedError is the *PasswordPolicyError for a password that is one
+// of the latest passwords of the user.
+func PasswordReusedError() error {
+	return &PasswordPolicyError{
+		Message: "password isn't strong enough",
+		Rules: []PasswordRule{{
+			Rule:    PasswordRuleHistory,
+			Message: fmt.Sprintf("password should not be one of the last %d passwords", config.C.PasswordHistory.Size),
+		}},
+	}
+}
+
+// passwordPolicy returns the configured policy with the original rule in
+// place of every rule it leaves out.
+func passwordPolicy() config.PasswordPolicy {
+	policy := config.C.PasswordPolicy
+	if policy.MinLength <= 0 {
+		policy.MinLength = legacyMinLength
+	}
+
+	required := true
+	for _, rule := range []**bool{&policy.RequireLower, &policy.RequireUpper, &policy.RequireDigit, &policy.RequireSpecial} {
+		if *rule == nil {
+			*rule = &required
+		}
+	}
+
+	if policy.SpecialCharacters == nil {
+		specialCharacters := legacySpecialCharacters
+		policy.SpecialCharacters = &specialCharacters
+	}
+
+	return policy
+}
+
+func isSpecialCharacter(r rune, specialCharacters string) bool {
+	if specialCharacters != "" {
+		return strings.ContainsRune(specialCharacters, r)
+	}
+
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// commonPasswordWords are the words most passwords are built around. They
+// add next to nothing to the strength of a password.
+var commonPasswordWords = []string{
+	"password", "passwort", "qwerty", "azerty", "letmein", "welcome", "admin", "login", "iloveyou",
+	"monkey", "dragon", "master", "sunshine", "princess", "football", "baseball", "shadow",
+	"superman", "trustno1", "secret", "abc123", "123456",
+}
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890"}
+
+// PasswordScore rates how hard the password is to guess from 0 to 4, in
+// the spirit of zxcvbn. Every character adds the entropy of the character
+// classes the password uses, except characters that repeat or continue a
+// sequence or keyboard run, and those of common password words, which add
+// a single bit.
+func PasswordScore(password string) int {
+	bits := passwordEntropy(password)
+
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 60:
+		return 2
+	case bits < 80:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func passwordEntropy(password string) float64 {
+	runes := []rune(password)
+	lowered := make([]rune, len(runes))
+	for i, r := range runes {
+		lowered[i] = unicode.ToLower(r)
+	}
+
+	weak := make([]bool, len(runes))
+	lowerPassword := string(lowered)
+	for _, word := range commonPasswordWords {
+		for i := 0; ; {
+			j := strings.Index(lowerPassword[i:], word)
+			if j < 0 {
+				break
+			}
+
+			start := utf8.RuneCountInString(lowerPassword[:i+j])
+			for k := 0; k < utf8.RuneCountInString(word); k++ {
+				weak[start+k] = true
+			}
+			i += j + len(word)
+		}
+	}
+
+	for i := 1; i < len(lowered); i++ {
+		diff := lowered[i] - lowered[i-1]
+		if diff == 0 || diff == 1 || diff == -1 || keyboardAdjacent(lowered[i-1], lowered[i]) {
+			weak[i] = true
+		}
+	}
+
+	perCharacter := math.Log2(float64(passwordPoolSize(runes)))
+
+	var bits float64
+	for i := range runes {
+		if weak[i] {
+			bits++
+		} else {
+			bits += perCharacter
+		}
+	}
+
+	return bits
+}
+
+func passwordPoolSize(runes []rune) int {
+	var lower, upper, digit, other, nonASCII bool
+	for _, r := range runes {
+		switch {
+		case r > unicode.MaxASCII:
+			nonASCII = true
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	size := 1
+	if lower {
+		size += 26
+	}
+
+	if upper {
+		size += 26
+	}
+
+	if digit {
+		size += 10
+	}
+
+	if other {
+		size += 33
+	}
+
+	if nonASCII {
+		size += 100
+	}
+
+	return size
+}
+
+func keyboardAdjacent(a rune, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		j := strings.IndexRune(row, b)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+
+	return false
+}
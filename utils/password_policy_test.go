@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func passwordRule(on bool) *bool {
+	return &on
+}
+
+type PasswordPolicyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PasswordPolicyTestSuite) TearDownTest() {
+	config.C.PasswordPolicy = config.PasswordPolicy{}
+	breachedPasswords = nil
+}
+
+func (suite *PasswordPolicyTestSuite) failedRules(password string) []string {
+	err := ValidatePassword(password)
+	if err == nil {
+		return nil
+	}
+
+	policyErr, ok := err.(*PasswordPolicyError)
+	suite.Require().True(ok)
+	suite.Require().Equal("password isn't strong enough", policyErr.Error())
+
+	var rules []string
+	for _, rule := range policyErr.Rules {
+		rules = append(rules, rule.Rule)
+	}
+
+	return rules
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_ValidatePassword() {
+	require := suite.Require()
+	config.C.PasswordPolicy = config.PasswordPolicy{
+		MinLength:         10,
+		MaxLength:         20,
+		RequireLower:      passwordRule(true),
+		RequireUpper:      passwordRule(true),
+		RequireDigit:      passwordRule(true),
+		RequireSpecial:    passwordRule(true),
+		SpecialCharacters: new(string),
+		MinScore:          2,
+	}
+
+	testCases := map[string]struct {
+		input    string
+		expected []string
+	}{
+		"Valid password": {
+			input:    "Horse~Battery9Staple",
+			expected: nil,
+		},
+		"Any symbol is special": {
+			input:    "Horse€Battery9Staple",
+			expected: nil,
+		},
+		"Every failed rule": {
+			input:    "aaaa",
+			expected: []string{PasswordRuleMinLength, PasswordRuleUpper, PasswordRuleDigit, PasswordRuleSpecial, PasswordRuleScore},
+		},
+		"Too long": {
+			input:    "Horse~Battery9Staple~Correct",
+			expected: []string{PasswordRuleMaxLength},
+		},
+		"Length counts characters": {
+			input:    "Wörter~Ärger9Öl",
+			expected: nil,
+		},
+		"Common word": {
+			input:    "Password12345!",
+			expected: []string{PasswordRuleScore},
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			require.Equal(v.expected, suite.failedRules(v.input))
+		})
+	}
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_OptionalRules() {
+	require := suite.Require()
+	config.C.PasswordPolicy = config.PasswordPolicy{MinLength: 4, RequireLower: passwordRule(false), RequireUpper: passwordRule(false), RequireDigit: passwordRule(false), RequireSpecial: passwordRule(false)}
+
+	require.Nil(suite.failedRules("aaaa"))
+	require.Equal([]string{PasswordRuleMinLength}, suite.failedRules("aaa"))
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_SpecialCharacters() {
+	require := suite.Require()
+	specialCharacters := "_"
+	config.C.PasswordPolicy = config.PasswordPolicy{
+		MinLength:         1,
+		RequireLower:      passwordRule(false),
+		RequireUpper:      passwordRule(false),
+		RequireDigit:      passwordRule(false),
+		RequireSpecial:    passwordRule(true),
+		SpecialCharacters: &specialCharacters,
+	}
+
+	require.Nil(suite.failedRules("a_b"))
+	require.Equal([]string{PasswordRuleSpecial}, suite.failedRules("a!b"))
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_LegacyRules() {
+	require := suite.Require()
+
+	require.Nil(suite.failedRules("Aaaaaaaa768!"))
+	require.Equal([]string{PasswordRuleSpecial}, suite.failedRules("Aaaaaaaa768~"))
+	require.Equal([]string{PasswordRuleMinLength, PasswordRuleUpper, PasswordRuleDigit, PasswordRuleSpecial}, suite.failedRules("aaa"))
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_PartialPolicy() {
+	require := suite.Require()
+
+	// Only the maximum length is configured, the other rules keep their
+	// original values.
+	config.C.PasswordPolicy = config.PasswordPolicy{MaxLength: 16}
+	require.Nil(suite.failedRules("Aaaaaaaa768!"))
+	require.Equal([]string{PasswordRuleMinLength, PasswordRuleUpper, PasswordRuleDigit, PasswordRuleSpecial}, suite.failedRules("aaa"))
+	require.Equal([]string{PasswordRuleMaxLength}, suite.failedRules("Aaaaaaaa768!Aaaaaaaa768!"))
+
+	// Turning one rule off leaves the others on.
+	config.C.PasswordPolicy = config.PasswordPolicy{RequireSpecial: passwordRule(false)}
+	require.Nil(suite.failedRules("Aaaaaaaa768"))
+	require.Equal([]string{PasswordRuleMinLength, PasswordRuleUpper, PasswordRuleDigit}, suite.failedRules("aaa"))
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_PasswordScore() {
+	require := suite.Require()
+	testCases := map[string]struct {
+		input    string
+		expected int
+	}{
+		"Empty":              {input: "", expected: 0},
+		"Common password":    {input: "password", expected: 0},
+		"Keyboard run":       {input: "qwertyuiop", expected: 0},
+		"Sequence":           {input: "abcdefghijkl", expected: 0},
+		"Repeated":           {input: "aaaaaaaaaaaaaaaa", expected: 0},
+		"Short and mixed":    {input: "Tr0b!x", expected: 1},
+		"Common with extra":  {input: "Password1234!", expected: 0},
+		"Repeated and mixed": {input: "Aaaaaaaa768!", expected: 1},
+		"Mixed":              {input: "Tr0ub4dor&3", expected: 3},
+		"Long and mixed":     {input: "Horse~Battery9Staple", expected: 4},
+		"Passphrase":         {input: "correct horse battery staple", expected: 4},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			require.Equal(v.expected, PasswordScore(v.input), fmt.Sprintf("%.1f bits", passwordEntropy(v.input)))
+		})
+	}
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_Breached() {
+	require := suite.Require()
+
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("breached%d", i)))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+
+	path := filepath.Join(suite.T().TempDir(), "breached.txt")
+	require.NoError(os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	config.C.PasswordPolicy = config.PasswordPolicy{MinLength: 1, BreachedList: path, RequireLower: passwordRule(false), RequireUpper: passwordRule(false), RequireDigit: passwordRule(false), RequireSpecial: passwordRule(false)}
+	require.NoError(InitBreachedPasswords())
+
+	for i := 0; i < 1000; i++ {
+		require.True(PasswordBreached(fmt.Sprintf("breached%d", i)))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if PasswordBreached(fmt.Sprintf("safe%d", i)) {
+			falsePositives++
+		}
+	}
+	require.Less(falsePositives, 50)
+
+	require.Equal([]string{PasswordRuleBreached}, suite.failedRules("breached7"))
+	require.Nil(suite.failedRules("safe-password"))
+}
+
+func (suite *PasswordPolicyTestSuite) TestPasswordPolicy_Breached_InvalidList() {
+	require := suite.Require()
+
+	path := filepath.Join(suite.T().TempDir(), "breached.txt")
+	require.NoError(os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\nnot a hash\n"), 0600))
+
+	config.C.PasswordPolicy = config.PasswordPolicy{MinLength: 1, BreachedList: path}
+	require.EqualError(InitBreachedPasswords(), "loading breached passwords failed: invalid SHA-1 hash on line 2")
+
+	config.C.PasswordPolicy.BreachedList = filepath.Join(suite.T().TempDir(), "missing.txt")
+	require.Error(InitBreachedPasswords())
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	suite.Run(t, new(PasswordPolicyTestSuite))
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-example/config"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	passwordResetKeyPrefix     = "password_reset"
+	passwordResetUserKeyPrefix = "password_reset_user"
+)
+
+var ErrPasswordResetTokenInvalid = errors.New("password reset token is invalid")
+
+// GeneratePasswordResetToken returns a new reset token for the user. Only its
+// hash is stored, and requesting a new token invalidates the previous one.
+func GeneratePasswordResetToken(ctx context.Context, redis *goredis.Client, userID uint) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("generating password reset token failed: %w", err)
+	}
+
+	hash := hashToken(token)
+	ttl := config.C.PasswordReset.TokenTTL
+
+	previous, err := redis.GetSet(ctx, passwordResetUserKey(userID), hash).Result()
+	if err != nil && err != goredis.Nil {
+		return "", err
+	}
+
+	pipe := redis.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, passwordResetKey(previous))
+	}
+	pipe.Expire(ctx, passwordResetUserKey(userID), ttl)
+	pipe.Set(ctx, passwordResetKey(hash), userID, ttl)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// LookupPasswordResetToken returns the user the token was issued for and
+// leaves the token valid.
+func LookupPasswordResetToken(ctx context.Context, redis *goredis.Client, token string) (uint, error) {
+	userID, err := redis.Get(ctx, passwordResetKey(hashToken(token))).Uint64()
+	if err == goredis.Nil {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(userID), nil
+}
+
+// ConsumePasswordResetToken returns the user the token was issued for and
+// makes sure it can't be used again.
+func ConsumePasswordResetToken(ctx context.Context, redis *goredis.Client, token string) (uint, error) {
+	hash := hashToken(token)
+
+	userID, err := redis.GetDel(ctx, passwordResetKey(hash)).Uint64()
+	if err == goredis.Nil {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	redis.Del(ctx, passwordResetUserKey(uint(userID)))
+
+	return uint(userID), nil
+}
+
+func passwordResetKey(hash string) string {
+	return fmt.Sprintf("%s:%s", passwordResetKeyPrefix, hash)
+}
+
+func passwordResetUserKey(userID uint) string {
+	return fmt.Sprintf("%s:%d", passwordResetUserKeyPrefix, userID)
+}
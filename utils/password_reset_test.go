@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"testing"
+	"time"
+)
+
+type PasswordResetTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *PasswordResetTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+	config.C.PasswordReset.TokenTTL = time.Minute
+}
+
+func (suite *PasswordResetTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+}
+
+func (suite *PasswordResetTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *PasswordResetTestSuite) TestPasswordReset_Consume_SingleUse() {
+	require := suite.Require()
+
+	token, err := GeneratePasswordResetToken(suite.ctx, suite.redisClient, 7)
+	require.NoError(err)
+	require.False(suite.redisServer.Exists(passwordResetKey(token)))
+
+	userID, err := ConsumePasswordResetToken(suite.ctx, suite.redisClient, token)
+	require.NoError(err)
+	require.Equal(uint(7), userID)
+
+	_, err = ConsumePasswordResetToken(suite.ctx, suite.redisClient, token)
+	require.Equal(ErrPasswordResetTokenInvalid, err)
+}
+
+func (suite *PasswordResetTestSuite) TestPasswordReset_Consume_Expired() {
+	require := suite.Require()
+
+	token, err := GeneratePasswordResetToken(suite.ctx, suite.redisClient, 7)
+	require.NoError(err)
+
+	suite.redisServer.FastForward(time.Minute + time.Second)
+
+	_, err = ConsumePasswordResetToken(suite.ctx, suite.redisClient, token)
+	require.Equal(ErrPasswordResetTokenInvalid, err)
+}
+
+func (suite *PasswordResetTestSuite) TestPasswordReset_Lookup_KeepsToken() {
+	require := suite.Require()
+
+	token, err := GeneratePasswordResetToken(suite.ctx, suite.redisClient, 7)
+	require.NoError(err)
+
+	userID, err := LookupPasswordResetToken(suite.ctx, suite.redisClient, token)
+	require.NoError(err)
+	require.Equal(uint(7), userID)
+
+	userID, err = ConsumePasswordResetToken(suite.ctx, suite.redisClient, token)
+	require.NoError(err)
+	require.Equal(uint(7), userID)
+
+	_, err = LookupPasswordResetToken(suite.ctx, suite.redisClient, token)
+	require.Equal(ErrPasswordResetTokenInvalid, err)
+}
+
+func (suite *PasswordResetTestSuite) TestPasswordReset_Generate_ReplacesPrevious() {
+	require := suite.Require()
+
+	first, err := GeneratePasswordResetToken(suite.ctx, suite.redisClient, 7)
+	require.NoError(err)
+
+	second, err := GeneratePasswordResetToken(suite.ctx, suite.redisClient, 7)
+	require.NoError(err)
+
+	_, err = ConsumePasswordResetToken(suite.ctx, suite.redisClient, first)
+	require.Equal(ErrPasswordResetTokenInvalid, err)
+
+	userID, err := ConsumePasswordResetToken(suite.ctx, suite.redisClient, second)
+	require.NoError(err)
+	require.Equal(uint(7), userID)
+}
+
+func TestPasswordReset(t *testing.T) {
+	suite.Run(t, new(PasswordResetTestSuite))
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/agiledragon/gomonkey/v2"
 	_ "github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/suite"
+	"golang-example/config"
 	"golang.org/x/crypto/bcrypt"
 	"testing"
 )
@@ -18,6 +19,10 @@ func (suite *PasswordTestSuite) SetupSuite() {
 	suite.patch = gomonkey.NewPatches()
 }
 
+func (suite *PasswordTestSuite) TearDownTest() {
+	suite.patch.Reset()
+}
+
 func (suite *PasswordTestSuite) TestPassword_ValidatePasswordPattern() {
 	require := suite.Require()
 	testCases := map[string]struct {
@@ -106,6 +111,140 @@ func (suite *PasswordTestSuite) TestPassword_VerifyPassword_Success() {
 	require.NoError(err)
 }
 
+func (suite *PasswordTestSuite) TestPassword_Argon2id() {
+	require := suite.Require()
+	config.C.PasswordHash = config.PasswordHash{
+		Algorithm: config.PasswordHashArgon2id,
+		Argon2id:  config.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	}
+	defer func() { config.C.PasswordHash = config.PasswordHash{} }()
+
+	hashed, err := HashPassword("Password1234!")
+	require.NoError(err)
+	require.Regexp(`^\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, hashed)
+
+	require.NoError(VerifyPassword(hashed, "Password1234!"))
+	require.Equal(bcrypt.ErrMismatchedHashAndPassword, VerifyPassword(hashed, "Password1234?"))
+	require.False(PasswordNeedsRehash(hashed))
+
+	other, err := HashPassword("Password1234!")
+	require.NoError(err)
+	require.NotEqual(hashed, other)
+}
+
+func (suite *PasswordTestSuite) TestPassword_Argon2id_Vector() {
+	require := suite.Require()
+
+	// from the reference implementation: "password" with the salt "somesalt"
+	hashed := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+
+	require.NoError(VerifyPassword(hashed, "password"))
+	require.Error(VerifyPassword(hashed, "Password"))
+}
+
+func (suite *PasswordTestSuite) TestPassword_VerifyPassword_InvalidArgon2id() {
+	require := suite.Require()
+
+	for _, hashed := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHQ",
+		"$argon2id$v=16$m=1024,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=0,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHQ$!!",
+	} {
+		require.Equal(errInvalidArgon2idHash, VerifyPassword(hashed, "password"), hashed)
+	}
+}
+
+func (suite *PasswordTestSuite) TestPassword_Bcrypt() {
+	require := suite.Require()
+	config.C.PasswordHash = config.PasswordHash{Algorithm: config.PasswordHashBcrypt, BcryptCost: bcrypt.MinCost}
+	defer func() { config.C.PasswordHash = config.PasswordHash{} }()
+
+	hashed, err := HashPassword("Password1234!")
+	require.NoError(err)
+	require.Regexp(`^\$2a\$04\$`, hashed)
+
+	require.NoError(VerifyPassword(hashed, "Password1234!"))
+	require.Error(VerifyPassword(hashed, "Password1234?"))
+	require.False(PasswordNeedsRehash(hashed))
+}
+
+func (suite *PasswordTestSuite) TestPassword_PasswordNeedsRehash() {
+	require := suite.Require()
+	defer func() { config.C.PasswordHash = config.PasswordHash{} }()
+
+	argon2idConfig := config.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	config.C.PasswordHash = config.PasswordHash{Algorithm: config.PasswordHashArgon2id, Argon2id: argon2idConfig}
+	argon2idHash, err := HashPassword("Password1234!")
+	require.NoError(err)
+
+	config.C.PasswordHash = config.PasswordHash{Algorithm: config.PasswordHashBcrypt, BcryptCost: bcrypt.MinCost}
+	bcryptHash, err := HashPassword("Password1234!")
+	require.NoError(err)
+
+	testCases := map[string]struct {
+		config   config.PasswordHash
+		hash     string
+		expected bool
+	}{
+		"Same argon2id parameters": {
+			config:   config.PasswordHash{Algorithm: config.PasswordHashArgon2id, Argon2id: argon2idConfig},
+			hash:     argon2idHash,
+			expected: false,
+		},
+		"Other salt length only": {
+			config: config.PasswordHash{Algorithm: config.PasswordHashArgon2id,
+				Argon2id: config.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32}},
+			hash:     argon2idHash,
+			expected: false,
+		},
+		"More argon2id memory": {
+			config: config.PasswordHash{Algorithm: config.PasswordHashArgon2id,
+				Argon2id: config.Argon2id{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}},
+			hash:     argon2idHash,
+			expected: true,
+		},
+		"More argon2id iterations": {
+			config: config.PasswordHash{Algorithm: config.PasswordHashArgon2id,
+				Argon2id: config.Argon2id{Memory: 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}},
+			hash:     argon2idHash,
+			expected: true,
+		},
+		"Argon2id to bcrypt": {
+			config:   config.PasswordHash{Algorithm: config.PasswordHashBcrypt, BcryptCost: bcrypt.MinCost},
+			hash:     argon2idHash,
+			expected: true,
+		},
+		"Same bcrypt cost": {
+			config:   config.PasswordHash{Algorithm: config.PasswordHashBcrypt, BcryptCost: bcrypt.MinCost},
+			hash:     bcryptHash,
+			expected: false,
+		},
+		"Higher bcrypt cost": {
+			config:   config.PasswordHash{Algorithm: config.PasswordHashBcrypt, BcryptCost: bcrypt.MinCost + 1},
+			hash:     bcryptHash,
+			expected: true,
+		},
+		"Bcrypt to argon2id": {
+			config:   config.PasswordHash{Algorithm: config.PasswordHashArgon2id, Argon2id: argon2idConfig},
+			hash:     bcryptHash,
+			expected: true,
+		},
+		"Unknown hash": {
+			config:   config.PasswordHash{Algorithm: config.PasswordHashBcrypt},
+			hash:     "bvuyrbvuyrbvyr",
+			expected: false,
+		},
+	}
+
+	for desc, v := range testCases {
+		suite.Run(desc, func() {
+			config.C.PasswordHash = v.config
+			require.Equal(v.expected, PasswordNeedsRehash(v.hash))
+		})
+	}
+}
+
 func TestPassword(t *testing.T) {
 	suite.Run(t, new(PasswordTestSuite))
 }
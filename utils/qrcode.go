@@ -0,0 +1,17 @@
+package utils
+
+import (
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QRCodePNG renders the content as a QR code PNG with the given number of
+// pixels per module, using error correction level M and the standard quiet
+// zone.
+func QRCodePNG(content string, scale int) ([]byte, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	return code.PNG(-scale)
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type QRCodeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *QRCodeTestSuite) TestQRCode_QRCodePNG() {
+	require := suite.Require()
+
+	b, err := QRCodePNG("hello", 4)
+	require.NoError(err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(err)
+	require.Equal((21+8)*4, img.Bounds().Dx())
+	require.Equal((21+8)*4, img.Bounds().Dy())
+
+	// The quiet zone is light and the top left finder pattern is dark.
+	r, _, _, _ := img.At(0, 0).RGBA()
+	require.Equal(uint32(0xffff), r)
+	r, _, _, _ = img.At(4*4, 4*4).RGBA()
+	require.Equal(uint32(0), r)
+}
+
+func (suite *QRCodeTestSuite) TestQRCode_OTPAuthURI() {
+	require := suite.Require()
+
+	uri := "otpauth://totp/golang-example:alice?algorithm=SHA1&digits=6&issuer=golang-example&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	b, err := QRCodePNG(uri, 1)
+	require.NoError(err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(err)
+	// Version 8 is 49 modules wide.
+	require.Equal(49+8, img.Bounds().Dx())
+}
+
+func (suite *QRCodeTestSuite) TestQRCode_TooLong() {
+	_, err := QRCodePNG(strings.Repeat("a", 3000), 4)
+	suite.Require().Error(err)
+}
+
+func TestQRCode(t *testing.T) {
+	suite.Run(t, new(QRCodeTestSuite))
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	refreshTokenKeyPrefix       = "refresh_token"
+	refreshTokenUsedKeyPrefix   = "refresh_token_used"
+	refreshTokenFamilyKeyPrefix = "refresh_token_family"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token is already used")
+)
+
+// RefreshTokenGrant is what the holder of a refresh token is entitled to.
+// SessionID is the login session the tokens belong to, if any. ClientID is
+// the OAuth client the tokens were issued to, empty for first party logins.
+type RefreshTokenGrant struct {
+	UserID    uint     `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	SessionID string   `json:"session_id,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+}
+
+type refreshTokenData struct {
+	RefreshTokenGrant
+	Family     string `json:"family"`
+	Generation int64  `json:"generation"`
+}
+
+// GenerateRefreshToken starts a new refresh token family for the grant and
+// returns its first token.
+func GenerateRefreshToken(ctx context.Context, redis *goredis.Client, grant RefreshTokenGrant) (string, error) {
+	family, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("generating refresh token failed: %w", err)
+	}
+
+	generation, err := TokenGeneration(ctx, redis, grant.UserID)
+	if err != nil {
+		return "", fmt.Errorf("generating refresh token failed: %w", err)
+	}
+
+	err = redis.Set(ctx, refreshTokenFamilyKey(family), grant.UserID, config.C.Token.RefreshExpiresIn).Err()
+	if err != nil {
+		return "", fmt.Errorf("generating refresh token failed: %w", err)
+	}
+
+	return issueRefreshToken(ctx, redis, refreshTokenData{RefreshTokenGrant: grant, Family: family, Generation: generation})
+}
+
+// RotateRefreshToken consumes the given refresh token and issues the next
+// one of the same family. Presenting a token which was already rotated
+// revokes the whole family, so a stolen token can be used at most once.
+// Only the client the family was issued to, or no client for first party
+// tokens, can rotate it.
+func RotateRefreshToken(ctx context.Context, redis *goredis.Client, token string, clientID string) (*RefreshTokenGrant, string, error) {
+	hash := hashToken(token)
+
+	data, err := getRefreshToken(ctx, redis, hash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if data.ClientID != clientID {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	generation, err := TokenGeneration(ctx, redis, data.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if data.Generation < generation {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	firstUse, err := redis.SetNX(ctx, refreshTokenUsedKey(hash), 1, config.C.Token.RefreshExpiresIn).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !firstUse {
+		if err = RevokeRefreshTokenFamily(ctx, redis, data.Family); err != nil {
+			return nil, "", err
+		}
+
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	alive, err := redis.Expire(ctx, refreshTokenFamilyKey(data.Family), config.C.Token.RefreshExpiresIn).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !alive {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	newToken, err := issueRefreshToken(ctx, redis, *data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &data.RefreshTokenGrant, newToken, nil
+}
+
+// LookupRefreshToken returns the grant of a refresh token and when it
+// expires without consuming the token. Tokens that were already rotated or
+// revoked are reported as ErrRefreshTokenInvalid.
+func LookupRefreshToken(ctx context.Context, redis *goredis.Client, token string) (*RefreshTokenGrant, int64, error) {
+	hash := hashToken(token)
+
+	data, err := getRefreshToken(ctx, redis, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	used, err := redis.Exists(ctx, refreshTokenUsedKey(hash)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if used != 0 {
+		return nil, 0, ErrRefreshTokenInvalid
+	}
+
+	alive, err := redis.Exists(ctx, refreshTokenFamilyKey(data.Family)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if alive == 0 {
+		return nil, 0, ErrRefreshTokenInvalid
+	}
+
+	generation, err := TokenGeneration(ctx, redis, data.UserID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if data.Generation < generation {
+		return nil, 0, ErrRefreshTokenInvalid
+	}
+
+	ttl, err := redis.TTL(ctx, refreshTokenKey(hash)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &data.RefreshTokenGrant, time.Now().Add(ttl).Unix(), nil
+}
+
+// RevokeRefreshToken invalidates the family the given refresh token belongs to.
+func RevokeRefreshToken(ctx context.Context, redis *goredis.Client, token string) error {
+	data, err := getRefreshToken(ctx, redis, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	return RevokeRefreshTokenFamily(ctx, redis, data.Family)
+}
+
+// RevokeRefreshTokenFamily invalidates every token issued within the family.
+func RevokeRefreshTokenFamily(ctx context.Context, redis *goredis.Client, family string) error {
+	return redis.Del(ctx, refreshTokenFamilyKey(family)).Err()
+}
+
+func getRefreshToken(ctx context.Context, redis *goredis.Client, hash string) (*refreshTokenData, error) {
+	value, err := redis.Get(ctx, refreshTokenKey(hash)).Result()
+	if err == goredis.Nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var data refreshTokenData
+	if err = json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	return &data, nil
+}
+
+func issueRefreshToken(ctx context.Context, redis *goredis.Client, data refreshTokenData) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("generating refresh token failed: %w", err)
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("generating refresh token failed: %w", err)
+	}
+
+	err = redis.Set(ctx, refreshTokenKey(hashToken(token)), value, config.C.Token.RefreshExpiresIn).Err()
+	if err != nil {
+		return "", fmt.Errorf("generating refresh token failed: %w", err)
+	}
+
+	return token, nil
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a random secret, like a token or a key, for storage and
+// lookup. Secrets this random can't be guessed from a fast hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func refreshTokenKey(hash string) string {
+	return fmt.Sprintf("%s:%s", refreshTokenKeyPrefix, hash)
+}
+
+func refreshTokenUsedKey(hash string) string {
+	return fmt.Sprintf("%s:%s", refreshTokenUsedKeyPrefix, hash)
+}
+
+func refreshTokenFamilyKey(family string) string {
+	return fmt.Sprintf("%s:%s", refreshTokenFamilyKeyPrefix, family)
+}
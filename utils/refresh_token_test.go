@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"testing"
+	"time"
+)
+
+type RefreshTokenTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *RefreshTokenTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+	config.C = config.Config{
+		Token: config.Token{
+			ExpiresIn:        time.Minute,
+			RefreshExpiresIn: time.Hour,
+			Secret:           "secret",
+		},
+	}
+}
+
+func (suite *RefreshTokenTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+}
+
+func (suite *RefreshTokenTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *RefreshTokenTestSuite) TestRefreshToken_Rotate_Success() {
+	require := suite.Require()
+
+	token, err := GenerateRefreshToken(suite.ctx, suite.redisClient, RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	grant, newToken, err := RotateRefreshToken(suite.ctx, suite.redisClient, token, "")
+	require.NoError(err)
+	require.Equal(uint(1), grant.UserID)
+	require.Equal([]string{"metas:read"}, grant.Scopes)
+	require.NotEqual(token, newToken)
+
+	grant, _, err = RotateRefreshToken(suite.ctx, suite.redisClient, newToken, "")
+	require.NoError(err)
+	require.Equal(uint(1), grant.UserID)
+}
+
+func (suite *RefreshTokenTestSuite) TestRefreshToken_Rotate_UnknownToken_Failure() {
+	require := suite.Require()
+
+	_, _, err := RotateRefreshToken(suite.ctx, suite.redisClient, "unknown", "")
+	require.Equal(ErrRefreshTokenInvalid, err)
+}
+
+func (suite *RefreshTokenTestSuite) TestRefreshToken_Rotate_Expired_Failure() {
+	require := suite.Require()
+
+	token, err := GenerateRefreshToken(suite.ctx, suite.redisClient, RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	suite.redisServer.FastForward(2 * time.Hour)
+
+	_, _, err = RotateRefreshToken(suite.ctx, suite.redisClient, token, "")
+	require.Equal(ErrRefreshTokenInvalid, err)
+}
+
+func (suite *RefreshTokenTestSuite) TestRefreshToken_Rotate_OtherClient_Failure() {
+	require := suite.Require()
+
+	token, err := GenerateRefreshToken(suite.ctx, suite.redisClient, RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}, ClientID: "client"})
+	require.NoError(err)
+
+	_, _, err = RotateRefreshToken(suite.ctx, suite.redisClient, token, "")
+	require.Equal(ErrRefreshTokenInvalid, err)
+
+	_, _, err = RotateRefreshToken(suite.ctx, suite.redisClient, token, "other")
+	require.Equal(ErrRefreshTokenInvalid, err)
+
+	// Rejected attempts don't consume the token.
+	grant, _, err := RotateRefreshToken(suite.ctx, suite.redisClient, token, "client")
+	require.NoError(err)
+	require.Equal("client", grant.ClientID)
+}
+
+func (suite *RefreshTokenTestSuite) TestRefreshToken_Rotate_Reuse_RevokesFamily() {
+	require := suite.Require()
+
+	token, err := GenerateRefreshToken(suite.ctx, suite.redisClient, RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	_, newToken, err := RotateRefreshToken(suite.ctx, suite.redisClient, token, "")
+	require.NoError(err)
+
+	_, _, err = RotateRefreshToken(suite.ctx, suite.redisClient, token, "")
+	require.Equal(ErrRefreshTokenReused, err)
+
+	_, _, err = RotateRefreshToken(suite.ctx, suite.redisClient, newToken, "")
+	require.Equal(ErrRefreshTokenInvalid, err)
+}
+
+func (suite *RefreshTokenTestSuite) TestRefreshToken_Lookup() {
+	require := suite.Require()
+
+	token, err := GenerateRefreshToken(suite.ctx, suite.redisClient, RefreshTokenGrant{UserID: 1, Scopes: []string{"metas:read"}})
+	require.NoError(err)
+
+	grant, expiresAt, err := LookupRefreshToken(suite.ctx, suite.redisClient, token)
+	require.NoError(err)
+	require.Equal(uint(1), grant.UserID)
+	require.Equal([]string{"metas:read"}, grant.Scopes)
+	require.InDelta(time.Now().Add(time.Hour).Unix(), expiresAt, 2)
+
+	_, newToken, err := RotateRefreshToken(suite.ctx, suite.redisClient, token, "")
+	require.NoError(err)
+
+	_, _, err = LookupRefreshToken(suite.ctx, suite.redisClient, token)
+	require.Equal(ErrRefreshTokenInvalid, err)
+
+	_, _, err = LookupRefreshToken(suite.ctx, suite.redisClient, newToken)
+	require.NoError(err)
+
+	require.NoError(RevokeUserTokens(suite.ctx, suite.redisClient, 1))
+
+	_, _, err = LookupRefreshToken(suite.ctx, suite.redisClient, newToken)
+	require.Equal(ErrRefreshTokenInvalid, err)
+}
+
+func TestRefreshToken(t *testing.T) {
+	suite.Run(t, new(RefreshTokenTestSuite))
+}
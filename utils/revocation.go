@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	revokedTokenKeyPrefix    = "revoked_token"
+	tokenGenerationKeyPrefix = "token_generation"
+)
+
+var ErrTokenRevoked = errors.New("token is revoked")
+
+// RevokeToken puts the token id on the denylist until the token expires on
+// its own.
+func RevokeToken(ctx context.Context, redis *goredis.Client, jti string, expiresAt int64) error {
+	ttl := time.Until(time.Unix(expiresAt, 0))
+	if ttl <= 0 {
+		return nil
+	}
+
+	return redis.Set(ctx, revokedTokenKey(jti), 1, ttl).Err()
+}
+
+// RevokeUserTokens bumps the token generation of the user, which rejects
+// every token issued for the user before the call, and ends the sessions
+// of the user.
+func RevokeUserTokens(ctx context.Context, redis *goredis.Client, userID uint) error {
+	if err := redis.Incr(ctx, tokenGenerationKey(userID)).Err(); err != nil {
+		return err
+	}
+
+	return DeleteUserSessions(ctx, redis, userID)
+}
+
+// TokenGeneration returns the current token generation of the user. New
+// tokens have to carry it to be accepted.
+func TokenGeneration(ctx context.Context, redis *goredis.Client, userID uint) (int64, error) {
+	generation, err := redis.Get(ctx, tokenGenerationKey(userID)).Int64()
+	if err == goredis.Nil {
+		return 0, nil
+	}
+
+	return generation, err
+}
+
+// CheckTokenRevoked returns ErrTokenRevoked when the token was logged out,
+// belongs to an older token generation of its user or to a session that
+// was ended.
+func CheckTokenRevoked(ctx context.Context, redis *goredis.Client, claims *jwtClaim) error {
+	revoked, err := redis.Exists(ctx, revokedTokenKey(claims.Id)).Result()
+	if err != nil {
+		return err
+	}
+
+	if revoked != 0 {
+		return ErrTokenRevoked
+	}
+
+	generation, err := TokenGeneration(ctx, redis, claims.ID)
+	if err != nil {
+		return err
+	}
+
+	if claims.Generation < generation {
+		return ErrTokenRevoked
+	}
+
+	if claims.SessionID != "" {
+		exists, err := SessionExists(ctx, redis, claims.ID, claims.SessionID)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrTokenRevoked
+		}
+	}
+
+	return nil
+}
+
+func revokedTokenKey(jti string) string {
+	return fmt.Sprintf("%s:%s", revokedTokenKeyPrefix, jti)
+}
+
+func tokenGenerationKey(userID uint) string {
+	return fmt.Sprintf("%s:%d", tokenGenerationKeyPrefix, userID)
+}
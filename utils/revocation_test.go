@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/suite"
+	"golang-example/database"
+	"testing"
+	"time"
+)
+
+type RevocationTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *RevocationTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+}
+
+func (suite *RevocationTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+}
+
+func (suite *RevocationTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *RevocationTestSuite) claims() *jwtClaim {
+	return &jwtClaim{
+		ID: 1,
+		StandardClaims: jwt.StandardClaims{
+			Id:        "jti",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+}
+
+func (suite *RevocationTestSuite) TestRevocation_CheckTokenRevoked_NotRevoked() {
+	require := suite.Require()
+
+	err := CheckTokenRevoked(suite.ctx, suite.redisClient, suite.claims())
+	require.NoError(err)
+}
+
+func (suite *RevocationTestSuite) TestRevocation_RevokeToken() {
+	require := suite.Require()
+	claims := suite.claims()
+
+	err := RevokeToken(suite.ctx, suite.redisClient, claims.Id, claims.ExpiresAt)
+	require.NoError(err)
+	require.True(suite.redisServer.Exists("revoked_token:jti"))
+
+	err = CheckTokenRevoked(suite.ctx, suite.redisClient, claims)
+	require.Equal(ErrTokenRevoked, err)
+
+	suite.redisServer.FastForward(2 * time.Minute)
+	require.False(suite.redisServer.Exists("revoked_token:jti"))
+}
+
+func (suite *RevocationTestSuite) TestRevocation_RevokeToken_AlreadyExpired() {
+	require := suite.Require()
+
+	err := RevokeToken(suite.ctx, suite.redisClient, "jti", time.Now().Add(-time.Minute).Unix())
+	require.NoError(err)
+	require.False(suite.redisServer.Exists("revoked_token:jti"))
+}
+
+func (suite *RevocationTestSuite) TestRevocation_RevokeUserTokens() {
+	require := suite.Require()
+	claims := suite.claims()
+
+	err := RevokeUserTokens(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+
+	err = CheckTokenRevoked(suite.ctx, suite.redisClient, claims)
+	require.Equal(ErrTokenRevoked, err)
+
+	claims.Generation, err = TokenGeneration(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Equal(int64(1), claims.Generation)
+
+	err = CheckTokenRevoked(suite.ctx, suite.redisClient, claims)
+	require.NoError(err)
+}
+
+func TestRevocation(t *testing.T) {
+	suite.Run(t, new(RevocationTestSuite))
+}
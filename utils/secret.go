@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"strings"
+)
+
+const encryptedSecretVersion = "v1:"
+
+var ErrSecretDecryption = errors.New("secret could not be decrypted")
+
+// EncryptSecret encrypts a secret to be stored at rest with AES-256-GCM under
+// the configured MFA encryption key. The result is prefixed with a version
+// so the scheme can be changed later.
+func EncryptSecret(plaintext string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("encrypting secret failed: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return encryptedSecretVersion + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret.
+func DecryptSecret(ciphertext string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(ciphertext, encryptedSecretVersion) {
+		return "", ErrSecretDecryption
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(ciphertext, encryptedSecretVersion))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrSecretDecryption
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrSecretDecryption
+	}
+
+	return string(plaintext), nil
+}
+
+// InitSecretKey checks the MFA encryption key, so TOTP secrets are never
+// stored unless they can be encrypted.
+func InitSecretKey() error {
+	_, err := secretCipher()
+	return err
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	if config.C.MFA.EncryptionKey == "" {
+		return nil, errors.New("mfa encryption key is not configured")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.C.MFA.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("mfa encryption key must be 32 bytes encoded in base64")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-example/config"
+	"sort"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	sessionKeyPrefix      = "session"
+	userSessionsKeyPrefix = "user_sessions"
+
+	// sessionTouchInterval is how often the last seen time of a session is
+	// written while the same token is in use.
+	sessionTouchInterval = time.Minute
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login of a user on a device. It lives as long as its refresh
+// tokens, and TokenID is the id (jti) of the last access token it used.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    uint      `json:"user_id"`
+	TokenID   string    `json:"token_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// CreateSession records a new session of the user.
+func CreateSession(ctx context.Context, redis *goredis.Client, userID uint, ip string, userAgent string) (*Session, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("generating session failed: %w", err)
+	}
+
+	now := time.Now()
+	session := &Session{ID: id, UserID: userID, IP: ip, UserAgent: userAgent, CreatedAt: now, LastSeen: now}
+	if err = saveSession(ctx, redis, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// TouchSession marks the session as seen with the access token jti. It
+// returns ErrSessionNotFound once the session was revoked or expired.
+func TouchSession(ctx context.Context, redis *goredis.Client, userID uint, id string, jti string) error {
+	session, err := getSession(ctx, redis, userID, id)
+	if err != nil {
+		return err
+	}
+
+	if session.TokenID == jti && time.Since(session.LastSeen) < sessionTouchInterval {
+		return nil
+	}
+
+	session.TokenID = jti
+	session.LastSeen = time.Now()
+
+	return updateSession(ctx, redis, session)
+}
+
+// SessionExists reports whether the session of the user is still alive.
+func SessionExists(ctx context.Context, redis *goredis.Client, userID uint, id string) (bool, error) {
+	_, err := getSession(ctx, redis, userID, id)
+	if err == ErrSessionNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// UserSessions returns the live sessions of the user, the most recently
+// seen first.
+func UserSessions(ctx context.Context, redis *goredis.Client, userID uint) ([]Session, error) {
+	ids, err := redis.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := getSession(ctx, redis, userID, id)
+		if err == ErrSessionNotFound {
+			redis.SRem(ctx, userSessionsKey(userID), id)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, *session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+
+	return sessions, nil
+}
+
+// DeleteSession ends the session of the user and returns it, so the last
+// access token it used can be revoked.
+func DeleteSession(ctx context.Context, redis *goredis.Client, userID uint, id string) (*Session, error) {
+	session, err := getSession(ctx, redis, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := redis.TxPipeline()
+	pipe.Del(ctx, sessionKey(id))
+	pipe.SRem(ctx, userSessionsKey(userID), id)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// DeleteUserSessions ends every session of the user.
+func DeleteUserSessions(ctx context.Context, redis *goredis.Client, userID uint) error {
+	ids, err := redis.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{userSessionsKey(userID)}
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+
+	return redis.Del(ctx, keys...).Err()
+}
+
+func getSession(ctx context.Context, redis *goredis.Client, userID uint, id string) (*Session, error) {
+	value, err := redis.Get(ctx, sessionKey(id)).Result()
+	if err == goredis.Nil {
+		return nil, ErrSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err = json.Unmarshal([]byte(value), &session); err != nil || session.UserID != userID {
+		return nil, ErrSessionNotFound
+	}
+
+	return &session, nil
+}
+
+func saveSession(ctx context.Context, redis *goredis.Client, session *Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("saving session failed: %w", err)
+	}
+
+	ttl := config.C.Token.RefreshExpiresIn
+
+	pipe := redis.TxPipeline()
+	pipe.Set(ctx, sessionKey(session.ID), value, ttl)
+	pipe.SAdd(ctx, userSessionsKey(session.UserID), session.ID)
+	pipe.Expire(ctx, userSessionsKey(session.UserID), ttl)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+// updateSession writes the session only if it still exists, so a session
+// revoked while it was being touched isn't brought back.
+func updateSession(ctx context.Context, redis *goredis.Client, session *Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("saving session failed: %w", err)
+	}
+
+	ttl := config.C.Token.RefreshExpiresIn
+
+	ok, err := redis.SetXX(ctx, sessionKey(session.ID), value, ttl).Result()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	return redis.Expire(ctx, userSessionsKey(session.UserID), ttl).Err()
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("%s:%s", sessionKeyPrefix, id)
+}
+
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("%s:%d", userSessionsKeyPrefix, userID)
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/suite"
+	"golang-example/config"
+	"golang-example/database"
+	"testing"
+	"time"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	redisServer *miniredis.Miniredis
+	redisClient *goredis.Client
+}
+
+func (suite *SessionTestSuite) SetupSuite() {
+	server, client := database.NewRedisMock()
+
+	suite.ctx = context.Background()
+	suite.redisServer = server
+	suite.redisClient = client
+	config.C.Token.RefreshExpiresIn = time.Hour
+}
+
+func (suite *SessionTestSuite) SetupTest() {
+	suite.redisClient.FlushAll(suite.ctx)
+}
+
+func (suite *SessionTestSuite) TearDownSuite() {
+	suite.redisServer.Close()
+}
+
+func (suite *SessionTestSuite) TestSession_CreateAndList() {
+	require := suite.Require()
+
+	first, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+	require.NotEmpty(first.ID)
+
+	second, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.2", "Firefox")
+	require.NoError(err)
+
+	_, err = CreateSession(suite.ctx, suite.redisClient, 2, "10.0.0.3", "Chrome")
+	require.NoError(err)
+
+	// Touching the first session makes it the most recently seen one.
+	first.LastSeen = first.LastSeen.Add(-time.Hour)
+	require.NoError(saveSession(suite.ctx, suite.redisClient, first))
+	second.LastSeen = second.LastSeen.Add(-2 * time.Hour)
+	require.NoError(saveSession(suite.ctx, suite.redisClient, second))
+	require.NoError(TouchSession(suite.ctx, suite.redisClient, 1, first.ID, "jti"))
+
+	sessions, err := UserSessions(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Len(sessions, 2)
+	require.Equal(first.ID, sessions[0].ID)
+	require.Equal("jti", sessions[0].TokenID)
+	require.Equal("10.0.0.1", sessions[0].IP)
+	require.Equal("curl/8.0", sessions[0].UserAgent)
+	require.Equal(second.ID, sessions[1].ID)
+}
+
+func (suite *SessionTestSuite) TestSession_Touch_OtherUser() {
+	require := suite.Require()
+
+	session, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	err = TouchSession(suite.ctx, suite.redisClient, 2, session.ID, "jti")
+	require.Equal(ErrSessionNotFound, err)
+}
+
+func (suite *SessionTestSuite) TestSession_Touch_Revoked() {
+	require := suite.Require()
+
+	session, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	// The session is revoked after a touch read it and before it is written.
+	touched, err := getSession(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+
+	_, err = DeleteSession(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+
+	touched.TokenID = "jti"
+	require.Equal(ErrSessionNotFound, updateSession(suite.ctx, suite.redisClient, touched))
+
+	exists, err := SessionExists(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+	require.False(exists)
+
+	members, err := suite.redisClient.SMembers(suite.ctx, userSessionsKey(1)).Result()
+	require.NoError(err)
+	require.Empty(members)
+}
+
+func (suite *SessionTestSuite) TestSession_Expires() {
+	require := suite.Require()
+
+	session, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	suite.redisServer.FastForward(time.Hour + time.Second)
+
+	exists, err := SessionExists(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+	require.False(exists)
+
+	sessions, err := UserSessions(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Empty(sessions)
+}
+
+func (suite *SessionTestSuite) TestSession_Delete() {
+	require := suite.Require()
+
+	session, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	other, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.2", "Firefox")
+	require.NoError(err)
+
+	_, err = DeleteSession(suite.ctx, suite.redisClient, 2, session.ID)
+	require.Equal(ErrSessionNotFound, err)
+
+	deleted, err := DeleteSession(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+	require.Equal(session.ID, deleted.ID)
+
+	_, err = DeleteSession(suite.ctx, suite.redisClient, 1, session.ID)
+	require.Equal(ErrSessionNotFound, err)
+
+	sessions, err := UserSessions(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Len(sessions, 1)
+	require.Equal(other.ID, sessions[0].ID)
+}
+
+func (suite *SessionTestSuite) TestSession_RevokeUserTokens() {
+	require := suite.Require()
+
+	session, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	require.NoError(RevokeUserTokens(suite.ctx, suite.redisClient, 1))
+
+	sessions, err := UserSessions(suite.ctx, suite.redisClient, 1)
+	require.NoError(err)
+	require.Empty(sessions)
+
+	exists, err := SessionExists(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+	require.False(exists)
+}
+
+func (suite *SessionTestSuite) TestSession_CheckTokenRevoked() {
+	require := suite.Require()
+
+	session, err := CreateSession(suite.ctx, suite.redisClient, 1, "10.0.0.1", "curl/8.0")
+	require.NoError(err)
+
+	claims := &jwtClaim{
+		ID:        1,
+		SessionID: session.ID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        "jti",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+	require.NoError(CheckTokenRevoked(suite.ctx, suite.redisClient, claims))
+
+	_, err = DeleteSession(suite.ctx, suite.redisClient, 1, session.ID)
+	require.NoError(err)
+	require.Equal(ErrTokenRevoked, CheckTokenRevoked(suite.ctx, suite.redisClient, claims))
+}
+
+func TestSession(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
@@ -4,26 +4,85 @@ import (
 	"errors"
 	"fmt"
 	"golang-example/config"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
 type jwtClaim struct {
-	ID uint `json:"id"`
+	ID         uint     `json:"id"`
+	Generation int64    `json:"gen"`
+	Roles      []string `json:"roles,omitempty"`
+	Scope      string   `json:"scope,omitempty"`
+	ClientID   string   `json:"client_id,omitempty"`
+	Purpose    string   `json:"purpose,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	SessionID  string   `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(id uint) (string, error) {
+// TokenParams describes the subject of a new access token. ClientID is set
+// when the token is issued to an OAuth client instead of the user itself.
+// Tokens with a Purpose are not access tokens, they are only accepted by
+// ValidatePurposeToken. ExpiresIn overrides the configured lifetime and
+// Email binds a purpose token to an address. SessionID ties the token to a
+// login session, which rejects it once the session is revoked.
+type TokenParams struct {
+	UserID     uint
+	Generation int64
+	Roles      []string
+	Scopes     []string
+	ClientID   string
+	Purpose    string
+	ExpiresIn  time.Duration
+	Email      string
+	SessionID  string
+}
+
+// Scopes returns the scopes granted to the token.
+func (c *jwtClaim) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func GenerateToken(params TokenParams) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("generating JWT Token failed: %w", err)
+	}
+
+	expiresIn := config.C.Token.ExpiresIn
+	if params.ExpiresIn != 0 {
+		expiresIn = params.ExpiresIn
+	}
+
 	claims := &jwtClaim{
-		ID: id,
+		ID:         params.UserID,
+		Generation: params.Generation,
+		Roles:      params.Roles,
+		Scope:      strings.Join(params.Scopes, " "),
+		ClientID:   params.ClientID,
+		Purpose:    params.Purpose,
+		Email:      params.Email,
+		SessionID:  params.SessionID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(config.C.Token.ExpiresIn).Unix(),
+			Id:        jti,
+			ExpiresAt: time.Now().Add(expiresIn).Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(config.C.Token.Secret))
+	var token *jwt.Token
+	var key interface{}
+	if tokenKeys != nil && tokenKeys.signing != nil {
+		token = jwt.NewWithClaims(tokenKeys.signing.method, claims)
+		token.Header["kid"] = tokenKeys.signing.id
+		key = tokenKeys.signing.privateKey
+	} else {
+		token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		key = []byte(config.C.Token.Secret)
+	}
+
+	tokenString, err := token.SignedString(key)
 
 	if err != nil {
 		return "", fmt.Errorf("generating JWT Token failed: %w", err)
@@ -32,27 +91,70 @@ func GenerateToken(id uint) (string, error) {
 	return tokenString, nil
 }
 
-func ValidateToken(signedToken string) (uint, error) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&jwtClaim{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(config.C.Token.Secret), nil
-		},
-	)
+// ValidateToken validates an access token.
+func ValidateToken(signedToken string) (*jwtClaim, error) {
+	return validateToken(signedToken, "")
+}
+
+// ValidatePurposeToken validates a token issued for the given purpose.
+func ValidatePurposeToken(signedToken string, purpose string) (*jwtClaim, error) {
+	return validateToken(signedToken, purpose)
+}
+
+func validateToken(signedToken string, purpose string) (*jwtClaim, error) {
+	token, err := jwt.ParseWithClaims(signedToken, &jwtClaim{}, verificationKey)
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(*jwtClaim)
 	if !ok {
-		return 0, errors.New("couldn't parse claims")
+		return nil, errors.New("couldn't parse claims")
 	}
 
 	if claims.ExpiresAt < time.Now().Local().Unix() {
-		return 0, errors.New("token expired")
+		return nil, errors.New("token expired")
+	}
+
+	if claims.Id == "" {
+		return nil, errors.New("token has no id")
+	}
+
+	if claims.Purpose != purpose {
+		return nil, errors.New("token is not meant for this use")
+	}
+
+	return claims, nil
+}
+
+// verificationKey picks the key a token has to be verified with. Tokens with
+// a kid header are checked against the configured asymmetric keys, the
+// others against the shared secret as long as no asymmetric signing key is
+// in use. The algorithm is bound to the key so a token can't choose how it
+// is verified.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		if _, ok = token.Method.(*jwt.SigningMethodHMAC); !ok || (tokenKeys != nil && tokenKeys.signing != nil) {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+
+		return []byte(config.C.Token.Secret), nil
+	}
+
+	if tokenKeys == nil {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+
+	key, ok := tokenKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
 	}
 
-	return claims.ID, nil
+	return key.publicKey, nil
 }
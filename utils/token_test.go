@@ -30,13 +30,17 @@ func (suite *TokenTestSuite) SetupSuite() {
 	}
 }
 
+func (suite *TokenTestSuite) TearDownTest() {
+	suite.patch.Reset()
+}
+
 func (suite *TokenTestSuite) TestToken_GenerateToken_Failure() {
 	require := suite.Require()
 	expectedErr := "generating JWT Token failed: error"
 
 	suite.patch.ApplyMethodReturn(suite.Token, "SignedString", "", errors.New("error"))
 
-	_, err := GenerateToken(1)
+	_, err := GenerateToken(TokenParams{UserID: 1})
 	require.Equal(expectedErr, err.Error())
 }
 
@@ -46,7 +50,7 @@ func (suite *TokenTestSuite) TestToken_GenerateToken_Success() {
 
 	suite.patch.ApplyMethodReturn(suite.Token, "SignedString", "bevyb4v7346vb74bvycbc6734g674bc", nil)
 
-	token, err := GenerateToken(1)
+	token, err := GenerateToken(TokenParams{UserID: 1})
 	require.NoError(err)
 	require.Equal(expectedToken, token)
 }
@@ -66,11 +70,34 @@ func (suite *TokenTestSuite) TestToken_ValidateToken_Failure() {
 func (suite *TokenTestSuite) TestToken_ValidateToken_Success() {
 	require := suite.Require()
 
-	token, err := GenerateToken(1)
+	token, err := GenerateToken(TokenParams{UserID: 1, Scopes: []string{"metas:read", "metas:write"}})
+
+	claims, err := ValidateToken(token)
+	require.NoError(err)
+	require.Equal(uint(1), claims.ID)
+	require.Equal([]string{"metas:read", "metas:write"}, claims.Scopes())
+	require.NotEmpty(claims.Id)
+}
+
+func (suite *TokenTestSuite) TestToken_ValidatePurposeToken() {
+	require := suite.Require()
 
-	id, err := ValidateToken(token)
+	token, err := GenerateToken(TokenParams{UserID: 1, Purpose: TokenPurposeMFAPending, ExpiresIn: 2 * time.Minute})
 	require.NoError(err)
-	require.Equal(uint(1), id)
+
+	_, err = ValidateToken(token)
+	require.Error(err)
+
+	claims, err := ValidatePurposeToken(token, TokenPurposeMFAPending)
+	require.NoError(err)
+	require.Equal(uint(1), claims.ID)
+	require.InDelta(time.Now().Add(2*time.Minute).Unix(), claims.ExpiresAt, 2)
+
+	accessToken, err := GenerateToken(TokenParams{UserID: 1})
+	require.NoError(err)
+
+	_, err = ValidatePurposeToken(accessToken, TokenPurposeMFAPending)
+	require.Error(err)
 }
 
 func TestToken(t *testing.T) {